@@ -0,0 +1,88 @@
+package modbus
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Data table of a Modicon-style reference, i.e. its leading digit.
+type ModiconTable uint
+
+const (
+	COILS_TABLE             ModiconTable = 0 // 0x references (00001-09999)
+	DISCRETE_INPUTS_TABLE   ModiconTable = 1 // 1x references (10001-19999)
+	INPUT_REGISTERS_TABLE   ModiconTable = 3 // 3x references (30001-39999)
+	HOLDING_REGISTERS_TABLE ModiconTable = 4 // 4x references (40001-49999)
+)
+
+// Object address in the classic Modicon notation (e.g. 40001 for the first
+// holding register), split into the data table and the 0-based protocol
+// address used on the wire (e.g. HOLDING_REGISTERS_TABLE and 0).
+type RegisterAddress struct {
+	Table ModiconTable
+	Addr  uint16
+}
+
+// Parses a Modicon-style reference, in either the 5-digit form (e.g. 40001,
+// offsets 1 to 9999) or the 6-digit form (e.g. 400001, offsets 1 to 65536).
+// The form is told apart by the number of digits, so that leading zeros of
+// coil references matter: 000100 is the 100th coil in the 6-digit form.
+func ParseRegisterAddress(ref string) (ra RegisterAddress, err error) {
+	var offset uint64
+
+	if len(ref) != 5 && len(ref) != 6 {
+		err = fmt.Errorf("%w: reference '%s' should have 5 or 6 digits",
+			ErrUnexpectedParameters, ref)
+		return
+	}
+
+	for _, c := range ref {
+		if c < '0' || c > '9' {
+			err = fmt.Errorf("%w: invalid reference '%s'",
+				ErrUnexpectedParameters, ref)
+			return
+		}
+	}
+
+	ra.Table = ModiconTable(ref[0] - '0')
+	if ra.Table != COILS_TABLE && ra.Table != DISCRETE_INPUTS_TABLE &&
+		ra.Table != INPUT_REGISTERS_TABLE && ra.Table != HOLDING_REGISTERS_TABLE {
+		err = fmt.Errorf("%w: unknown data table in reference '%s'",
+			ErrUnexpectedParameters, ref)
+		return
+	}
+
+	// references are 1-based
+	offset, _ = strconv.ParseUint(ref[1:], 10, 32)
+	if offset == 0 || offset > 0x10000 {
+		err = fmt.Errorf("%w: offset out of range in reference '%s'",
+			ErrUnexpectedParameters, ref)
+		return
+	}
+	ra.Addr = uint16(offset - 1)
+
+	return
+}
+
+// Returns the Modicon-style reference of the address, in the 5-digit form
+// if the address allows it or in the 6-digit form otherwise.
+func (ra RegisterAddress) String() string {
+	if ra.Addr < 9999 {
+		return fmt.Sprintf("%d%04d", ra.Table, uint32(ra.Addr)+1)
+	}
+
+	return fmt.Sprintf("%d%05d", ra.Table, uint32(ra.Addr)+1)
+}
+
+// Returns the register type matching the data table of the address, or false
+// if the address refers to a coil or discrete input.
+func (ra RegisterAddress) RegType() (RegType, bool) {
+	switch ra.Table {
+	case HOLDING_REGISTERS_TABLE:
+		return HOLDING_REGISTER, true
+	case INPUT_REGISTERS_TABLE:
+		return INPUT_REGISTER, true
+	}
+
+	return 0, false
+}
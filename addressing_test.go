@@ -0,0 +1,89 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRegisterAddress(t *testing.T) {
+	for _, tc := range []struct {
+		ref   string
+		table ModiconTable
+		addr  uint16
+		str   string
+	}{
+		{"00001", COILS_TABLE, 0, "00001"},
+		{"09999", COILS_TABLE, 9998, "09999"},
+		{"10001", DISCRETE_INPUTS_TABLE, 0, "10001"},
+		{"30100", INPUT_REGISTERS_TABLE, 99, "30100"},
+		{"40001", HOLDING_REGISTERS_TABLE, 0, "40001"},
+		{"400001", HOLDING_REGISTERS_TABLE, 0, "40001"},
+		{"000100", COILS_TABLE, 99, "00100"},
+		{"410000", HOLDING_REGISTERS_TABLE, 9999, "410000"},
+		{"465536", HOLDING_REGISTERS_TABLE, 0xffff, "465536"},
+	} {
+		ra, err := ParseRegisterAddress(tc.ref)
+		if err != nil {
+			t.Errorf("%s: ParseRegisterAddress() should have succeeded, got %v",
+				tc.ref, err)
+			continue
+		}
+		if ra.Table != tc.table || ra.Addr != tc.addr {
+			t.Errorf("%s: unexpected address %+v", tc.ref, ra)
+		}
+		if ra.String() != tc.str {
+			t.Errorf("%s: expected '%s', got '%s'", tc.ref, tc.str, ra.String())
+		}
+	}
+
+	for _, ref := range []string{
+		"", "4001", "4000001", "40000", "400000", "465537", "20001", "4000a",
+		"-4001",
+	} {
+		_, err := ParseRegisterAddress(ref)
+		if !errors.Is(err, ErrUnexpectedParameters) {
+			t.Errorf("%s: expected ErrUnexpectedParameters, got %v", ref, err)
+		}
+	}
+}
+
+func TestClientReadAt(t *testing.T) {
+	var mt *MockTransport = NewMockTransport()
+	var client *ModbusClient = NewMockClient(mt)
+	var err error
+
+	mt.Queue(
+		MockResponse{FunctionCode: 0x04, Payload: []byte{0x02, 0x12, 0x34}},
+		MockResponse{FunctionCode: 0x01, Payload: []byte{0x01, 0x01}},
+	)
+
+	ra, _ := ParseRegisterAddress("30011")
+	regs, err := client.ReadRegistersAt(ra, 1)
+	if err != nil || len(regs) != 1 || regs[0] != 0x1234 {
+		t.Errorf("unexpected registers: %v (err: %v)", regs, err)
+	}
+
+	ra, _ = ParseRegisterAddress("00011")
+	bits, err := client.ReadBitsAt(ra, 1)
+	if err != nil || len(bits) != 1 || !bits[0] {
+		t.Errorf("unexpected bits: %v (err: %v)", bits, err)
+	}
+
+	reqs := mt.Requests()
+	if len(reqs) != 2 ||
+		reqs[0].FunctionCode != 0x04 || reqs[0].Payload[1] != 10 ||
+		reqs[1].FunctionCode != 0x01 || reqs[1].Payload[1] != 10 {
+		t.Errorf("unexpected requests: %+v", reqs)
+	}
+
+	// mismatched tables should be rejected
+	_, err = client.ReadRegistersAt(ra, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got %v", err)
+	}
+	ra, _ = ParseRegisterAddress("40001")
+	_, err = client.ReadBitsAt(ra, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// 1 byte of start-of-frame marker, 2 hex chars for each of the 253 bytes
+	// of PDU, unit id and LRC, and 2 bytes of CRLF terminator
+	maxASCIIFrameLength int = 513
+)
+
+type asciiTransport struct {
+	timeouts
+	logger *logger
+	link   rtuLink
+	reader *bufio.Reader
+}
+
+// Returns a new ASCII transport.
+func newASCIITransport(link rtuLink, addr string, timeout time.Duration, parentLogger *logger) *asciiTransport {
+	at := asciiTransport{
+		logger:   parentLogger.derive(fmt.Sprintf("ascii-transport(%s)", addr)),
+		link:     link,
+		reader:   bufio.NewReaderSize(link, maxASCIIFrameLength),
+		timeouts: timeouts{timeout: timeout},
+	}
+
+	return &at
+}
+
+// Closes the ascii link.
+func (at *asciiTransport) Close() error {
+	return at.link.Close()
+}
+
+// Runs a request across the ascii link and returns a response.
+func (at *asciiTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	// set an i/o deadline on the link
+	err := at.link.SetDeadline(time.Now().Add(at.timeoutFor(req.unitId)))
+	if err != nil {
+		return nil, err
+	}
+
+	// drop any stale data left over from a previous exchange
+	at.reader.Reset(at.link)
+
+	// build an ASCII ADU out of the request object and send it on the wire
+	_, err = at.link.Write(at.assembleASCIIFrame(req))
+	if err != nil {
+		return nil, err
+	}
+
+	// broadcast requests get no response
+	if isBroadcast(req) {
+		return nil, nil
+	}
+
+	// read the response back from the wire
+	return at.readASCIIFrame()
+}
+
+// Reads a request from the ascii link.
+func (at *asciiTransport) ReadRequest() (*pdu, error) {
+	// set an i/o deadline on the link
+	err := at.link.SetDeadline(time.Now().Add(at.defaultTimeout()))
+	if err != nil {
+		return nil, err
+	}
+
+	return at.readASCIIFrame()
+}
+
+// Writes a response to the ascii link.
+func (at *asciiTransport) WriteResponse(res *pdu) error {
+	// refresh the i/o deadline, which may have expired while the request
+	// was being handled
+	err := at.link.SetDeadline(time.Now().Add(at.defaultTimeout()))
+	if err != nil {
+		return err
+	}
+
+	_, err = at.link.Write(at.assembleASCIIFrame(res))
+
+	return err
+}
+
+// Waits for, reads and decodes a frame from the ascii link.
+// As ASCII frames are variable length and delimited by start and end markers,
+// the frame is accumulated across as many reads as needed.
+func (at *asciiTransport) readASCIIFrame() (*pdu, error) {
+	var rxbuf []byte
+	var frame []byte
+	var lrc lrc
+
+	// skip anything preceding the start-of-frame marker
+	for {
+		b, err := at.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b == ':' {
+			break
+		}
+	}
+
+	// accumulate characters up to the LF terminator
+	for {
+		b, err := at.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b == ':' {
+			// a new frame started before the end of the current one:
+			// drop what we have so far and start over
+			at.logger.Warning("unexpected start of frame, dropping partial frame")
+			rxbuf = rxbuf[:0]
+			continue
+		}
+
+		rxbuf = append(rxbuf, b)
+		if b == '\n' {
+			break
+		}
+
+		if len(rxbuf) > maxASCIIFrameLength-1 {
+			return nil, ErrProtocol
+		}
+	}
+
+	// expect a CR right before the LF and an even number of hex characters
+	if len(rxbuf) < 2 || rxbuf[len(rxbuf)-2] != '\r' {
+		return nil, ErrProtocol
+	}
+	rxbuf = rxbuf[:len(rxbuf)-2]
+
+	if len(rxbuf)%2 != 0 {
+		return nil, ErrProtocol
+	}
+
+	frame = make([]byte, len(rxbuf)/2)
+	_, err := hex.Decode(frame, rxbuf)
+	if err != nil {
+		return nil, ErrProtocol
+	}
+
+	// expect at least a unit id, a function code and an LRC
+	if len(frame) < 3 {
+		return nil, ErrShortFrame
+	}
+
+	// compute the LRC on the entire frame, excluding the LRC
+	lrc.init()
+	lrc.add(frame[0 : len(frame)-1])
+
+	// compare LRC values
+	if !lrc.isEqual(frame[len(frame)-1]) {
+		return nil, ErrProtocol
+	}
+
+	return &pdu{
+		unitId:       frame[0],
+		functionCode: frame[1],
+		payload:      frame[2 : len(frame)-1],
+	}, nil
+}
+
+// Turns a PDU object into bytes.
+func (at *asciiTransport) assembleASCIIFrame(p *pdu) []byte {
+	var lrc lrc
+	var adu []byte
+
+	adu = append(adu, p.unitId)
+	adu = append(adu, p.functionCode)
+	adu = append(adu, p.payload...)
+
+	// run the ADU through the LRC generator
+	lrc.init()
+	lrc.add(adu)
+
+	// append the LRC to the ADU
+	adu = append(adu, lrc.value())
+
+	// hex-encode the ADU and wrap it with start and end markers
+	return []byte(":" + strings.ToUpper(hex.EncodeToString(adu)) + "\r\n")
+}
+
+type lrc struct {
+	sum uint8
+}
+
+// Prepares the LRC generator for use.
+func (l *lrc) init() {
+	l.sum = 0
+}
+
+// Adds the given bytes to the LRC.
+func (l *lrc) add(in []byte) {
+	for _, b := range in {
+		l.sum += b
+	}
+}
+
+// Returns the LRC (two's complement of the sum of all bytes).
+func (l *lrc) value() byte {
+	return -l.sum
+}
+
+func (l *lrc) isEqual(value byte) bool {
+	return l.value() == value
+}
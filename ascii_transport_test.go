@@ -0,0 +1,178 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAssembleASCIIFrame(t *testing.T) {
+	var at *asciiTransport
+	var frame []byte
+
+	at = &asciiTransport{}
+
+	frame = at.assembleASCIIFrame(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	// expect a start-of-frame marker, 2 hex chars for each of the unit id,
+	// function code, 4 bytes of payload and LRC, then CRLF
+	if string(frame) != ":1103006B00037E\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+}
+
+func TestASCIITransportReadASCIIFrame(t *testing.T) {
+	var at *asciiTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var res *pdu
+
+	txchan = make(chan []byte, 4)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	at = newASCIITransport(p2, "", 100*time.Millisecond, nil)
+
+	// read a valid response, split across multiple writes and preceded by
+	// line noise
+	txchan <- []byte("\x00\xff:1103")
+	txchan <- []byte("0411223344")
+	txchan <- []byte("3E\r")
+	txchan <- []byte("\n")
+	res, err = at.readASCIIFrame()
+	if err != nil {
+		t.Fatalf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+	if res.unitId != 0x11 {
+		t.Errorf("expected 0x11 as unit id, got 0x%02x", res.unitId)
+	}
+	if res.functionCode != 0x03 {
+		t.Errorf("expected 0x03 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 5 {
+		t.Errorf("expected a length of 5, got %v", len(res.payload))
+	}
+	for i, b := range []byte{
+		0x04,
+		0x11, 0x22,
+		0x33, 0x44,
+	} {
+		if res.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				b, i, res.payload[i])
+		}
+	}
+
+	// read a frame with a bad LRC
+	txchan <- []byte(":1183020000\r\n")
+	_, err = at.readASCIIFrame()
+	if err != ErrProtocol {
+		t.Errorf("readASCIIFrame() should have returned ErrProtocol, got %v", err)
+	}
+
+	// read a frame with invalid hex characters
+	txchan <- []byte(":11830Z6A\r\n")
+	_, err = at.readASCIIFrame()
+	if err != ErrProtocol {
+		t.Errorf("readASCIIFrame() should have returned ErrProtocol, got %v", err)
+	}
+
+	// read a valid exception response (lowercase hex is accepted)
+	txchan <- []byte(":1183026a\r\n")
+	res, err = at.readASCIIFrame()
+	if err != nil {
+		t.Fatalf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x83 {
+		t.Errorf("expected 0x83 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 1 || res.payload[0] != 0x02 {
+		t.Errorf("expected {0x02} as payload, got %v", res.payload)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestASCIITransportExecuteRequest(t *testing.T) {
+	var at *asciiTransport
+	var p1, p2 net.Conn
+	var err error
+	var res *pdu
+
+	p1, p2 = net.Pipe()
+
+	// play the role of a device answering a read holding registers request
+	go func() {
+		var rxbuf = make([]byte, 17)
+		var n int
+		var rerr error
+
+		for n < len(rxbuf) {
+			var count int
+
+			count, rerr = p1.Read(rxbuf[n:])
+			if rerr != nil {
+				return
+			}
+			n += count
+		}
+
+		if string(rxbuf) != ":1103006B00037E\r\n" {
+			t.Errorf("unexpected request frame: %q", rxbuf)
+			return
+		}
+
+		p1.Write([]byte(":11030400010002E5\r\n"))
+	}()
+
+	at = newASCIITransport(p2, "", 100*time.Millisecond, nil)
+
+	res, err = at.ExecuteRequest(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+	for i, b := range []byte{
+		0x04,
+		0x00, 0x01,
+		0x00, 0x02,
+	} {
+		if res.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				b, i, res.payload[i])
+		}
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestLRC(t *testing.T) {
+	var l lrc
+
+	l.init()
+	if l.value() != 0x00 {
+		t.Errorf("expected 0x00, saw 0x%02x", l.value())
+	}
+
+	l.add([]byte{0x11, 0x03, 0x00, 0x6b, 0x00, 0x03})
+	if l.value() != 0x7e {
+		t.Errorf("expected 0x7e, saw 0x%02x", l.value())
+	}
+
+	if !l.isEqual(0x7e) {
+		t.Error("isEqual() should have returned true")
+	}
+
+	if l.isEqual(0x7f) {
+		t.Error("isEqual() should have returned false")
+	}
+}
@@ -1,21 +1,29 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
+	"math/bits"
 	"net"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type RegType uint
 type Endianness uint
 type WordOrder uint
+type TxnIdMismatchPolicy uint
+type SchedulingPolicy uint
+type Direction uint
 
 const (
 	PARITY_NONE uint = 0
@@ -32,24 +40,107 @@ const (
 	// word order of 32-bit registers
 	HIGH_WORD_FIRST WordOrder = 1
 	LOW_WORD_FIRST  WordOrder = 2
+
+	// handling of responses carrying an unexpected transaction id
+	TXN_ID_MISMATCH_SKIP TxnIdMismatchPolicy = 0
+	TXN_ID_MISMATCH_FAIL TxnIdMismatchPolicy = 1
+
+	// order in which concurrent requests are sent over a shared connection
+	SCHEDULING_FIFO        SchedulingPolicy = 0
+	SCHEDULING_ROUND_ROBIN SchedulingPolicy = 1
+
+	// direction of traced frames
+	DIRECTION_TX Direction = 1
+	DIRECTION_RX Direction = 2
+
+	// read device identification codes (function code 43 / MEI type 14)
+	DEVICE_ID_BASIC    uint8 = 0x01
+	DEVICE_ID_REGULAR  uint8 = 0x02
+	DEVICE_ID_EXTENDED uint8 = 0x03
+	DEVICE_ID_SPECIFIC uint8 = 0x04
+
+	// standard device identification object ids
+	OBJECT_ID_VENDOR_NAME           uint8 = 0x00
+	OBJECT_ID_PRODUCT_CODE          uint8 = 0x01
+	OBJECT_ID_MAJOR_MINOR_REVISION  uint8 = 0x02
+	OBJECT_ID_VENDOR_URL            uint8 = 0x03
+	OBJECT_ID_PRODUCT_NAME          uint8 = 0x04
+	OBJECT_ID_MODEL_NAME            uint8 = 0x05
+	OBJECT_ID_USER_APPLICATION_NAME uint8 = 0x06
+
+	// diagnostics sub-function codes (function code 08)
+	DIAG_RETURN_QUERY_DATA                uint16 = 0x0000
+	DIAG_RESTART_COMMUNICATIONS           uint16 = 0x0001
+	DIAG_RETURN_DIAGNOSTIC_REGISTER       uint16 = 0x0002
+	DIAG_CHANGE_ASCII_INPUT_DELIMITER     uint16 = 0x0003
+	DIAG_FORCE_LISTEN_ONLY_MODE           uint16 = 0x0004
+	DIAG_CLEAR_COUNTERS                   uint16 = 0x000a
+	DIAG_RETURN_BUS_MESSAGE_COUNT         uint16 = 0x000b
+	DIAG_RETURN_BUS_COMM_ERROR_COUNT      uint16 = 0x000c
+	DIAG_RETURN_BUS_EXCEPTION_ERROR_COUNT uint16 = 0x000d
+	DIAG_RETURN_SERVER_MESSAGE_COUNT      uint16 = 0x000e
+	DIAG_RETURN_SERVER_NO_RESPONSE_COUNT  uint16 = 0x000f
+	DIAG_RETURN_SERVER_NAK_COUNT          uint16 = 0x0010
+	DIAG_RETURN_SERVER_BUSY_COUNT         uint16 = 0x0011
+	DIAG_RETURN_BUS_CHAR_OVERRUN_COUNT    uint16 = 0x0012
+	DIAG_CLEAR_OVERRUN_COUNTER            uint16 = 0x0014
 )
 
+func (d Direction) String() string {
+	switch d {
+	case DIRECTION_TX:
+		return "tx"
+	case DIRECTION_RX:
+		return "rx"
+	}
+
+	return fmt.Sprintf("direction(%d)", uint(d))
+}
+
+// File record sub-request, as used by ReadFileRecords() and
+// WriteFileRecords().
+type FileRecord struct {
+	// FileNumber is the number of the file to access (1 to 0xffff)
+	FileNumber uint16
+	// RecordNumber is the number of the first record to access
+	// (0 to 0x270f)
+	RecordNumber uint16
+	// RecordLength is the number of registers to read (reads only)
+	RecordLength uint16
+	// Values holds the registers to write (writes only)
+	Values []uint16
+}
+
+// Per-device request size limits, for devices handling less than the spec
+// maximums. Limits left to 0, or above the spec maximums, are ignored.
+// Register limits must be at least 2, as 32-bit values are never split
+// across requests.
+type DeviceProfile struct {
+	// maximum number of registers per read request (125 per the spec)
+	MaxReadRegisters int
+	// maximum number of registers per write request (123 per the spec)
+	MaxWriteRegisters int
+	// maximum number of coils or discrete inputs per read request (2000
+	// per the spec)
+	MaxReadCoils int
+}
+
 // Modbus client configuration object.
 type ClientConfiguration struct {
 	// URL sets the client mode and target location in the form
 	// <mode>://<serial device or host:port> e.g. tcp://plc:502
 	URL string
 
-	// Speed sets the serial link speed (in bps, rtu only)
+	// Speed sets the serial link speed (in bps, rtu and ascii only)
 	Speed uint
 
-	// DataBits sets the number of bits per serial character (rtu only)
+	// DataBits sets the number of bits per serial character (rtu and ascii only)
 	DataBits uint
 
-	// Parity sets the serial link parity mode (rtu only)
+	// Parity sets the serial link parity mode (rtu and ascii only)
 	Parity uint
 
-	// StopBits sets the number of serial stop bits (rtu only)
+	// StopBits sets the number of serial stop bits (rtu and ascii only)
 	StopBits uint
 
 	// Timeout sets the request timeout value
@@ -66,9 +157,224 @@ type ClientConfiguration struct {
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger *log.Logger
+
+	// LogLevel sets the minimum level of log messages to output
+	// (defaults to LOG_INFO, use LOG_OFF to silence all messages).
+	LogLevel LogLevel
+
+	// LogFunc, if set, receives log messages along with structured fields
+	// instead of Logger/stdout.
+	LogFunc LogFunc
+
+	// Retry sets the reconnection policy applied when the connection
+	// drops mid-request (tcp and tcp+tls only).
+	// Leave MaxAttempts to 0 to fail fast (default).
+	Retry RetryConfig
+
+	// Pipelined allows requests issued concurrently from multiple goroutines
+	// to be in flight at the same time, responses being matched to requests
+	// by transaction id (tcp and tcp+tls only).
+	// The Retry policy is ignored when pipelining is enabled.
+	Pipelined bool
+
+	// Scheduling sets the order in which requests issued concurrently
+	// through the client and its handles (see WithUnitId() and
+	// Gateway.Unit()) take turns on the connection: SCHEDULING_FIFO
+	// (default) serves them roughly in arrival order, while
+	// SCHEDULING_ROUND_ROBIN serves unit ids in turn, one request each, so
+	// that a slow device with many requests waiting does not starve the
+	// others. Ignored when pipelining is enabled.
+	Scheduling SchedulingPolicy
+
+	// DeviceProfile sets the request size limits of the device, used to
+	// validate requests and to split bulk reads and writes (see e.g.
+	// ReadRegistersBlock() and WriteRegisters()). Defaults to the spec
+	// maximums.
+	DeviceProfile DeviceProfile
+
+	// KeepAlive sets the policy used to detect stale connections by probing
+	// idle ones (tcp and tcp+tls only, ignored when pipelining is enabled).
+	// Leave Interval to 0 to disable probes (default).
+	KeepAlive KeepAliveConfig
+
+	// ProtocolId sets the protocol identifier of the MBAP header of frames
+	// sent, and expected in frames received (tcp, tcp+tls and udp only),
+	// for proprietary encapsulations reusing the MBAP header.
+	// Defaults to 0x0000 (modbus), as mandated by the spec.
+	ProtocolId uint16
+
+	// DefaultUnitId sets the unit id requests are sent to until SetUnitId()
+	// is called. Leave to 0 for the default of the mode: 0xff for tcp,
+	// tcp+tls and udp, as the spec recommends for devices that are not
+	// gateways, and 1 otherwise. Use SetUnitId(0) to address unit id 0.
+	DefaultUnitId uint8
+
+	// TolerantExceptions makes the client accept exception responses from
+	// devices which echo the request function code instead of setting its
+	// high bit: 1-byte responses to function codes expecting longer ones are
+	// then treated as exceptions rather than rejected with ErrProtocol
+	// (tcp, tcp+tls and udp only). Defaults to false (strict parsing).
+	TolerantExceptions bool
+
+	// BusIdleWindow, if non-zero, makes the client listen to the line
+	// before each transmission and wait for it to remain silent for 3.5
+	// character times, to avoid corrupting frames sent by another master on
+	// the same bus (rtu, rtuovertcp and rtuoverudp only).
+	// Requests fail with ErrBusBusy if the line doesn't go silent within
+	// BusIdleWindow. Any data heard in the meantime is discarded.
+	BusIdleWindow time.Duration
+
+	// ResyncOnFrameError makes the client drain the line after receiving a
+	// corrupted response (bad CRC, short or malformed frame) until it has
+	// been silent for 3.5 character times, so that the tail of the frame or
+	// line noise doesn't corrupt the next response (rtu, rtuovertcp and
+	// rtuoverudp only). By default, data is flushed for as long as the
+	// longest frame takes to transmit.
+	ResyncOnFrameError bool
+
+	// UDPRetransmits sets how many times unanswered requests are sent again,
+	// with the same transaction id, before giving up (udp only, ignored when
+	// pipelining is enabled). Timeout is split evenly between attempts.
+	// Defaults to 0 (no retransmission).
+	UDPRetransmits int
+
+	// TCPKeepAlive sets the TCP-level keep-alive options of tcp, tcp+tls,
+	// rtuovertcp and asciiovertcp connections, letting the operating system
+	// detect half-open connections (e.g. after a device power-cycle) within
+	// Idle + Interval * Count.
+	// Leave Enable to false to keep the defaults (keep-alive enabled, with
+	// system-dependent settings).
+	TCPKeepAlive net.KeepAliveConfig
+
+	// Dial, if set, is called instead of dialing the host part of URL
+	// whenever a connection is needed (tcp only), e.g. to hand out warmed-up
+	// connections from an application-managed pool.
+	// Connections are handed back by calling their Close() method, which
+	// pools can wrap to reclaim them.
+	Dial func() (net.Conn, error)
+
+	// Dialer, if set, is used to dial tcp, tcp+tls, rtuovertcp and
+	// asciiovertcp connections, e.g. to bind a local address (LocalAddr) on
+	// multi-homed hosts or to set socket options (Control).
+	// Its Timeout defaults to 5 seconds (15 seconds for tcp+tls) if left to 0.
+	Dialer *net.Dialer
+
+	// UDPLocalAddr, if set, is the local address udp and rtuoverudp sockets
+	// are bound to.
+	UDPLocalAddr *net.UDPAddr
+
+	// ConnMaxLifetime, if non-zero, is the amount of time after which a
+	// connection is closed and replaced ahead of the next request (tcp and
+	// tcp+tls only, ignored when pipelining is enabled).
+	ConnMaxLifetime time.Duration
+
+	// ConnPerRequest makes the client close its connection after each
+	// transaction and obtain a new one on the next request, rather than
+	// holding a dedicated connection (tcp and tcp+tls only, ignored when
+	// pipelining is enabled). Keep-alive probes are not sent while no
+	// connection is held.
+	ConnPerRequest bool
+
+	// ConnIdleTimeout, if non-zero, is the amount of time after which a
+	// connection left unused (keep-alive probes aside) is closed, a new one
+	// being dialed transparently on the next request (tcp and tcp+tls only,
+	// ignored when pipelining is enabled). Meant for clients polling devices
+	// only occasionally.
+	ConnIdleTimeout time.Duration
+
+	// TxnIdMismatch sets how responses carrying an unexpected transaction
+	// id are handled (tcp, tcp+tls and udp only, ignored when pipelining
+	// is enabled): TXN_ID_MISMATCH_SKIP (default) skips them and keeps
+	// waiting for the expected response, TXN_ID_MISMATCH_FAIL fails the
+	// request with ErrBadTransactionId.
+	TxnIdMismatch TxnIdMismatchPolicy
+
+	// TxnIdMismatchFunc, if set, overrides TxnIdMismatch and decides on a
+	// per frame basis: returning true skips the frame, false fails the
+	// request with ErrBadTransactionId.
+	TxnIdMismatchFunc func(expected uint16, received uint16) bool
+
+	// MaxSkippedFrames sets how many frames not matching the pending request
+	// (unknown protocol id, transaction id or unit id) may be skipped before
+	// the request fails with ErrTooManyMismatchedFrames rather than waiting
+	// for the timeout to expire (tcp, tcp+tls and udp only, defaults to 16).
+	MaxSkippedFrames int
+
+	// RawFrameFunc, if set, is called with a copy of every raw frame
+	// (MBAP header + PDU) received, e.g. to hex dump device responses when
+	// troubleshooting (tcp, tcp+tls and udp only).
+	RawFrameFunc func(frame []byte)
+
+	// Observer, if set, is notified of the outcome of every request
+	// (e.g. to feed latency and error metrics).
+	Observer Observer
+
+	// FrameHistory sets the number of most recent frames sent and received
+	// kept for diagnostics (see RecentFrames(), tcp, tcp+tls and udp only).
+	// Leave to 0 to disable (default).
+	FrameHistory int
+
+	// DumpFramesOnProtocolError makes the client log the frames kept in its
+	// history whenever a response is rejected as malformed (ErrProtocol).
+	DumpFramesOnProtocolError bool
+}
+
+// Observer receives request completion events from clients.
+// Implementations are called synchronously from the goroutine issuing the
+// request and should return quickly. When pipelining is enabled, they may
+// be called concurrently.
+type Observer interface {
+	// RequestCompleted is called once per request with its function code,
+	// the time elapsed waiting for the response and the outcome of the
+	// request: nil on success, an error matching ErrRequestTimedOut (see
+	// errors.Is()), a transport error, or a *ModbusError when the device
+	// replied with an exception.
+	RequestCompleted(functionCode uint8, latency time.Duration, err error)
+}
+
+// FrameLengthObserver can optionally be implemented by observers to be
+// notified of responses whose MBAP length field leaves trailing bytes after
+// a well-formed PDU (tcp, tcp+tls and udp only), an early sign of a desynced
+// stream. Such responses are rejected with ErrProtocol.
+type FrameLengthObserver interface {
+	// FrameLengthMismatch is called with the function code of the response,
+	// the length declared in its MBAP header and the length expected from
+	// its PDU (both covering the unit id, function code and payload).
+	FrameLengthMismatch(functionCode uint8, declaredLength int, expectedLength int)
+}
+
+// Reconnection policy object.
+type RetryConfig struct {
+	// MaxAttempts sets the maximum number of times the connection is
+	// re-established and the request re-sent before giving up
+	MaxAttempts int
+
+	// InitialBackoff sets the delay before the first reconnection attempt
+	// (doubled on every subsequent attempt, defaults to 100ms)
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between reconnection attempts
+	// (defaults to 5s)
+	MaxBackoff time.Duration
+}
+
+// Keep-alive policy object.
+type KeepAliveConfig struct {
+	// Interval sets how long the connection may remain idle before a probe
+	// request is sent. Should the probe go unanswered, the connection is
+	// re-established.
+	Interval time.Duration
+
+	// Probe returns the unit id, function code and payload of probe requests.
+	// Any response, including exception responses, counts as a sign of life.
+	// Defaults to a diagnostics return query data request (function code 08)
+	// sent to the unit id of the client as of Open().
+	Probe func() (unitId uint8, functionCode uint8, payload []byte)
 }
 
 // Modbus client object.
+// All methods are safe for concurrent use by multiple goroutines: requests
+// are serialized and run one at a time over the underlying transport.
 type ModbusClient struct {
 	conf          ClientConfiguration
 	logger        *logger
@@ -78,6 +384,25 @@ type ModbusClient struct {
 	transport     transport
 	unitId        uint8
 	transportType transportType
+	parent        *ModbusClient
+	ctx           context.Context
+	// minimum delay between transactions (rtu modes only)
+	interRequestDelay time.Duration
+	// per unit id timeout overrides
+	unitTimeouts map[uint8]time.Duration
+	// maximum accepted frame length (tcp, tcp+tls and udp only)
+	maxFrameLength int
+	traceFunc      func(direction Direction, frame []byte)
+	// true between successful calls to Open() and Close()
+	connected bool
+	// most recent transport-level failure
+	lastErr atomic.Pointer[error]
+	// true if register writes are to be read back (see SetVerifyWrites())
+	verifyWrites atomic.Bool
+	// most recent frames, if enabled
+	history *frameHistory
+	// turns on the connection, if round-robin scheduling is enabled
+	sched *scheduler
 }
 
 // NewClient creates, configures and returns a modbus client object.
@@ -97,6 +422,7 @@ func NewClient(conf *ClientConfiguration) (*ModbusClient, error) {
 
 	mc.logger = newLogger(
 		fmt.Sprintf("modbus-client(%s)", mc.conf.URL), conf.Logger)
+	mc.logger.configure(conf.LogLevel, conf.LogFunc)
 
 	switch clientType {
 	case "rtu":
@@ -129,6 +455,39 @@ func NewClient(conf *ClientConfiguration) (*ModbusClient, error) {
 
 		mc.transportType = modbusRTU
 
+	case "ascii":
+		if mc.conf.Speed == 0 {
+			mc.conf.Speed = 19200
+		}
+
+		// note: the "modbus over serial line v1.02" document specifies a
+		// 10-bit character frame for ASCII mode, with 7 data bits, even parity
+		// and 1 stop bit as default, and 2 stop bits when no parity is used.
+		if mc.conf.DataBits == 0 {
+			mc.conf.DataBits = 7
+		}
+
+		if mc.conf.StopBits == 0 {
+			if mc.conf.Parity == PARITY_NONE {
+				mc.conf.StopBits = 2
+			} else {
+				mc.conf.StopBits = 1
+			}
+		}
+
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		mc.transportType = modbusASCII
+
+	case "asciiovertcp":
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		mc.transportType = modbusASCIIOverTCP
+
 	case "rtuovertcp":
 		if mc.conf.Speed == 0 {
 			mc.conf.Speed = 19200
@@ -184,11 +543,44 @@ func NewClient(conf *ClientConfiguration) (*ModbusClient, error) {
 		mc.transportType = modbusTCPOverUDP
 	default:
 		if len(splitURL) != 2 {
+			mc.logger.Errorf("missing client type in URL '%s'", mc.conf.URL)
 			return nil, fmt.Errorf("missing client type in URL '%s'", mc.conf.URL)
 		}
+		mc.logger.Errorf("unsupported client type '%s'", clientType)
 		return nil, fmt.Errorf("unsupported client type '%s'", clientType)
 	}
-	mc.unitId = 1
+	err := mc.conf.DeviceProfile.validate()
+	if err != nil {
+		mc.logger.Error(err.Error())
+		return nil, err
+	}
+
+	if mc.conf.Retry.MaxAttempts > 0 {
+		if mc.conf.Retry.InitialBackoff == 0 {
+			mc.conf.Retry.InitialBackoff = 100 * time.Millisecond
+		}
+
+		if mc.conf.Retry.MaxBackoff == 0 {
+			mc.conf.Retry.MaxBackoff = 5 * time.Second
+		}
+	}
+
+	switch {
+	case mc.conf.DefaultUnitId != 0:
+		mc.unitId = mc.conf.DefaultUnitId
+	case mc.transportType == modbusTCP || mc.transportType == modbusTCPOverTLS ||
+		mc.transportType == modbusTCPOverUDP:
+		mc.unitId = 0xff
+	default:
+		mc.unitId = 1
+	}
+	if mc.conf.FrameHistory > 0 {
+		mc.history = newFrameHistory(mc.conf.FrameHistory)
+	}
+	if mc.conf.Scheduling == SCHEDULING_ROUND_ROBIN && !mc.conf.Pipelined {
+		mc.sched = newScheduler()
+	}
+
 	mc.endianness = BIG_ENDIAN
 	mc.wordOrder = HIGH_WORD_FIRST
 	return &mc, nil
@@ -202,6 +594,12 @@ func (mc *ModbusClient) Open() error {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
+	// drop the current connection, if any, before reconnecting
+	if mc.connected {
+		mc.transport.Close()
+		mc.connected = false
+	}
+
 	switch mc.transportType {
 	case modbusRTU:
 		// create a serial port wrapper object
@@ -223,12 +621,50 @@ func (mc *ModbusClient) Open() error {
 		discard(spw)
 
 		// create the RTU transport
-		mc.transport = newRTUTransport(
-			spw, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
+		rt := newRTUTransport(
+			spw, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.logger)
+		rt.interRequestDelay = mc.interRequestDelay
+		rt.busIdleWindow = mc.conf.BusIdleWindow
+		rt.resyncOnError = mc.conf.ResyncOnFrameError
+		mc.transport = rt
+
+	case modbusASCII:
+		// create a serial port wrapper object
+		spw = newSerialPortWrapper(&serialPortConfig{
+			Device:   mc.conf.URL,
+			Speed:    mc.conf.Speed,
+			DataBits: mc.conf.DataBits,
+			Parity:   mc.conf.Parity,
+			StopBits: mc.conf.StopBits,
+		})
+
+		// open the serial device
+		err := spw.Open()
+		if err != nil {
+			return err
+		}
+
+		// discard potentially stale serial data
+		discard(spw)
+
+		// create the ASCII transport
+		mc.transport = newASCIITransport(
+			spw, mc.conf.URL, mc.conf.Timeout, mc.logger)
+
+	case modbusASCIIOverTCP:
+		// connect to the remote host
+		sock, err := mc.dialTCP()
+		if err != nil {
+			return err
+		}
+
+		// create the ASCII transport
+		mc.transport = newASCIITransport(
+			sock, mc.conf.URL, mc.conf.Timeout, mc.logger)
 
 	case modbusRTUOverTCP:
 		// connect to the remote host
-		sock, err := net.DialTimeout("tcp", mc.conf.URL, 5*time.Second)
+		sock, err := mc.dialTCP()
 		if err != nil {
 			return err
 		}
@@ -237,13 +673,17 @@ func (mc *ModbusClient) Open() error {
 		discard(sock)
 
 		// create the RTU transport
-		mc.transport = newRTUTransport(
-			sock, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
+		rt := newRTUTransport(
+			sock, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.logger)
+		rt.interRequestDelay = mc.interRequestDelay
+		rt.busIdleWindow = mc.conf.BusIdleWindow
+		rt.resyncOnError = mc.conf.ResyncOnFrameError
+		mc.transport = rt
 
 	case modbusRTUOverUDP:
 		// open a socket to the remote host (note: no actual connection is
 		// being made as UDP is connection-less)
-		sock, err := net.DialTimeout("udp", mc.conf.URL, 5*time.Second)
+		sock, err := mc.dialUDP()
 		if err != nil {
 			return err
 		}
@@ -251,55 +691,77 @@ func (mc *ModbusClient) Open() error {
 		// create the RTU transport, wrapping the UDP socket in
 		// an adapter to allow the transport to read the stream of
 		// packets byte per byte
-		mc.transport = newRTUTransport(
-			newUDPSockWrapper(sock),
-			mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
+		rt := newRTUTransport(
+			newUDPSockWrapper(sock, maxRTUFrameLength),
+			mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.logger)
+		rt.interRequestDelay = mc.interRequestDelay
+		rt.busIdleWindow = mc.conf.BusIdleWindow
+		rt.resyncOnError = mc.conf.ResyncOnFrameError
+		mc.transport = rt
 
 	case modbusTCP:
+		dial := mc.dialTCP
+		if mc.conf.Dial != nil {
+			dial = func() (net.Conn, error) {
+				sock, err := mc.conf.Dial()
+				if err == nil {
+					mc.setTCPKeepAlive(sock)
+				}
+				return sock, err
+			}
+		}
+
 		// connect to the remote host
-		sock, err := net.DialTimeout("tcp", mc.conf.URL, 5*time.Second)
+		sock, err := dial()
 		if err != nil {
 			return err
 		}
 
 		// create the TCP transport
-		mc.transport = newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+		tt := newTCPTransport(sock, mc.conf.Timeout, mc.logger)
+		tt.retry = mc.conf.Retry
+		tt.pipelined = mc.conf.Pipelined
+		tt.redial = dial
+		tt.maxLifetime = mc.conf.ConnMaxLifetime
+		tt.releaseConn = mc.conf.ConnPerRequest
+		mc.configureTCPTransport(tt)
+		if !mc.conf.Pipelined && mc.conf.KeepAlive.Interval > 0 {
+			tt.startKeepAlive(mc.keepAliveConfig())
+		}
+		if !mc.conf.Pipelined && mc.conf.ConnIdleTimeout > 0 {
+			tt.startIdleTimer(mc.conf.ConnIdleTimeout)
+		}
+		mc.transport = tt
 
 	case modbusTCPOverTLS:
 		// connect to the remote host with TLS
-		sock, err := tls.DialWithDialer(
-			&net.Dialer{
-				Deadline: time.Now().Add(15 * time.Second),
-			}, "tcp", mc.conf.URL,
-			&tls.Config{
-				Certificates: []tls.Certificate{
-					*mc.conf.TLSClientCert,
-				},
-				RootCAs: mc.conf.TLSRootCAs,
-				// mandate TLS 1.2 or higher (see R-01 of the MBAPS spec)
-				MinVersion: tls.VersionTLS12,
-			})
-		if err != nil {
-			return err
-		}
-
-		// force the TLS handshake
-		err = sock.Handshake()
+		sock, err := mc.dialTLS()
 		if err != nil {
-			sock.Close()
 			return err
 		}
 
 		// create the TCP transport, wrapping the TLS socket in
 		// an adapter to work around write timeouts corrupting internal
 		// state (see https://pkg.go.dev/crypto/tls#Conn.SetWriteDeadline)
-		mc.transport = newTCPTransport(
-			newTLSSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		tt := newTCPTransport(sock, mc.conf.Timeout, mc.logger)
+		tt.retry = mc.conf.Retry
+		tt.pipelined = mc.conf.Pipelined
+		tt.redial = mc.dialTLS
+		tt.maxLifetime = mc.conf.ConnMaxLifetime
+		tt.releaseConn = mc.conf.ConnPerRequest
+		mc.configureTCPTransport(tt)
+		if !mc.conf.Pipelined && mc.conf.KeepAlive.Interval > 0 {
+			tt.startKeepAlive(mc.keepAliveConfig())
+		}
+		if !mc.conf.Pipelined && mc.conf.ConnIdleTimeout > 0 {
+			tt.startIdleTimer(mc.conf.ConnIdleTimeout)
+		}
+		mc.transport = tt
 
 	case modbusTCPOverUDP:
 		// open a socket to the remote host (note: no actual connection is
 		// being made as UDP is connection-less)
-		sock, err := net.DialTimeout("udp", mc.conf.URL, 5*time.Second)
+		sock, err := mc.dialUDP()
 		if err != nil {
 			return err
 		}
@@ -307,521 +769,2569 @@ func (mc *ModbusClient) Open() error {
 		// create the TCP transport, wrapping the UDP socket in
 		// an adapter to allow the transport to read the stream of
 		// packets byte per byte
-		mc.transport = newTCPTransport(
-			newUDPSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		tt := newTCPTransport(
+			newUDPSockWrapper(sock, max(mc.maxFrameLength, maxTCPFrameLength)),
+			mc.conf.Timeout, mc.logger)
+		mc.configureTCPTransport(tt)
+		mc.transport = tt
+
+	case modbusUnitHandle:
+		// unit id handles share the connection of the client they were
+		// obtained from
+		mc.transport = &unitTransport{parent: mc.parent, ctx: mc.ctx}
+
+	case modbusMock:
+		// mock clients come with their transport, nothing to open
 
 	default:
 		// should never happen
 		return ErrConfiguration
 	}
+
+	if tt, ok := mc.transport.(*tcpTransport); ok {
+		if mc.maxFrameLength != 0 {
+			tt.setMaxFrameLength(mc.maxFrameLength)
+		}
+		tt.setTraceFunc(mc.traceFunc)
+	}
+
+	// apply per unit id timeouts to the new transport
+	if ut, ok := mc.transport.(timeoutTransport); ok {
+		for unitId, timeout := range mc.unitTimeouts {
+			ut.setUnitTimeout(unitId, timeout)
+		}
+	}
+
+	mc.connected = true
+
 	return nil
 }
 
 // Closes the underlying transport.
+// The client can be reopened with Open() at any time.
 func (mc *ModbusClient) Close() error {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
+	mc.connected = false
+
 	if mc.transport != nil {
 		return mc.transport.Close()
 	}
 	return nil
 }
 
-// Sets the unit id of subsequent requests.
-func (mc *ModbusClient) SetUnitId(id uint8) {
+// Returns true if the client was successfully opened and has not been
+// closed since. Note that a dropped connection is only detected when the
+// next request fails.
+// Handles obtained with WithUnitId() or WithContext() report the state of
+// their parent client.
+func (mc *ModbusClient) IsConnected() bool {
+	if mc.parent != nil {
+		return mc.parent.IsConnected()
+	}
+
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
-	mc.unitId = id
+
+	return mc.connected
 }
 
-// Sets the encoding (endianness and word ordering) of subsequent requests.
-func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder) error {
+// Sets the unit id of subsequent requests.
+// On serial links (rtu and ascii modes), writes sent to unit id 0 are
+// broadcast to all devices: no response is expected and writes return
+// as soon as the request has been sent.
+func (mc *ModbusClient) SetUnitId(id uint8) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
+	mc.unitId = id
+}
 
-	if endianness != BIG_ENDIAN && endianness != LITTLE_ENDIAN {
-		mc.logger.Errorf("unknown endianness value %v", endianness)
-		return ErrUnexpectedParameters
+// Sets the request timeout, overriding the Timeout value of the client
+// configuration. Takes effect from the next request on, and can safely be
+// called while requests are in flight (e.g. for adaptive polling).
+// Timeouts of 0 or less are logged and ignored.
+// Can be called before or after Open(). Handles obtained with WithUnitId()
+// or WithContext() update their parent client.
+func (mc *ModbusClient) SetTimeout(timeout time.Duration) {
+	if mc.parent != nil {
+		mc.parent.SetTimeout(timeout)
+		return
 	}
 
-	if wordOrder != HIGH_WORD_FIRST && wordOrder != LOW_WORD_FIRST {
-		mc.logger.Errorf("unknown word order value %v", wordOrder)
-		return ErrUnexpectedParameters
+	if timeout <= 0 {
+		mc.logger.Errorf("ignoring invalid timeout (%v)", timeout)
+		return
 	}
 
-	mc.endianness = endianness
-	mc.wordOrder = wordOrder
-	return nil
-}
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
 
-// Reads multiple coils (function code 01).
-func (mc *ModbusClient) ReadCoils(addr uint16, quantity uint16) ([]bool, error) {
-	return mc.readBools(addr, quantity, false)
+	mc.conf.Timeout = timeout
+	if tt, ok := mc.transport.(timeoutTransport); ok {
+		tt.setTimeout(timeout)
+	}
 }
 
-// Reads a single coil (function code 01).
-func (mc *ModbusClient) ReadCoil(addr uint16) (bool, error) {
-	values, err := mc.readBools(addr, 1, false)
-	if err != nil {
-		return false, err
-	}
-	if len(values) < 1 {
-		return false, errors.New("no values")
+// Sets the timeout of requests addressed to unitId, overriding the Timeout
+// value of the client configuration (e.g. for slow devices behind a
+// gateway). A timeout of 0 removes the override.
+// Can be called before or after Open(). Handles obtained with WithUnitId()
+// or WithContext() update their parent client.
+func (mc *ModbusClient) SetUnitTimeout(unitId uint8, timeout time.Duration) {
+	if mc.parent != nil {
+		mc.parent.SetUnitTimeout(unitId, timeout)
+		return
 	}
-	return values[0], nil
-}
 
-// Reads multiple discrete inputs (function code 02).
-func (mc *ModbusClient) ReadDiscreteInputs(addr uint16, quantity uint16) ([]bool, error) {
-	return mc.readBools(addr, quantity, true)
-}
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
 
-// Reads a single discrete input (function code 02).
-func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (bool, error) {
-	values, err := mc.readBools(addr, 1, true)
-	if err != nil {
-		return false, err
-	}
-	if len(values) < 1 {
-		return false, errors.New("no values")
+	if timeout == 0 {
+		delete(mc.unitTimeouts, unitId)
+	} else {
+		if mc.unitTimeouts == nil {
+			mc.unitTimeouts = make(map[uint8]time.Duration)
+		}
+		mc.unitTimeouts[unitId] = timeout
 	}
-	return values[0], nil
-}
 
-// Reads multiple 16-bit registers (function code 03 or 04).
-func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegType) ([]uint16, error) {
-	// read quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity, regType)
-	if err != nil {
-		return nil, err
+	if ut, ok := mc.transport.(timeoutTransport); ok {
+		ut.setUnitTimeout(unitId, timeout)
 	}
-	// decode payload bytes as uint16s
-	values := bytesToUint16s(mc.endianness, mbPayload)
-	return values, nil
 }
 
-// Reads a single 16-bit register (function code 03 or 04).
-func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (value uint16, err error) {
-	// read 1 uint16 register, as bytes
-	values, err := mc.ReadRegisters(addr, 1, regType)
-	if err != nil {
-		return 0, err
-	}
-	if len(values) < 1 {
-		return 0, errors.New("bo values")
+// Sets the minimum delay between the end of a response (or of a broadcast
+// request) and the start of the next request, for devices needing time to
+// recover between transactions (rtu, rtuovertcp and rtuoverudp only).
+// The delay is measured from the last byte received to the next byte sent,
+// and only applies when longer than the 3.5 character times required by the
+// spec. Can be called before or after Open(). Handles obtained with
+// WithUnitId() or WithContext() update their parent client.
+func (mc *ModbusClient) SetInterRequestDelay(delay time.Duration) {
+	if mc.parent != nil {
+		mc.parent.SetInterRequestDelay(delay)
+		return
 	}
-	return values[0], nil
-}
 
-// Reads multiple 32-bit registers.
-func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegType) ([]uint32, error) {
-	// read 2 * quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity*2, regType)
-	if err != nil {
-		return nil, err
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.interRequestDelay = delay
+	if rt, ok := mc.transport.(*rtuTransport); ok {
+		rt.interRequestDelay = delay
 	}
-	// decode payload bytes as uint32s
-	values := bytesToUint32s(mc.endianness, mc.wordOrder, mbPayload)
-	return values, nil
 }
 
-// Reads a single 32-bit register.
-func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (uint32, error) {
-	values, err := mc.ReadUint32s(addr, 1, regType)
-	if err != nil {
-		return 0, err
+// Raises the maximum length of frames accepted from the device above the
+// 260 bytes allowed by the spec, for non-compliant devices or gateways
+// sending oversized frames (tcp, tcp+tls and udp only).
+// length must be between 260 and 65541 bytes (the largest frame the MBAP
+// header can describe). A length of 0 restores the default of 260 bytes.
+// Can be called before or after Open(). Handles obtained with WithUnitId()
+// or WithContext() update their parent client.
+func (mc *ModbusClient) SetMaxFrameLength(length int) error {
+	if mc.parent != nil {
+		return mc.parent.SetMaxFrameLength(length)
+	}
+
+	if length != 0 &&
+		(length < maxTCPFrameLength || length > maxExtendedTCPFrameLength) {
+		mc.logger.Errorf("max frame length must be between %v and %v bytes",
+			maxTCPFrameLength, maxExtendedTCPFrameLength)
+		return ErrUnexpectedParameters
 	}
-	if len(values) < 1 {
-		return 0, errors.New("no values")
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.maxFrameLength = length
+	if tt, ok := mc.transport.(*tcpTransport); ok {
+		tt.setMaxFrameLength(length)
 	}
-	return values[0], nil
+
+	return nil
 }
 
-// Reads multiple 32-bit float registers.
-func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegType) ([]float32, error) {
-	// read 2 * quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity*2, regType)
-	if err != nil {
-		return nil, err
+// Enables or disables write verification: when enabled, holding registers
+// written with WriteRegister(), WriteRegisters() and other register write
+// methods are read back (function code 03) right after each successful write
+// request, ErrVerifyFailed being returned if their values differ from those
+// written. Meant for critical setpoints, to catch devices or gateways
+// acknowledging writes without applying them. Broadcast writes are not
+// verified. Disabled by default.
+// Handles obtained with WithUnitId() or WithContext() update their parent
+// client.
+func (mc *ModbusClient) SetVerifyWrites(enabled bool) {
+	if mc.parent != nil {
+		mc.parent.SetVerifyWrites(enabled)
+		return
 	}
-	// decode payload bytes as float32s
-	values := bytesToFloat32s(mc.endianness, mc.wordOrder, mbPayload)
-	return values, nil
+
+	mc.verifyWrites.Store(enabled)
 }
 
-// Reads a single 32-bit float register.
-func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (float32, error) {
-	values, err := mc.ReadFloat32s(addr, 1, regType)
-	if err != nil {
-		return 0, err
-	}
-	if len(values) < 1 {
-		return 0, errors.New("no values")
+// Returns true if write verification is enabled.
+func (mc *ModbusClient) verifyWritesEnabled() bool {
+	if mc.parent != nil {
+		return mc.parent.verifyWritesEnabled()
 	}
-	return values[0], nil
+
+	return mc.verifyWrites.Load()
 }
 
-// Reads multiple 64-bit registers.
-func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegType) (values []uint64, err error) {
-	var mbPayload []byte
+// Reads back the registers of width bytes each written to unitId starting at
+// addr, in as many requests as needed, and makes sure they hold values (as
+// sent on the wire).
+func (mc *ModbusClient) verifyRegisters(unitId uint8, addr uint16, values []byte, width int) error {
+	var readBack []byte
+	var maxQuantity uint16 = mc.maxReadRegisters()
 
-	// read 4 * quantity uint16 registers, as bytes
-	mbPayload, err = mc.readRegisters(addr, quantity*4, regType)
-	if err != nil {
-		return
+	// broadcast requests get no response
+	if unitId == 0 {
+		return nil
 	}
 
-	// decode payload bytes as uint64s
-	values = bytesToUint64s(mc.endianness, mc.wordOrder, mbPayload)
+	if width == 4 {
+		maxQuantity = maxReadEnronRegisters
+	}
 
-	return
-}
+	quantity := uint16(len(values) / width)
+	for done := uint16(0); done < quantity; {
+		count := min(quantity-done, maxQuantity)
 
-// Reads a single 64-bit register.
-func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (value uint64, err error) {
-	var values []uint64
+		chunk, err := mc.readUnitRegistersWidth(
+			unitId, addr+done, count, HOLDING_REGISTER, width)
+		if err != nil {
+			return err
+		}
 
-	values, err = mc.ReadUint64s(addr, 1, regType)
-	if err == nil {
-		value = values[0]
+		readBack = append(readBack, chunk...)
+		done += count
 	}
 
-	return
+	// report the first register that does not match
+	for i := 0; i < len(values); i += width {
+		if !bytes.Equal(readBack[i:i+width], values[i:i+width]) {
+			mc.logger.Warningf("write verification failed at address 0x%04x: "+
+				"wrote %x, read back %x", int(addr)+i/width,
+				values[i:i+width], readBack[i:i+width])
+			return ErrVerifyFailed
+		}
+	}
+
+	return nil
+}
+
+// Sets a function called with every complete frame sent (DIRECTION_TX, right
+// before it is written to the socket) or received (DIRECTION_RX, as soon as
+// it is fully read, before any validation) by the client, for byte-level
+// debugging (tcp, tcp+tls and udp only).
+// Frames are passed along as copies, MBAP header included, and may be
+// retained. Pass nil to remove the trace function.
+// Can be called before or after Open(). Handles obtained with WithUnitId()
+// or WithContext() update their parent client.
+func (mc *ModbusClient) SetTraceFunc(traceFunc func(direction Direction, frame []byte)) {
+	if mc.parent != nil {
+		mc.parent.SetTraceFunc(traceFunc)
+		return
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.traceFunc = traceFunc
+	if tt, ok := mc.transport.(*tcpTransport); ok {
+		tt.setTraceFunc(traceFunc)
+	}
+}
+
+// Returns a client handle addressing unit id instead of the one set with
+// SetUnitId(), over the same connection (e.g. to talk to several devices
+// behind a gateway).
+// The handle shares the transport of mc and inherits its encoding settings:
+// requests made through either are serialized, and calling Open() or Close()
+// on the handle has no effect on the underlying connection, which remains
+// managed through mc.
+func (mc *ModbusClient) WithUnitId(id uint8) *ModbusClient {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	return &ModbusClient{
+		conf:          mc.conf,
+		logger:        mc.logger,
+		endianness:    mc.endianness,
+		wordOrder:     mc.wordOrder,
+		unitId:        id,
+		transportType: modbusUnitHandle,
+		transport:     &unitTransport{parent: mc},
+		parent:        mc,
+	}
+}
+
+// Returns a client handle bound to ctx: requests made through the handle
+// are aborted as soon as ctx is done, in which case ctx.Err() is returned.
+// The context deadline, if any, supersedes the configured timeout
+// (tcp and tcp+tls only, other transports ignore the context).
+// As with WithUnitId(), the handle shares the transport of mc.
+func (mc *ModbusClient) WithContext(ctx context.Context) *ModbusClient {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	return &ModbusClient{
+		conf:          mc.conf,
+		logger:        mc.logger,
+		endianness:    mc.endianness,
+		wordOrder:     mc.wordOrder,
+		unitId:        mc.unitId,
+		transportType: modbusUnitHandle,
+		transport:     &unitTransport{parent: mc, ctx: ctx},
+		parent:        mc,
+		ctx:           ctx,
+	}
+}
+
+// Returns the certificate chain presented by the server (tcp+tls only),
+// leaf certificate first, e.g. to enforce authorization based on the
+// role extension of the server certificate.
+// Returns nil if the client is not connected over TLS.
+func (mc *ModbusClient) PeerCertificates() []*x509.Certificate {
+	if mc.parent != nil {
+		return mc.parent.PeerCertificates()
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if tt, ok := mc.transport.(*tcpTransport); ok {
+		tt.lock.Lock()
+		defer tt.lock.Unlock()
+
+		if tsw, ok := tt.socket.(*tlsSockWrapper); ok {
+			return tsw.peerCertificates()
+		}
+	}
+
+	return nil
+}
+
+// Connection statistics, as returned by ModbusClient.Stats().
+type ConnStats struct {
+	BytesSent      uint64
+	BytesReceived  uint64
+	FramesSent     uint64
+	FramesReceived uint64
+}
+
+// Returns the local address of the connection (tcp, tcp+tls and udp only),
+// or nil if the client is not connected.
+func (mc *ModbusClient) LocalAddr() net.Addr {
+	if mc.parent != nil {
+		return mc.parent.LocalAddr()
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if tt, ok := mc.transport.(*tcpTransport); ok && mc.connected {
+		local, _ := tt.addrs()
+		return local
+	}
+
+	return nil
+}
+
+// Returns the remote address of the connection (tcp, tcp+tls and udp only),
+// or nil if the client is not connected.
+func (mc *ModbusClient) RemoteAddr() net.Addr {
+	if mc.parent != nil {
+		return mc.parent.RemoteAddr()
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if tt, ok := mc.transport.(*tcpTransport); ok && mc.connected {
+		_, remote := tt.addrs()
+		return remote
+	}
+
+	return nil
+}
+
+// Returns traffic counters of the connection (tcp, tcp+tls and udp only),
+// accumulated since the last call to Open() (reconnections made by the
+// retry policy or by keep-alive probes included).
+func (mc *ModbusClient) Stats() (stats ConnStats) {
+	if mc.parent != nil {
+		return mc.parent.Stats()
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if tt, ok := mc.transport.(*tcpTransport); ok {
+		stats = tt.stats()
+	}
+
+	return
+}
+
+// Returns the most recent frames sent and received, oldest first, across
+// reconnections (tcp, tcp+tls and udp only).
+// Returns nil unless FrameHistory is set in the client configuration.
+func (mc *ModbusClient) RecentFrames() []FrameRecord {
+	if mc.parent != nil {
+		return mc.parent.RecentFrames()
+	}
+
+	if mc.history == nil {
+		return nil
+	}
+
+	return mc.history.snapshot()
+}
+
+// Sets the encoding (endianness and word ordering) of subsequent requests.
+// For a 32-bit value 0xAABBCCDD, the four usual register layouts map to:
+//   - ABCD: BIG_ENDIAN, HIGH_WORD_FIRST (modbus spec, default),
+//   - CDAB: BIG_ENDIAN, LOW_WORD_FIRST (word swap),
+//   - BADC: LITTLE_ENDIAN, HIGH_WORD_FIRST (byte swap),
+//   - DCBA: LITTLE_ENDIAN, LOW_WORD_FIRST (byte and word swap).
+func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if endianness != BIG_ENDIAN && endianness != LITTLE_ENDIAN {
+		mc.logger.Errorf("unknown endianness value %v", endianness)
+		return ErrUnexpectedParameters
+	}
+
+	if wordOrder != HIGH_WORD_FIRST && wordOrder != LOW_WORD_FIRST {
+		mc.logger.Errorf("unknown word order value %v", wordOrder)
+		return ErrUnexpectedParameters
+	}
+
+	mc.endianness = endianness
+	mc.wordOrder = wordOrder
+	return nil
+}
+
+// Sets the default byte order used to decode and encode register values in
+// subsequent requests, leaving the word ordering untouched.
+// Registers always travel big-endian on the wire as mandated by the spec:
+// this setting only affects how register contents are interpreted as
+// integers, floats and bytes, not protocol fields such as MBAP headers,
+// addresses or quantities. Devices storing values in a different byte order
+// can be addressed with their own settings through a handle (see
+// WithUnitId()), which inherits then overrides those of mc.
+func (mc *ModbusClient) SetEndianness(endianness Endianness) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if endianness != BIG_ENDIAN && endianness != LITTLE_ENDIAN {
+		mc.logger.Errorf("unknown endianness value %v", endianness)
+		return ErrUnexpectedParameters
+	}
+
+	mc.endianness = endianness
+	return nil
+}
+
+// Reads multiple coils (function code 01).
+func (mc *ModbusClient) ReadCoils(addr uint16, quantity uint16) ([]bool, error) {
+	return mc.readBools(addr, quantity, false)
+}
+
+// Reads a single coil (function code 01).
+func (mc *ModbusClient) ReadCoil(addr uint16) (bool, error) {
+	values, err := mc.readBools(addr, 1, false)
+	if err != nil {
+		return false, err
+	}
+	if len(values) < 1 {
+		return false, errors.New("no values")
+	}
+	return values[0], nil
+}
+
+// Reads multiple discrete inputs (function code 02).
+func (mc *ModbusClient) ReadDiscreteInputs(addr uint16, quantity uint16) ([]bool, error) {
+	return mc.readBools(addr, quantity, true)
+}
+
+// Reads a single discrete input (function code 02).
+func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (bool, error) {
+	values, err := mc.readBools(addr, 1, true)
+	if err != nil {
+		return false, err
+	}
+	if len(values) < 1 {
+		return false, errors.New("no values")
+	}
+	return values[0], nil
+}
+
+// Reads multiple 16-bit registers (function code 03 or 04).
+func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegType) ([]uint16, error) {
+	// read quantity uint16 registers, as bytes
+	mbPayload, err := mc.readRegisters(addr, quantity, regType)
+	if err != nil {
+		return nil, err
+	}
+	// decode payload bytes as uint16s
+	values := bytesToUint16s(mc.endianness, mbPayload)
+	return values, nil
+}
+
+// Reads quantity contiguous 16-bit holding registers (function code 03),
+// splitting the read into as many requests of at most 125 registers (or the
+// DeviceProfile limit) as needed, sent in order and all addressed to the
+// same unit id.
+// Should one of them fail, no further requests are sent and a *ChunkError
+// reporting the start address of the failed request and how many registers
+// were read is returned.
+// Note that other requests made on the same client may be interleaved
+// between chunks.
+func (mc *ModbusClient) ReadRegistersBlock(addr uint16, quantity uint16) (values []uint16, err error) {
+	var mbPayload []byte
+	var count uint16
+
+	if quantity == 0 {
+		mc.logger.Error("quantity of registers is 0")
+		return nil, ErrUnexpectedParameters
+	}
+
+	if uint32(addr)+uint32(quantity)-1 > 0xffff {
+		mc.logger.Error("end register address is past 0xffff")
+		return nil, ErrUnexpectedParameters
+	}
+
+	// keep the unit id consistent across chunks
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	values = make([]uint16, 0, quantity)
+	for done := uint16(0); done < quantity; done += count {
+		count = min(quantity-done, mc.maxReadRegisters())
+
+		mbPayload, err = mc.readUnitRegisters(unitId, addr+done, count, HOLDING_REGISTER)
+		if err != nil {
+			return nil, &ChunkError{
+				Addr: addr + done,
+				Done: int(done),
+				Err:  err,
+			}
+		}
+
+		values = append(values, bytesToUint16s(mc.endianness, mbPayload)...)
+	}
+
+	return
+}
+
+// Reads the 16-bit holding registers (function code 03) at the given,
+// possibly sparse addresses and returns their values keyed by address.
+// Addresses are grouped into windows of at most 125 registers (or the
+// DeviceProfile limit), merging addresses separated by gaps of up to maxGap
+// unrequested registers, so that a single request is sent per window. All
+// requests are addressed to the same unit id. Should one of them fail, no
+// further requests are sent and a *ChunkError reporting the start address of
+// the failed window and how many values were read is returned.
+func (mc *ModbusClient) ReadRegistersScattered(addrs []uint16, maxGap uint16) (values map[uint16]uint16, err error) {
+	var mbPayload []byte
+	var start, end uint16
+
+	if len(addrs) == 0 {
+		mc.logger.Error("no register addresses")
+		return nil, ErrUnexpectedParameters
+	}
+
+	// sort and deduplicate addresses
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+
+	// keep the unit id consistent across windows
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	values = make(map[uint16]uint16, len(addrs))
+	for i := 0; i < len(addrs); {
+		// grow the window as long as the gap to the next address is small
+		// enough and the window fits in a single request
+		start = addrs[i]
+		end = start
+		for i++; i < len(addrs); i++ {
+			if uint32(addrs[i])-uint32(end)-1 > uint32(maxGap) ||
+				uint32(addrs[i])-uint32(start)+1 > uint32(mc.maxReadRegisters()) {
+				break
+			}
+			end = addrs[i]
+		}
+
+		mbPayload, err = mc.readUnitRegisters(unitId, start, end-start+1, HOLDING_REGISTER)
+		if err != nil {
+			return nil, &ChunkError{
+				Addr: start,
+				Done: len(values),
+				Err:  err,
+			}
+		}
+
+		// only keep requested registers
+		regs := bytesToUint16s(mc.endianness, mbPayload)
+		for j := i - 1; j >= 0 && addrs[j] >= start; j-- {
+			values[addrs[j]] = regs[addrs[j]-start]
+		}
+	}
+
+	return
+}
+
+// Reads multiple 16-bit holding registers (function code 03).
+// quantity must be between 1 and 125.
+func (mc *ModbusClient) ReadHoldingRegisters(addr uint16, quantity uint16) ([]uint16, error) {
+	return mc.ReadRegisters(addr, quantity, HOLDING_REGISTER)
+}
+
+// Reads multiple 16-bit input registers (function code 04).
+// quantity must be between 1 and 125.
+func (mc *ModbusClient) ReadInputRegisters(addr uint16, quantity uint16) ([]uint16, error) {
+	return mc.ReadRegisters(addr, quantity, INPUT_REGISTER)
+}
+
+// Reads a single 16-bit register (function code 03 or 04).
+func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (value uint16, err error) {
+	// read 1 uint16 register, as bytes
+	values, err := mc.ReadRegisters(addr, 1, regType)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) < 1 {
+		return 0, errors.New("bo values")
+	}
+	return values[0], nil
+}
+
+// Reads multiple 32-bit registers.
+func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegType) ([]uint32, error) {
+	// read 2 * quantity uint16 registers, as bytes
+	mbPayload, err := mc.readRegisters(addr, quantity*2, regType)
+	if err != nil {
+		return nil, err
+	}
+	// decode payload bytes as uint32s
+	values := bytesToUint32s(mc.endianness, mc.wordOrder, mbPayload)
+	return values, nil
+}
+
+// Reads a single 32-bit register.
+func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (uint32, error) {
+	values, err := mc.ReadUint32s(addr, 1, regType)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) < 1 {
+		return 0, errors.New("no values")
+	}
+	return values[0], nil
+}
+
+// Reads quantity registers starting at ra, e.g. ParseRegisterAddress("40001")
+// for the first holding register. ra must refer to the holding or input
+// register table.
+func (mc *ModbusClient) ReadRegistersAt(ra RegisterAddress, quantity uint16) ([]uint16, error) {
+	regType, ok := ra.RegType()
+	if !ok {
+		mc.logger.Errorf("%v does not refer to a register", ra)
+		return nil, ErrUnexpectedParameters
+	}
+
+	return mc.ReadRegisters(ra.Addr, quantity, regType)
+}
+
+// Reads quantity coils or discrete inputs starting at ra, e.g.
+// ParseRegisterAddress("10001") for the first discrete input. ra must refer
+// to the coil or discrete input table.
+func (mc *ModbusClient) ReadBitsAt(ra RegisterAddress, quantity uint16) ([]bool, error) {
+	switch ra.Table {
+	case COILS_TABLE:
+		return mc.ReadCoils(ra.Addr, quantity)
+	case DISCRETE_INPUTS_TABLE:
+		return mc.ReadDiscreteInputs(ra.Addr, quantity)
+	}
+
+	mc.logger.Errorf("%v does not refer to a coil or discrete input", ra)
+	return nil, ErrUnexpectedParameters
+}
+
+// Reads multiple 16-bit registers as signed (two's complement) integers.
+func (mc *ModbusClient) ReadInt16s(addr uint16, quantity uint16, regType RegType) (values []int16, err error) {
+	var regs []uint16
+
+	regs, err = mc.ReadRegisters(addr, quantity, regType)
+	if err != nil {
+		return
+	}
+
+	values = make([]int16, len(regs))
+	for i, reg := range regs {
+		values[i] = int16(reg)
+	}
+
+	return
+}
+
+// Reads a single 16-bit register as a signed integer.
+func (mc *ModbusClient) ReadInt16(addr uint16, regType RegType) (value int16, err error) {
+	var reg uint16
+
+	reg, err = mc.ReadRegister(addr, regType)
+	if err == nil {
+		value = int16(reg)
+	}
+
+	return
+}
+
+// Reads multiple 32-bit registers as signed (two's complement) integers.
+func (mc *ModbusClient) ReadInt32s(addr uint16, quantity uint16, regType RegType) (values []int32, err error) {
+	var regs []uint32
+
+	regs, err = mc.ReadUint32s(addr, quantity, regType)
+	if err != nil {
+		return
+	}
+
+	values = make([]int32, len(regs))
+	for i, reg := range regs {
+		values[i] = int32(reg)
+	}
+
+	return
+}
+
+// Reads a single 32-bit register as a signed integer.
+func (mc *ModbusClient) ReadInt32(addr uint16, regType RegType) (value int32, err error) {
+	var reg uint32
+
+	reg, err = mc.ReadUint32(addr, regType)
+	if err == nil {
+		value = int32(reg)
+	}
+
+	return
+}
+
+// Reads multiple 32-bit float registers.
+// More than 62 values (125 registers, or the DeviceProfile limit) are read
+// in as many requests as needed, sent in order and all addressed to the
+// same unit id, without splitting values across requests. Should one of them
+// fail, no further requests are sent and a *ChunkError reporting how many
+// values were read is returned.
+// Note that other requests made on the same client may be interleaved
+// between chunks.
+func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegType) ([]float32, error) {
+	var mbPayload []byte
+	var count uint16
+	var err error
+
+	// single requests are sent as is
+	if quantity <= mc.maxReadRegisters()/2 {
+		// read 2 * quantity uint16 registers, as bytes
+		mbPayload, err = mc.readRegisters(addr, quantity*2, regType)
+		if err != nil {
+			return nil, err
+		}
+		// decode payload bytes as float32s
+		values := bytesToFloat32s(mc.endianness, mc.wordOrder, mbPayload)
+		return values, nil
+	}
+
+	if uint32(addr)+2*uint32(quantity)-1 > 0xffff {
+		mc.logger.Error("end register address is past 0xffff")
+		return nil, ErrUnexpectedParameters
+	}
+
+	// keep the unit id consistent across chunks
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	values := make([]float32, 0, quantity)
+	for done := uint16(0); done < quantity; done += count {
+		count = min(quantity-done, mc.maxReadRegisters()/2)
+
+		mbPayload, err = mc.readUnitRegisters(unitId, addr+2*done, 2*count, regType)
+		if err != nil {
+			return nil, &ChunkError{
+				Addr: addr + 2*done,
+				Done: int(done),
+				Err:  err,
+			}
+		}
+
+		values = append(values, bytesToFloat32s(mc.endianness, mc.wordOrder, mbPayload)...)
+	}
+
+	return values, nil
+}
+
+// Reads a single 32-bit float register.
+func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (float32, error) {
+	values, err := mc.ReadFloat32s(addr, 1, regType)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) < 1 {
+		return 0, errors.New("no values")
+	}
+	return values[0], nil
+}
+
+// Reads multiple 64-bit registers.
+func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegType) (values []uint64, err error) {
+	var mbPayload []byte
+
+	// read 4 * quantity uint16 registers, as bytes
+	mbPayload, err = mc.readRegisters(addr, quantity*4, regType)
+	if err != nil {
+		return
+	}
+
+	// decode payload bytes as uint64s
+	values = bytesToUint64s(mc.endianness, mc.wordOrder, mbPayload)
+
+	return
+}
+
+// Reads a single 64-bit register.
+func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (value uint64, err error) {
+	var values []uint64
+
+	values, err = mc.ReadUint64s(addr, 1, regType)
+	if err == nil {
+		value = values[0]
+	}
+
+	return
+}
+
+// Reads multiple 64-bit registers as signed (two's complement) integers.
+func (mc *ModbusClient) ReadInt64s(addr uint16, quantity uint16, regType RegType) (values []int64, err error) {
+	var regs []uint64
+
+	regs, err = mc.ReadUint64s(addr, quantity, regType)
+	if err != nil {
+		return
+	}
+
+	values = make([]int64, len(regs))
+	for i, reg := range regs {
+		values[i] = int64(reg)
+	}
+
+	return
+}
+
+// Reads a single 64-bit register as a signed integer.
+func (mc *ModbusClient) ReadInt64(addr uint16, regType RegType) (value int64, err error) {
+	var reg uint64
+
+	reg, err = mc.ReadUint64(addr, regType)
+	if err == nil {
+		value = int64(reg)
+	}
+
+	return
+}
+
+// Reads multiple 64-bit float registers.
+func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegType) (values []float64, err error) {
+	var mbPayload []byte
+
+	// read 4 * quantity uint16 registers, as bytes
+	mbPayload, err = mc.readRegisters(addr, quantity*4, regType)
+	if err != nil {
+		return
+	}
+
+	// decode payload bytes as float64s
+	values = bytesToFloat64s(mc.endianness, mc.wordOrder, mbPayload)
+
+	return
+}
+
+// Reads a single 64-bit float register.
+func (mc *ModbusClient) ReadFloat64(addr uint16, regType RegType) (value float64, err error) {
+	var values []float64
+
+	values, err = mc.ReadFloat64s(addr, 1, regType)
+	if err == nil {
+		value = values[0]
+	}
+
+	return
+}
+
+// Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
+// A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
+func (mc *ModbusClient) ReadBytes(addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
+	values, err = mc.readBytes(addr, quantity, regType, true)
+
+	return
+}
+
+// Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
+// No byte or word reordering is performed: bytes are returned exactly as they come
+// off the wire, allowing the caller to handle encoding/endianness/word order manually.
+func (mc *ModbusClient) ReadRawBytes(addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
+	values, err = mc.readBytes(addr, quantity, regType, false)
+
+	return
+}
+
+// Reads registerCount 16-bit registers (function code 03 or 04) as a string
+// of ASCII characters packed two per register.
+// Characters are unpacked high byte first, or low byte first if endianness is
+// set to LITTLE_ENDIAN. Trailing null bytes and spaces are trimmed.
+func (mc *ModbusClient) ReadString(addr uint16, registerCount uint16, regType RegType) (value string, err error) {
+	var values []byte
+
+	// validate here, as registerCount*2 would wrap around past 0x7fff
+	if registerCount == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers is 0")
+		return
+	}
+
+	if registerCount > mc.maxReadRegisters() {
+		err = ErrUnexpectedParameters
+		mc.logLimitExceeded("registers", mc.maxReadRegisters(), maxReadRegisters)
+		return
+	}
+
+	values, err = mc.readBytes(addr, registerCount*2, regType, true)
+	if err != nil {
+		return
+	}
+
+	value = strings.TrimRight(string(values), "\x00 ")
+
+	return
+}
+
+// Writes a single coil (function code 05)
+func (mc *ModbusClient) WriteCoil(addr uint16, value bool) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req := &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_WRITE_SINGLE_COIL,
+	}
+
+	// coil address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// coil value
+	if value {
+		req.payload = append(req.payload, 0xff, 0x00)
+	} else {
+		req.payload = append(req.payload, 0x00, 0x00)
+	}
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(req)
+	if err != nil {
+		return err
+	}
+
+	// broadcast requests get no response
+	if res == nil {
+		return nil
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the coil address and value
+		err = mc.checkWriteEcho(req, res)
+		if err != nil {
+			return err
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocol
+		}
+		return mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocol
+	}
+	return nil
+}
+
+// Writes multiple coils (function code 15)
+func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) error {
+	var req *pdu
+	var res *pdu
+	var quantity uint16
+	var encodedValues []byte
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	quantity = uint16(len(values))
+	if quantity == 0 {
+		mc.logger.Error("quantity of coils is 0")
+		return ErrUnexpectedParameters
+	}
+
+	if quantity > 0x7b0 {
+		mc.logger.Error("quantity of coils exceeds 1968")
+		return ErrUnexpectedParameters
+	}
+
+	if uint32(addr)+uint32(quantity)-1 > 0xffff {
+		mc.logger.Error("end coil address is past 0xffff")
+		return ErrUnexpectedParameters
+	}
+
+	encodedValues = encodeBools(values)
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_WRITE_MULTIPLE_COILS,
+	}
+
+	// start address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// quantity
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
+	// byte count
+	req.payload = append(req.payload, byte(len(encodedValues)))
+	// payload
+	req.payload = append(req.payload, encodedValues...)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(req)
+	if err != nil {
+		return err
+	}
+
+	// broadcast requests get no response
+	if res == nil {
+		return nil
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the base coil address and quantity of coils
+		err = mc.checkWriteEcho(req, res)
+		if err != nil {
+			return err
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocol
+		}
+		return mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocol
+	}
+	return nil
+}
+
+// Writes a single 16-bit register (function code 06).
+// The register is read back and compared if write verification is enabled
+// (see SetVerifyWrites()).
+func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	err = mc.writeRegister(unitId, addr, value)
+	if err == nil && mc.verifyWritesEnabled() {
+		err = mc.verifyRegisters(unitId, addr, uint16ToBytes(mc.endianness, value), 2)
+	}
+
+	return
+}
+
+// Writes a single 16-bit register to unitId (function code 06).
+func (mc *ModbusClient) writeRegister(unitId uint8, addr uint16, value uint16) error {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       unitId,
+		functionCode: FC_WRITE_SINGLE_REGISTER,
+	}
+
+	// register address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// register value
+	req.payload = append(req.payload, uint16ToBytes(mc.endianness, value)...)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(req)
+	if err != nil {
+		return err
+	}
+
+	// broadcast requests get no response
+	if res == nil {
+		return nil
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the register address and value
+		err = mc.checkWriteEcho(req, res)
+		if err != nil {
+			return err
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocol
+		}
+		return mapExceptionCodeToError(req.functionCode, res.payload[0])
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocol
+	}
+	return nil
+}
+
+// Reads a single 16-bit register and returns bit bitPos of its value, 0
+// being the least significant bit (e.g. to read flags of a status word).
+func (mc *ModbusClient) ReadBit(addr uint16, bitPos uint8, regType RegType) (bool, error) {
+	if bitPos > 15 {
+		mc.logger.Errorf("bit position %v is past 15", bitPos)
+		return false, ErrUnexpectedParameters
+	}
+
+	value, err := mc.ReadRegister(addr, regType)
+	if err != nil {
+		return false, err
+	}
+
+	return value&(1<<bitPos) != 0, nil
+}
+
+// Sets bit bitPos (0 being the least significant bit) of a single 16-bit
+// holding register to value, leaving other bits untouched.
+// The write is atomic when the device supports mask write register requests
+// (function code 22). Devices replying with ErrIllegalFunction get a
+// read-modify-write instead, which may overwrite concurrent changes to
+// other bits of the register.
+func (mc *ModbusClient) WriteBit(addr uint16, bitPos uint8, value bool) error {
+	var mask uint16
+	var orMask uint16
+
+	if bitPos > 15 {
+		mc.logger.Errorf("bit position %v is past 15", bitPos)
+		return ErrUnexpectedParameters
+	}
+
+	// bit positions apply to register values as decoded by ReadRegister(),
+	// while masks are always sent in big endian order
+	mc.lock.Lock()
+	mask = 1 << bitPos
+	if mc.endianness == LITTLE_ENDIAN {
+		mask = bits.ReverseBytes16(mask)
+	}
+	mc.lock.Unlock()
+
+	if value {
+		orMask = mask
+	}
+
+	err := mc.MaskWriteRegister(addr, ^mask, orMask)
+	if !errors.Is(err, ErrIllegalFunction) {
+		return err
+	}
+
+	reg, err := mc.ReadRegister(addr, HOLDING_REGISTER)
+	if err != nil {
+		return err
+	}
+
+	if value {
+		reg |= 1 << bitPos
+	} else {
+		reg &^= 1 << bitPos
+	}
+
+	return mc.WriteRegister(addr, reg)
+}
+
+// Atomically modifies a single 16-bit holding register (function code 22).
+// The device sets the register to
+// (current value AND andMask) OR (orMask AND (NOT andMask)),
+// i.e. bits cleared in andMask are taken from orMask while others are left
+// untouched. Masks are always sent in big endian order.
+func (mc *ModbusClient) MaskWriteRegister(addr uint16, andMask uint16, orMask uint16) error {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_MASK_WRITE_REGISTER,
+	}
+
+	// register address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// AND mask
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, andMask)...)
+	// OR mask
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, orMask)...)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(req)
+	if err != nil {
+		return err
+	}
+
+	// broadcast requests get no response
+	if res == nil {
+		return nil
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the request (2 bytes of address + 2 bytes of
+		// AND mask + 2 bytes of OR mask)
+		if len(res.payload) != 6 ||
+			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
+			bytesToUint16(BIG_ENDIAN, res.payload[2:4]) != andMask ||
+			bytesToUint16(BIG_ENDIAN, res.payload[4:6]) != orMask {
+			mc.logger.Warningf("unexpected mask write register echo (%x)", res.payload)
+			return ErrProtocol
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocol
+		}
+		return mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocol
+	}
+	return nil
+}
+
+// Writes writeValues to holding registers starting at writeAddr, then reads
+// readQty holding registers starting at readAddr, in a single transaction
+// (function code 23). The write is performed before the read.
+// readQty must be between 1 and 125 and writeValues must hold between 1 and
+// 121 values, as per the spec.
+func (mc *ModbusClient) ReadWriteRegisters(readAddr uint16, readQty uint16,
+	writeAddr uint16, writeValues []uint16) (values []uint16, err error) {
+	var req *pdu
+	var res *pdu
+	var writeQty uint16
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if readQty == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers to read is 0")
+		return
+	}
+
+	if readQty > maxReadWriteReadRegisters {
+		err = ErrUnexpectedParameters
+		mc.logger.Errorf("quantity of registers to read exceeds %v",
+			maxReadWriteReadRegisters)
+		return
+	}
+
+	if uint32(readAddr)+uint32(readQty)-1 > 0xffff {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("end read register address is past 0xffff")
+		return
+	}
+
+	writeQty = uint16(len(writeValues))
+	if writeQty == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers to write is 0")
+		return
+	}
+
+	if len(writeValues) > maxReadWriteWriteRegisters {
+		err = ErrUnexpectedParameters
+		mc.logger.Errorf("quantity of registers to write exceeds %v",
+			maxReadWriteWriteRegisters)
+		return
+	}
+
+	if uint32(writeAddr)+uint32(writeQty)-1 > 0xffff {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("end write register address is past 0xffff")
+		return
+	}
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_READ_WRITE_MULTIPLE_REGISTERS,
+	}
+
+	// read start address
+	req.payload = uint16ToBytes(BIG_ENDIAN, readAddr)
+	// read quantity
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, readQty)...)
+	// write start address
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, writeAddr)...)
+	// write quantity
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, writeQty)...)
+	// byte count
+	req.payload = append(req.payload, byte(2*writeQty))
+	// registers value
+	req.payload = append(req.payload, uint16sToBytes(mc.endianness, writeValues)...)
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of length + 2 bytes per register)
+		if len(res.payload) != 1+2*int(readQty) {
+			err = ErrProtocol
+			return
+		}
+
+		// validate the byte count field
+		if uint(res.payload[0]) != 2*uint(readQty) {
+			err = ErrProtocol
+			return
+		}
+
+		values = bytesToUint16s(mc.endianness, res.payload[1:])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads the description of the remote device (function code 17).
+// The contents of the server id are vendor-specific and returned as is.
+// runIndicator reflects the last byte of the response (0xff meaning ON).
+// Devices returning no data at all yield an empty id and a false
+// runIndicator.
+func (mc *ModbusClient) ReportServerID() (id []byte, runIndicator bool, err error) {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create the request object (no payload)
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_REPORT_SERVER_ID,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect at least the byte count field
+		if len(res.payload) < 1 {
+			err = ErrProtocol
+			return
+		}
+
+		// validate the byte count field
+		if int(res.payload[0]) != len(res.payload)-1 {
+			err = ErrProtocol
+			return
+		}
+
+		// the server id is followed by the run indicator status byte
+		id = []byte{}
+		if len(res.payload) > 1 {
+			id = res.payload[1 : len(res.payload)-1]
+			runIndicator = res.payload[len(res.payload)-1] == 0xff
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Returns the 8 exception status coils of the device (function code 07) as
+// a byte, coil 0 being the least significant bit. What the coils report is
+// device specific; the short request and response make this a cheap health
+// check on serial lines.
+func (mc *ModbusClient) ReadExceptionStatus() (status uint8, err error) {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create the request object (no payload)
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_READ_EXCEPTION_STATUS,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect a single byte of status
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		status = res.payload[0]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Runs a diagnostics sub-function (function code 08) and returns the data
+// field of the response (e.g. the value of a counter).
+// Sub-functions echoing their request (e.g. DIAG_RETURN_QUERY_DATA or
+// DIAG_CLEAR_COUNTERS) are checked to have returned data unchanged.
+// Note that devices do not reply to DIAG_FORCE_LISTEN_ONLY_MODE requests,
+// which are thus expected to time out.
+func (mc *ModbusClient) Diagnostics(subFunction uint16, data uint16) (value uint16, err error) {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_DIAGNOSTICS,
+	}
+
+	// sub-function
+	req.payload = uint16ToBytes(BIG_ENDIAN, subFunction)
+	// data
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, data)...)
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 4 bytes (2 bytes of sub-function + 2 bytes of data)
+		if len(res.payload) != 4 ||
+			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != subFunction {
+			err = ErrProtocol
+			return
+		}
+
+		value = bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+
+		switch subFunction {
+		case DIAG_RETURN_QUERY_DATA,
+			DIAG_RESTART_COMMUNICATIONS,
+			DIAG_CHANGE_ASCII_INPUT_DELIMITER,
+			DIAG_CLEAR_COUNTERS,
+			DIAG_CLEAR_OVERRUN_COUNTER:
+			if value != data {
+				mc.logger.Warningf("unexpected diagnostics echo (%x)", res.payload)
+				err = ErrProtocol
+				return
+			}
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Returns the value of a diagnostics counter (function code 08), counter
+// being one of the DIAG_RETURN_*_COUNT sub-functions, from
+// DIAG_RETURN_BUS_MESSAGE_COUNT to DIAG_RETURN_BUS_CHAR_OVERRUN_COUNT.
+// Note that some TCP gateways expose the counters of the serial bus behind
+// them, while others reply with ErrIllegalFunction.
+func (mc *ModbusClient) DiagnosticCounter(counter uint16) (value uint16, err error) {
+	if counter < DIAG_RETURN_BUS_MESSAGE_COUNT ||
+		counter > DIAG_RETURN_BUS_CHAR_OVERRUN_COUNT {
+		mc.logger.Errorf("unexpected diagnostics counter (0x%04x)", counter)
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	value, err = mc.Diagnostics(counter, 0x0000)
+
+	return
+}
+
+// Returns the status word (0xffff while the device is still processing a
+// previous command, 0x0000 otherwise) and the communication event counter of
+// the device (function code 11).
+func (mc *ModbusClient) GetCommEventCounter() (status uint16, eventCount uint16, err error) {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create the request object (no payload)
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_GET_COMM_EVENT_COUNTER,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 4 bytes (2 bytes of status + 2 bytes of event count)
+		if len(res.payload) != 4 {
+			err = ErrProtocol
+			return
+		}
+
+		status = bytesToUint16(BIG_ENDIAN, res.payload[0:2])
+		eventCount = bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Returns the status word, event counter, message counter and the raw
+// event log (up to 64 bytes, most recent event first) of the device
+// (function code 12).
+func (mc *ModbusClient) GetCommEventLog() (status uint16, eventCount uint16,
+	messageCount uint16, events []byte, err error) {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create the request object (no payload)
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_GET_COMM_EVENT_LOG,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect at least 7 bytes (1 byte of byte count, 2 bytes of status,
+		// 2 bytes of event count and 2 bytes of message count)
+		if len(res.payload) < 7 {
+			err = ErrProtocol
+			return
+		}
+
+		// validate the byte count field
+		if int(res.payload[0]) != len(res.payload)-1 {
+			err = ErrProtocol
+			return
+		}
+
+		status = bytesToUint16(BIG_ENDIAN, res.payload[1:3])
+		eventCount = bytesToUint16(BIG_ENDIAN, res.payload[3:5])
+		messageCount = bytesToUint16(BIG_ENDIAN, res.payload[5:7])
+		events = res.payload[7:]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads the contents of the FIFO queue of registers whose pointer register
+// is at addr (function code 24). Queues hold up to 31 registers, and are
+// returned oldest value first.
+func (mc *ModbusClient) ReadFIFOQueue(addr uint16) (values []uint16, err error) {
+	var req *pdu
+	var res *pdu
+	var byteCount uint16
+	var fifoCount uint16
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_READ_FIFO_QUEUE,
+		payload:      uint16ToBytes(BIG_ENDIAN, addr),
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect at least 4 bytes (2 bytes of byte count + 2 bytes of
+		// FIFO count)
+		if len(res.payload) < 4 {
+			err = ErrProtocol
+			return
+		}
+
+		byteCount = bytesToUint16(BIG_ENDIAN, res.payload[0:2])
+		fifoCount = bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+
+		// the byte count covers the FIFO count field and 2 bytes per
+		// register, and should match the payload length
+		if fifoCount > maxFIFOCount ||
+			int(byteCount) != 2+2*int(fifoCount) ||
+			int(byteCount) != len(res.payload)-2 {
+			mc.logger.Warningf("inconsistent byte count (%v) and FIFO count (%v)",
+				byteCount, fifoCount)
+			err = ErrProtocol
+			return
+		}
+
+		values = bytesToUint16s(mc.endianness, res.payload[4:])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads recordLength registers from file fileNumber, starting at record
+// recordNumber (function code 20).
+func (mc *ModbusClient) ReadFileRecord(fileNumber uint16, recordNumber uint16,
+	recordLength uint16) ([]uint16, error) {
+	values, err := mc.ReadFileRecords([]FileRecord{{
+		FileNumber:   fileNumber,
+		RecordNumber: recordNumber,
+		RecordLength: recordLength,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return values[0], nil
+}
+
+// Reads multiple file records in a single request (function code 20).
+// Registers read are returned in the same order as sub-requests.
+// The whole batch must fit in a single PDU, i.e. at most 35 sub-requests
+// and 245 bytes of response data (2 bytes per register plus 2 bytes per
+// sub-request).
+func (mc *ModbusClient) ReadFileRecords(records []FileRecord) (values [][]uint16, err error) {
+	var req *pdu
+	var res *pdu
+	var responseLength int
+	var offset int
+	var length int
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if len(records) == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("no file record to read")
+		return
+	}
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_READ_FILE_RECORD,
+		payload:      []byte{0x00},
+	}
+
+	for _, record := range records {
+		err = mc.validateFileRecord(record.FileNumber, record.RecordNumber, record.RecordLength)
+		if err != nil {
+			return
+		}
+
+		// each sub-response holds a length, a reference type and
+		// 2 bytes per register
+		responseLength += 2 + 2*int(record.RecordLength)
+
+		// reference type, file number, record number and record length
+		req.payload = append(req.payload, fileRecordRefType)
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordLength)...)
+	}
+
+	if len(req.payload)-1 > maxReadFileRecordByteCount ||
+		responseLength > maxReadFileRecordByteCount {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("file record sub-requests do not fit in a single request")
+		return
+	}
+
+	// byte count
+	req.payload[0] = byte(len(req.payload) - 1)
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of length + sub-responses)
+		if len(res.payload) != 1+responseLength ||
+			int(res.payload[0]) != responseLength {
+			err = ErrProtocol
+			return
+		}
+
+		// decode sub-responses
+		offset = 1
+		for _, record := range records {
+			// validate the sub-response length (reference type + 2 bytes
+			// per register) and reference type fields
+			length = int(res.payload[offset])
+			if length != 1+2*int(record.RecordLength) ||
+				res.payload[offset+1] != fileRecordRefType {
+				mc.logger.Warningf("unexpected file record sub-response header (%x)",
+					res.payload[offset:offset+2])
+				values = nil
+				err = ErrProtocol
+				return
+			}
+
+			values = append(values, bytesToUint16s(mc.endianness,
+				res.payload[offset+2:offset+1+length]))
+			offset += 1 + length
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocol
+			return
+		}
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		err = ErrProtocol
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Writes values to file fileNumber, starting at record recordNumber
+// (function code 21).
+func (mc *ModbusClient) WriteFileRecord(fileNumber uint16, recordNumber uint16, values []uint16) error {
+	return mc.WriteFileRecords([]FileRecord{{
+		FileNumber:   fileNumber,
+		RecordNumber: recordNumber,
+		Values:       values,
+	}})
+}
+
+// Writes multiple file records in a single request (function code 21).
+// The RecordLength field of sub-requests is ignored, the length of Values
+// being used instead.
+// The whole batch must fit in a single PDU, i.e. at most 251 bytes of
+// request data (2 bytes per register plus 7 bytes per sub-request).
+func (mc *ModbusClient) WriteFileRecords(records []FileRecord) (err error) {
+	var req *pdu
+	var res *pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if len(records) == 0 {
+		mc.logger.Error("no file record to write")
+		return ErrUnexpectedParameters
+	}
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: FC_WRITE_FILE_RECORD,
+		payload:      []byte{0x00},
+	}
+
+	for _, record := range records {
+		err = mc.validateFileRecord(record.FileNumber, record.RecordNumber,
+			uint16(min(len(record.Values), 0xffff)))
+		if err != nil {
+			return
+		}
+
+		if len(req.payload)-1+7+2*len(record.Values) > maxWriteFileRecordByteCount {
+			mc.logger.Error("file record sub-requests do not fit in a single request")
+			return ErrUnexpectedParameters
+		}
+
+		// reference type, file number, record number, record length
+		// and record data
+		req.payload = append(req.payload, fileRecordRefType)
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, uint16(len(record.Values)))...)
+		req.payload = append(req.payload, uint16sToBytes(mc.endianness, record.Values)...)
+	}
+
+	// byte count
+	req.payload[0] = byte(len(req.payload) - 1)
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the request
+		if string(res.payload) != string(req.payload) {
+			mc.logger.Warning("unexpected write file record echo")
+			return ErrProtocol
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocol
+		}
+
+		return mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocol
+	}
+
+	return
+}
+
+// Reads device identification objects (function code 43 / MEI type 14).
+// readDeviceIdCode selects the access type (one of DEVICE_ID_BASIC,
+// DEVICE_ID_REGULAR, DEVICE_ID_EXTENDED or DEVICE_ID_SPECIFIC) and objectId
+// the first object to read (or the only one with DEVICE_ID_SPECIFIC).
+// Should the device not fit all objects in a single response, as many
+// requests as needed are issued to assemble the full object map.
+// Object values are returned as is, along with the conformity level
+// reported by the device.
+func (mc *ModbusClient) ReadDeviceIdentification(readDeviceIdCode uint8, objectId uint8) (
+	objects map[uint8][]byte, conformityLevel uint8, err error) {
+	var req *pdu
+	var res *pdu
+	var moreFollows bool
+	var nextObjectId uint8
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if readDeviceIdCode < DEVICE_ID_BASIC || readDeviceIdCode > DEVICE_ID_SPECIFIC {
+		err = ErrUnexpectedParameters
+		mc.logger.Errorf("unexpected read device id code (%v)", readDeviceIdCode)
+		return
+	}
+
+	objects = make(map[uint8][]byte)
+
+	for {
+		// create and fill in the request object
+		req = &pdu{
+			unitId:       mc.unitId,
+			functionCode: FC_ENCAPSULATED_INTERFACE,
+			payload:      []byte{meiReadDeviceId, readDeviceIdCode, objectId},
+		}
+
+		// run the request across the transport and wait for a response
+		res, err = mc.executeRequest(req)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// validate the response code
+		switch {
+		case res.functionCode == req.functionCode:
+			conformityLevel, moreFollows, nextObjectId, err =
+				decodeDeviceIdResponse(readDeviceIdCode, res.payload, objects)
+			if err != nil {
+				return nil, 0, err
+			}
+
+		case res.functionCode == (req.functionCode | 0x80):
+			if len(res.payload) != 1 {
+				return nil, 0, ErrProtocol
+			}
+
+			return nil, 0, mapExceptionCodeToError(req.functionCode, res.payload[0])
+
+		default:
+			mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+			return nil, 0, ErrProtocol
+		}
+
+		if !moreFollows || readDeviceIdCode == DEVICE_ID_SPECIFIC {
+			return
+		}
+
+		// make sure the device is making progress to avoid looping forever
+		if nextObjectId <= objectId {
+			mc.logger.Warningf("unexpected next object id (%v)", nextObjectId)
+			return nil, 0, ErrProtocol
+		}
+		objectId = nextObjectId
+	}
 }
 
-// Reads multiple 64-bit float registers.
-func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegType) (values []float64, err error) {
-	var mbPayload []byte
+// Writes multiple 16-bit registers (function code 16).
+// Slices of more than 123 values (or the DeviceProfile limit) are split into
+// as many requests as needed, sent in order and all addressed to the same
+// unit id. Should one of them fail, no further requests are sent and a
+// *ChunkError reporting how many registers were written is returned.
+// Note that other requests made on the same client may be interleaved
+// between chunks.
+func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) (err error) {
+	var payload []byte
+	var quantity int
+
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint16ToBytes(mc.endianness, value)...)
+	}
+
+	// single requests are sent as is
+	if len(values) <= int(mc.maxWriteRegisters()) {
+		return mc.writeRegisters(addr, payload)
+	}
+
+	if uint32(addr)+uint32(len(values))-1 > 0xffff {
+		mc.logger.Error("end register address is past 0xffff")
+		return ErrUnexpectedParameters
+	}
+
+	// keep the unit id consistent across chunks
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	for done := 0; done < len(values); done += quantity {
+		quantity = min(len(values)-done, int(mc.maxWriteRegisters()))
 
-	// read 4 * quantity uint16 registers, as bytes
-	mbPayload, err = mc.readRegisters(addr, quantity*4, regType)
-	if err != nil {
-		return
+		err = mc.writeUnitRegisters(unitId, addr+uint16(done), payload[2*done:2*(done+quantity)])
+		if err != nil {
+			return &ChunkError{
+				Addr: addr + uint16(done),
+				Done: done,
+				Err:  err,
+			}
+		}
 	}
 
-	// decode payload bytes as float64s
-	values = bytesToFloat64s(mc.endianness, mc.wordOrder, mbPayload)
-
 	return
 }
 
-// Reads a single 64-bit float register.
-func (mc *ModbusClient) ReadFloat64(addr uint16, regType RegType) (value float64, err error) {
-	var values []float64
+// Writes multiple 32-bit registers.
+func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) (err error) {
+	var payload []byte
 
-	values, err = mc.ReadFloat64s(addr, 1, regType)
-	if err == nil {
-		value = values[0]
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
+	err = mc.writeRegisters(addr, payload)
+
 	return
 }
 
-// Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
-// A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
-func (mc *ModbusClient) ReadBytes(addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
-	values, err = mc.readBytes(addr, quantity, regType, true)
+// Writes a single 32-bit register.
+func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) error {
+	return mc.writeRegisters(addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
+}
+
+// Writes multiple 16-bit registers as signed (two's complement) integers.
+func (mc *ModbusClient) WriteInt16s(addr uint16, values []int16) (err error) {
+	var regs = make([]uint16, len(values))
+
+	for i, value := range values {
+		regs[i] = uint16(value)
+	}
+
+	err = mc.WriteRegisters(addr, regs)
 
 	return
 }
 
-// Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
-// No byte or word reordering is performed: bytes are returned exactly as they come
-// off the wire, allowing the caller to handle encoding/endianness/word order manually.
-func (mc *ModbusClient) ReadRawBytes(addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
-	values, err = mc.readBytes(addr, quantity, regType, false)
+// Writes a single 16-bit register as a signed integer.
+func (mc *ModbusClient) WriteInt16(addr uint16, value int16) error {
+	return mc.WriteRegister(addr, uint16(value))
+}
+
+// Writes multiple 32-bit registers as signed (two's complement) integers.
+func (mc *ModbusClient) WriteInt32s(addr uint16, values []int32) (err error) {
+	var payload []byte
+
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, uint32(value))...)
+	}
+
+	err = mc.writeRegisters(addr, payload)
 
 	return
 }
 
-// Writes a single coil (function code 05)
-func (mc *ModbusClient) WriteCoil(addr uint16, value bool) error {
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+// Writes a single 32-bit register as a signed integer.
+func (mc *ModbusClient) WriteInt32(addr uint16, value int32) error {
+	return mc.writeRegisters(addr, uint32ToBytes(mc.endianness, mc.wordOrder, uint32(value)))
+}
 
-	// create and fill in the request object
-	req := &pdu{
-		unitId:       mc.unitId,
-		functionCode: fcWriteSingleCoil,
+// Writes multiple 32-bit float registers.
+// More than 61 values (122 registers, or the DeviceProfile limit) are
+// written in as many requests as needed, sent in order and all addressed to
+// the same unit id, without splitting values across requests. Should one of
+// them fail, no further requests are sent and a *ChunkError reporting how
+// many values were written is returned.
+// Note that other requests made on the same client may be interleaved
+// between chunks.
+func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) (err error) {
+	var payload []byte
+	var quantity int
+
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
-	// coil address
-	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// coil value
-	if value {
-		req.payload = append(req.payload, 0xff, 0x00)
-	} else {
-		req.payload = append(req.payload, 0x00, 0x00)
+	// single requests are sent as is
+	if len(values) <= int(mc.maxWriteRegisters()/2) {
+		return mc.writeRegisters(addr, payload)
 	}
 
-	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
-	if err != nil {
-		return err
+	if uint32(addr)+2*uint32(len(values))-1 > 0xffff {
+		mc.logger.Error("end register address is past 0xffff")
+		return ErrUnexpectedParameters
 	}
 
-	// validate the response code
-	switch {
-	case res.functionCode == req.functionCode:
-		// expect 4 bytes (2 byte of address + 2 bytes of value)
-		if len(res.payload) != 4 ||
-			// bytes 1-2 should be the coil address
-			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
-			// bytes 3-4 should either be {0xff, 0x00} or {0x00, 0x00}
-			// depending on the coil value
-			(value && res.payload[2] != 0xff) ||
-			res.payload[3] != 0x00 {
-			return ErrProtocol
-		}
+	// keep the unit id consistent across chunks
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
 
-	case res.functionCode == (req.functionCode | 0x80):
-		if len(res.payload) != 1 {
-			return ErrProtocol
-		}
-		return mapExceptionCodeToError(res.payload[0])
+	for done := 0; done < len(values); done += quantity {
+		quantity = min(len(values)-done, int(mc.maxWriteRegisters()/2))
 
-	default:
-		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
-		return ErrProtocol
+		err = mc.writeUnitRegisters(unitId, addr+uint16(2*done), payload[4*done:4*(done+quantity)])
+		if err != nil {
+			return &ChunkError{
+				Addr: addr + uint16(2*done),
+				Done: done,
+				Err:  err,
+			}
+		}
 	}
-	return nil
+
+	return
 }
 
-// Writes multiple coils (function code 15)
-func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) error {
-	var req *pdu
-	var res *pdu
-	var quantity uint16
-	var encodedValues []byte
+// Writes a single 32-bit float register.
+func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
+	err = mc.writeRegisters(addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	return
+}
 
-	quantity = uint16(len(values))
-	if quantity == 0 {
-		mc.logger.Error("quantity of coils is 0")
-		return ErrUnexpectedParameters
-	}
+// Writes multiple 64-bit registers.
+func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
+	var payload []byte
 
-	if quantity > 0x7b0 {
-		mc.logger.Error("quantity of coils exceeds 1968")
-		return ErrUnexpectedParameters
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
-	if uint32(addr)+uint32(quantity)-1 > 0xffff {
-		mc.logger.Error("end coil address is past 0xffff")
-		return ErrUnexpectedParameters
-	}
+	err = mc.writeRegisters(addr, payload)
 
-	encodedValues = encodeBools(values)
+	return
+}
 
-	// create and fill in the request object
-	req = &pdu{
-		unitId:       mc.unitId,
-		functionCode: fcWriteMultipleCoils,
-	}
+// Writes a single 64-bit register.
+func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) (err error) {
+	err = mc.writeRegisters(addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
 
-	// start address
-	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// quantity
-	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
-	// byte count
-	req.payload = append(req.payload, byte(len(encodedValues)))
-	// payload
-	req.payload = append(req.payload, encodedValues...)
+	return
+}
 
-	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
-	if err != nil {
-		return err
+// Writes multiple 64-bit registers as signed (two's complement) integers.
+func (mc *ModbusClient) WriteInt64s(addr uint16, values []int64) (err error) {
+	var payload []byte
+
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, uint64(value))...)
 	}
 
-	// validate the response code
-	switch {
-	case res.functionCode == req.functionCode:
-		// expect 4 bytes (2 byte of address + 2 bytes of quantity)
-		if len(res.payload) != 4 ||
-			// bytes 1-2 should be the base coil address
-			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
-			// bytes 3-4 should be the quantity of coils
-			bytesToUint16(BIG_ENDIAN, res.payload[2:4]) != quantity {
-			return ErrProtocol
-		}
+	err = mc.writeRegisters(addr, payload)
 
-	case res.functionCode == (req.functionCode | 0x80):
-		if len(res.payload) != 1 {
-			return ErrProtocol
-		}
-		return mapExceptionCodeToError(res.payload[0])
+	return
+}
 
-	default:
-		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
-		return ErrProtocol
+// Writes a single 64-bit register as a signed integer.
+func (mc *ModbusClient) WriteInt64(addr uint16, value int64) (err error) {
+	err = mc.writeRegisters(addr, uint64ToBytes(mc.endianness, mc.wordOrder, uint64(value)))
+
+	return
+}
+
+// Writes multiple 64-bit float registers.
+func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) (err error) {
+	var payload []byte
+
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
-	return nil
+
+	err = mc.writeRegisters(addr, payload)
+
+	return
 }
 
-// Writes a single 16-bit register (function code 06).
-func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) error {
+// Writes a single 64-bit float register.
+func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) (err error) {
+	err = mc.writeRegisters(addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
+
+	return
+}
+
+// Writes the given slice of bytes to 16-bit registers starting at addr.
+// A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
+// Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
+func (mc *ModbusClient) WriteBytes(addr uint16, values []byte) (err error) {
+	err = mc.writeBytes(addr, values, true)
+	return
+}
+
+// Writes the given slice of bytes to 16-bit registers starting at addr.
+// No byte or word reordering is performed: bytes are pushed to the wire as-is,
+// allowing the caller to handle encoding/endianness/word order manually.
+// Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
+func (mc *ModbusClient) WriteRawBytes(addr uint16, values []byte) (err error) {
+	err = mc.writeBytes(addr, values, false)
+
+	return
+}
+
+// Writes s to 16-bit registers starting at addr, packing characters two per
+// register (high byte first, or low byte first if endianness is set to
+// LITTLE_ENDIAN).
+// Strings of odd length are padded with a null byte.
+func (mc *ModbusClient) WriteString(addr uint16, s string) (err error) {
+	err = mc.writeBytes(addr, []byte(s), true)
+
+	return
+}
+
+// Sends an arbitrary request PDU to unitId and returns the response as is,
+// for vendor-specific or otherwise unsupported function codes.
+// The payload is neither interpreted nor validated, with one exception:
+// exception responses (function code with the high bit set) are returned
+// as the matching *ModbusError, along with the response function code and
+// payload.
+// Note that on rtu links, responses can only be delimited for function codes
+// known to this package: other function codes yield ErrProtocol.
+func (mc *ModbusClient) ExecuteRaw(unitId uint8, functionCode uint8, payload []byte) (
+	respFunctionCode uint8, respPayload []byte, err error) {
 	var req *pdu
 	var res *pdu
 
+	if functionCode == 0x00 || functionCode&0x80 != 0 {
+		mc.logger.Errorf("illegal function code 0x%02x", functionCode)
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	// function code + payload must fit in a 253-byte PDU
+	if len(payload) > 252 {
+		mc.logger.Error("payload exceeds 252 bytes")
+		err = ErrUnexpectedParameters
+		return
+	}
+
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
-	// create and fill in the request object
 	req = &pdu{
-		unitId:       mc.unitId,
-		functionCode: fcWriteSingleRegister,
+		unitId:       unitId,
+		functionCode: functionCode,
+		payload:      payload,
 	}
 
-	// register address
-	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// register value
-	req.payload = append(req.payload, uint16ToBytes(mc.endianness, value)...)
-
-	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err = mc.executeRequest(req)
 	if err != nil {
-		return err
+		return
 	}
 
-	// validate the response code
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
+	respFunctionCode = res.functionCode
+	respPayload = res.payload
+
 	switch {
 	case res.functionCode == req.functionCode:
-		// expect 4 bytes (2 byte of address + 2 bytes of value)
-		if len(res.payload) != 4 ||
-			// bytes 1-2 should be the register address
-			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
-			// bytes 3-4 should be the value
-			bytesToUint16(mc.endianness, res.payload[2:4]) != value {
-			return ErrProtocol
-		}
+		// leave the payload to the caller
 
 	case res.functionCode == (req.functionCode | 0x80):
 		if len(res.payload) != 1 {
-			return ErrProtocol
+			err = ErrProtocol
+			return
 		}
-		return mapExceptionCodeToError(res.payload[0])
+
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
+
 	default:
+		err = ErrProtocol
 		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
-		return ErrProtocol
 	}
-	return nil
-}
 
-// Writes multiple 16-bit registers (function code 16).
-func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) error {
-	var payload []byte
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, uint16ToBytes(mc.endianness, value)...)
-	}
-	return mc.writeRegisters(addr, payload)
+	return
 }
 
-// Writes multiple 32-bit registers.
-func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) (err error) {
-	var payload []byte
-
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
+// Probes unit ids startId to endId (inclusive) one after the other and
+// returns those which answered, e.g. to discover the devices present on a
+// new serial bus. Unit id 0 (broadcast) is skipped.
+// probe returns the function code and payload of the request sent to each
+// unit id, and defaults to reading the holding register at address 0 if nil.
+// Any response, exceptions included (e.g. ErrIllegalDataAddress for devices
+// without such register), counts as an answer, while timeouts and garbled
+// responses don't. Requests are subject to the client timeout and, on serial
+// links, inter-request delay.
+// Scanning stops on other errors (e.g. the connection was lost) or as soon
+// as ctx is done, in which case the unit ids found so far are returned along
+// with the error.
+func (mc *ModbusClient) ScanBus(ctx context.Context, startId uint8, endId uint8,
+	probe func(unitId uint8) (functionCode uint8, payload []byte)) (found []uint8, err error) {
+	var me *ModbusError
+
+	if startId > endId {
+		mc.logger.Errorf("start unit id (%v) is past end unit id (%v)", startId, endId)
+		return nil, ErrUnexpectedParameters
+	}
+
+	if probe == nil {
+		probe = func(uint8) (uint8, []byte) {
+			return FC_READ_HOLDING_REGISTERS, []byte{0x00, 0x00, 0x00, 0x01}
+		}
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	handle := mc.WithContext(ctx)
+	for id := int(max(startId, 1)); id <= int(endId); id++ {
+		functionCode, payload := probe(uint8(id))
+
+		_, _, err = handle.ExecuteRaw(uint8(id), functionCode, payload)
+		switch {
+		case ctx.Err() != nil:
+			return found, ctx.Err()
+		case err == nil || errors.As(err, &me):
+			found = append(found, uint8(id))
+		case errors.Is(err, ErrRequestTimedOut):
+		case errors.Is(err, ErrProtocol) || errors.Is(err, ErrBadCRC) ||
+			errors.Is(err, ErrShortFrame):
+			mc.logger.Warningf("garbled response from unit id %v: %v", id, err)
+		default:
+			return found, err
+		}
+	}
 
-	return
+	return found, nil
 }
 
-// Writes a single 32-bit register.
-func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) error {
-	return mc.writeRegisters(addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
-}
+// Checks that the device is reachable without issuing a business read, by
+// sending it the keep-alive probe (see KeepAliveConfig.Probe, a diagnostics
+// return query data request by default) with the unit id of the client.
+// Returns nil if the device answered, exceptions included (e.g. devices not
+// supporting diagnostics reply with ErrIllegalFunction).
+func (mc *ModbusClient) Ping() error {
+	var me *ModbusError
 
-// Writes multiple 32-bit float registers.
-func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) (err error) {
-	var payload []byte
+	mc.lock.Lock()
+	unitId, functionCode, payload := mc.keepAliveConfig().Probe()
+	mc.lock.Unlock()
 
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	_, _, err := mc.ExecuteRaw(unitId, functionCode, payload)
+	if errors.As(err, &me) {
+		return nil
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	return err
+}
 
-	return
+// Returns the most recent transport-level failure (e.g. a timeout, an i/o
+// error or a garbled response) encountered by the client or any of its
+// handles, or nil if none. Exception responses and context cancellations
+// are not recorded. The error is kept until superseded by another one, e.g.
+// for display on dashboards.
+func (mc *ModbusClient) LastError() error {
+	if mc.parent != nil {
+		return mc.parent.LastError()
+	}
+
+	if err := mc.lastErr.Load(); err != nil {
+		return *err
+	}
+
+	return nil
 }
 
-// Writes a single 32-bit float register.
-func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
-	err = mc.writeRegisters(addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
+/*** unexported methods ***/
+// Returns the keep-alive policy of the client, with the default probe filled
+// in if none was configured.
+func (mc *ModbusClient) keepAliveConfig() (ka KeepAliveConfig) {
+	var unitId uint8 = mc.unitId
+
+	ka = mc.conf.KeepAlive
+	if ka.Probe == nil {
+		ka.Probe = func() (uint8, uint8, []byte) {
+			// return query data sub-function, no data
+			return unitId, FC_DIAGNOSTICS, []byte{0x00, 0x00, 0x00, 0x00}
+		}
+	}
 
 	return
 }
 
-// Writes multiple 64-bit registers.
-func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
-	var payload []byte
+// Applies options shared by tcp, tcp+tls and udp transports.
+func (mc *ModbusClient) configureTCPTransport(tt *tcpTransport) {
+	tt.rawFrameFunc = mc.conf.RawFrameFunc
+	tt.maxSkippedFrames = mc.conf.MaxSkippedFrames
+	tt.retransmits = mc.conf.UDPRetransmits
+	tt.tolerantExceptions = mc.conf.TolerantExceptions
+	tt.protocolId = mc.conf.ProtocolId
+	tt.observer = mc.conf.Observer
+	tt.history = mc.history
 
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
+	switch {
+	case mc.conf.TxnIdMismatchFunc != nil:
+		tt.skipTxnId = mc.conf.TxnIdMismatchFunc
+	case mc.conf.TxnIdMismatch == TXN_ID_MISMATCH_FAIL:
+		tt.skipTxnId = func(uint16, uint16) bool { return false }
 	}
+}
 
-	err = mc.writeRegisters(addr, payload)
+// Connects to the remote host with TLS, forces the TLS handshake and returns
+// the wrapped TLS socket.
+func (mc *ModbusClient) dialTLS() (net.Conn, error) {
+	sock, err := dialTLS(mc.dialer(15*time.Second), mc.conf.URL,
+		&tls.Config{
+			Certificates: []tls.Certificate{
+				*mc.conf.TLSClientCert,
+			},
+			RootCAs: mc.conf.TLSRootCAs,
+		})
+	if err != nil {
+		return nil, err
+	}
 
-	return
+	mc.setTCPKeepAlive(sock.NetConn())
+
+	// wrap the TLS socket to work around write timeouts corrupting
+	// internal state
+	return newTLSSockWrapper(sock), nil
 }
 
-// Writes a single 64-bit register.
-func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) (err error) {
-	err = mc.writeRegisters(addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
+// Connects to the host part of URL over TCP.
+func (mc *ModbusClient) dialTCP() (net.Conn, error) {
+	sock, err := mc.dialer(5*time.Second).Dial("tcp", mc.conf.URL)
+	if err != nil {
+		return nil, err
+	}
 
-	return
+	mc.setTCPKeepAlive(sock)
+
+	return sock, nil
 }
 
-// Writes multiple 64-bit float registers.
-func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) (err error) {
-	var payload []byte
+// Opens a UDP socket to the host part of URL.
+func (mc *ModbusClient) dialUDP() (net.Conn, error) {
+	var dialer *net.Dialer = &net.Dialer{Timeout: 5 * time.Second}
 
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
+	if mc.conf.UDPLocalAddr != nil {
+		dialer.LocalAddr = mc.conf.UDPLocalAddr
 	}
 
-	err = mc.writeRegisters(addr, payload)
-
-	return
+	return dialer.Dial("udp", mc.conf.URL)
 }
 
-// Writes a single 64-bit float register.
-func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) (err error) {
-	err = mc.writeRegisters(addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
+// Returns a copy of the user-provided dialer, if any, or a new one,
+// with timeout as timeout if none is set.
+func (mc *ModbusClient) dialer(timeout time.Duration) *net.Dialer {
+	var dialer net.Dialer
 
-	return
-}
+	if mc.conf.Dialer != nil {
+		dialer = *mc.conf.Dialer
+	}
 
-// Writes the given slice of bytes to 16-bit registers starting at addr.
-// A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
-// Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
-func (mc *ModbusClient) WriteBytes(addr uint16, values []byte) (err error) {
-	err = mc.writeBytes(addr, values, true)
-	return
+	if dialer.Timeout == 0 {
+		dialer.Timeout = timeout
+	}
+
+	return &dialer
 }
 
-// Writes the given slice of bytes to 16-bit registers starting at addr.
-// No byte or word reordering is performed: bytes are pushed to the wire as-is,
-// allowing the caller to handle encoding/endianness/word order manually.
-// Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
-func (mc *ModbusClient) WriteRawBytes(addr uint16, values []byte) (err error) {
-	err = mc.writeBytes(addr, values, false)
+// Applies the TCP keep-alive options, if any, to sock.
+func (mc *ModbusClient) setTCPKeepAlive(sock net.Conn) {
+	tc, ok := sock.(*net.TCPConn)
+	if !ok || !mc.conf.TCPKeepAlive.Enable {
+		return
+	}
 
-	return
+	err := tc.SetKeepAliveConfig(mc.conf.TCPKeepAlive)
+	if err != nil {
+		mc.logger.Warningf("failed to set tcp keep-alive options: %v", err)
+	}
 }
 
-/*** unexported methods ***/
 // Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
 func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType, observeEndianness bool) (values []byte, err error) {
 	// read enough registers to get the requested number of bytes
@@ -885,9 +3395,9 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 		return
 	}
 
-	if quantity > 2000 {
+	if quantity > mc.maxReadCoils() {
 		err = ErrUnexpectedParameters
-		mc.logger.Error("quantity of coils/discrete inputs exceeds 2000")
+		mc.logLimitExceeded("coils/discrete inputs", mc.maxReadCoils(), maxReadCoils)
 		return
 	}
 
@@ -903,9 +3413,9 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 	}
 
 	if di {
-		req.functionCode = fcReadDiscreteInputs
+		req.functionCode = FC_READ_DISCRETE_INPUTS
 	} else {
-		req.functionCode = fcReadCoils
+		req.functionCode = FC_READ_COILS
 	}
 
 	// start address
@@ -949,7 +3459,7 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 			return
 		}
 
-		err = mapExceptionCodeToError(res.payload[0])
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
 
 	default:
 		err = ErrProtocol
@@ -959,24 +3469,108 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 	return
 }
 
+// Validates the addressing of a file record sub-request.
+func (mc *ModbusClient) validateFileRecord(fileNumber uint16, recordNumber uint16,
+	recordLength uint16) error {
+	if fileNumber == 0 {
+		mc.logger.Error("file number is 0")
+		return ErrUnexpectedParameters
+	}
+
+	if recordLength == 0 {
+		mc.logger.Error("record length is 0")
+		return ErrUnexpectedParameters
+	}
+
+	if uint32(recordNumber)+uint32(recordLength)-1 > maxFileRecordNumber {
+		mc.logger.Errorf("end record number is past 0x%04x", maxFileRecordNumber)
+		return ErrUnexpectedParameters
+	}
+
+	return nil
+}
+
+// Decodes the payload of a read device identification response, adding
+// objects to the objects map.
+func decodeDeviceIdResponse(readDeviceIdCode uint8, payload []byte, objects map[uint8][]byte) (
+	conformityLevel uint8, moreFollows bool, nextObjectId uint8, err error) {
+	var objectCount int
+	var offset int
+	var length int
+
+	// expect at least 6 bytes (MEI type, read device id code, conformity
+	// level, more follows, next object id and number of objects)
+	if len(payload) < 6 ||
+		payload[0] != meiReadDeviceId ||
+		payload[1] != readDeviceIdCode {
+		err = ErrProtocol
+		return
+	}
+
+	conformityLevel = payload[2]
+	moreFollows = payload[3] == 0xff
+	nextObjectId = payload[4]
+	objectCount = int(payload[5])
+
+	// each object is made of an id (1 byte), a length (1 byte) and a value
+	offset = 6
+	for i := 0; i < objectCount; i++ {
+		if offset+2 > len(payload) {
+			err = ErrProtocol
+			return
+		}
+
+		length = int(payload[offset+1])
+		if offset+2+length > len(payload) {
+			err = ErrProtocol
+			return
+		}
+
+		objects[payload[offset]] = payload[offset+2 : offset+2+length]
+		offset += 2 + length
+	}
+
+	if offset != len(payload) {
+		err = ErrProtocol
+	}
+
+	return
+}
+
 // Reads and returns quantity registers of type regType, as bytes.
 func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegType) (bytes []byte, err error) {
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	return mc.readUnitRegisters(unitId, addr, quantity, regType)
+}
+
+// Reads multiple 16-bit registers from unitId, as bytes.
+func (mc *ModbusClient) readUnitRegisters(unitId uint8, addr uint16, quantity uint16, regType RegType) (bytes []byte, err error) {
+	return mc.readUnitRegistersWidth(unitId, addr, quantity, regType, 2)
+}
+
+// Reads multiple registers of width bytes each (2, or 4 for Enron registers)
+// from unitId, as bytes.
+func (mc *ModbusClient) readUnitRegistersWidth(unitId uint8, addr uint16, quantity uint16, regType RegType, width int) (bytes []byte, err error) {
 	var req *pdu
 	var res *pdu
+	var maxQuantity, specLimit uint16 = mc.maxReadRegisters(), maxReadRegisters
 
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
 	// create and fill in the request object
 	req = &pdu{
-		unitId: mc.unitId,
+		unitId: unitId,
 	}
 
 	switch regType {
 	case HOLDING_REGISTER:
-		req.functionCode = fcReadHoldingRegisters
+		req.functionCode = FC_READ_HOLDING_REGISTERS
 	case INPUT_REGISTER:
-		req.functionCode = fcReadInputRegisters
+		req.functionCode = FC_READ_INPUT_REGISTERS
 	default:
 		err = ErrUnexpectedParameters
 		mc.logger.Errorf("unexpected register type (%v)", regType)
@@ -989,9 +3583,13 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 		return
 	}
 
-	if quantity > 125 {
+	if width == 4 {
+		maxQuantity, specLimit = maxReadEnronRegisters, maxReadEnronRegisters
+	}
+
+	if quantity > maxQuantity {
 		err = ErrUnexpectedParameters
-		mc.logger.Error("quantity of registers exceeds 125")
+		mc.logLimitExceeded("registers", maxQuantity, specLimit)
 		return
 	}
 
@@ -1016,15 +3614,15 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 	switch {
 	case res.functionCode == req.functionCode:
 		// make sure the payload length is what we expect
-		// (1 byte of length + 2 bytes per register)
-		if len(res.payload) != 1+2*int(quantity) {
+		// (1 byte of length + width bytes per register)
+		if len(res.payload) != 1+width*int(quantity) {
 			err = ErrProtocol
 			return
 		}
 
 		// validate the byte count field
-		// (2 bytes per register * number of registers)
-		if uint(res.payload[0]) != 2*uint(quantity) {
+		// (width bytes per register * number of registers)
+		if uint(res.payload[0]) != uint(width)*uint(quantity) {
 			err = ErrProtocol
 			return
 		}
@@ -1038,7 +3636,7 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 			return
 		}
 
-		err = mapExceptionCodeToError(res.payload[0])
+		err = mapExceptionCodeToError(req.functionCode, res.payload[0])
 
 	default:
 		err = ErrProtocol
@@ -1051,24 +3649,61 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 // Writes multiple registers starting from base address addr.
 // Register values are passed as bytes, each value being exactly 2 bytes.
 func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
+	return mc.writeRegistersWidth(addr, values, 2)
+}
+
+// Writes multiple registers of width bytes each (2, or 4 for Enron
+// registers).
+func (mc *ModbusClient) writeRegistersWidth(addr uint16, values []byte, width int) (err error) {
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	return mc.writeUnitRegistersWidth(unitId, addr, values, width)
+}
+
+// Writes multiple 16-bit registers to unitId.
+func (mc *ModbusClient) writeUnitRegisters(unitId uint8, addr uint16, values []byte) (err error) {
+	return mc.writeUnitRegistersWidth(unitId, addr, values, 2)
+}
+
+// Writes multiple registers of width bytes each to unitId, reading them back
+// if write verification is enabled.
+func (mc *ModbusClient) writeUnitRegistersWidth(unitId uint8, addr uint16, values []byte, width int) (err error) {
+	err = mc.writeRegistersRequest(unitId, addr, values, width)
+	if err == nil && mc.verifyWritesEnabled() {
+		err = mc.verifyRegisters(unitId, addr, values, width)
+	}
+
+	return
+}
+
+// Sends a write multiple registers request (function code 16) to unitId,
+// registers being width bytes each.
+func (mc *ModbusClient) writeRegistersRequest(unitId uint8, addr uint16, values []byte, width int) (err error) {
 	var req *pdu
 	var res *pdu
 	var payloadLength uint16
 	var quantity uint16
+	var maxQuantity, specLimit uint16 = mc.maxWriteRegisters(), maxWriteRegisters
 
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
 	payloadLength = uint16(len(values))
-	quantity = payloadLength / 2
+	quantity = payloadLength / uint16(width)
 
 	if quantity == 0 {
 		mc.logger.Error("quantity of registers is 0")
 		return ErrUnexpectedParameters
 	}
 
-	if quantity > 123 {
-		mc.logger.Error("quantity of registers exceeds 123")
+	if width == 4 {
+		maxQuantity, specLimit = maxWriteEnronRegisters, maxWriteEnronRegisters
+	}
+
+	if quantity > maxQuantity {
+		mc.logLimitExceeded("registers", maxQuantity, specLimit)
 		return ErrUnexpectedParameters
 	}
 
@@ -1079,13 +3714,13 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 
 	// create and fill in the request object
 	req = &pdu{
-		unitId:       mc.unitId,
-		functionCode: fcWriteMultipleRegisters,
+		unitId:       unitId,
+		functionCode: FC_WRITE_MULTIPLE_REGISTERS,
 	}
 
 	// base address
 	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// quantity of registers (2 bytes per register)
+	// quantity of registers (width bytes per register)
 	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
 	// byte count
 	req.payload = append(req.payload, byte(payloadLength))
@@ -1098,42 +3733,114 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 		return err
 	}
 
+	// broadcast requests get no response
+	if res == nil {
+		return nil
+	}
+
 	// validate the response code
 	switch {
 	case res.functionCode == req.functionCode:
-		// expect 4 bytes (2 byte of address + 2 bytes of quantity)
-		if len(res.payload) != 4 ||
-			// bytes 1-2 should be the base register address
-			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
-			// bytes 3-4 should be the quantity of registers (2 bytes per register)
-			bytesToUint16(BIG_ENDIAN, res.payload[2:4]) != quantity {
-			return ErrProtocol
-		}
+		// expect an echo of the base register address and quantity of
+		// registers
+		return mc.checkWriteEcho(req, res)
 
 	case res.functionCode == (req.functionCode | 0x80):
 		if len(res.payload) != 1 {
 			return ErrProtocol
 		}
-		return mapExceptionCodeToError(res.payload[0])
+		return mapExceptionCodeToError(req.functionCode, res.payload[0])
 
 	default:
-		err = ErrProtocol
 		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocol
+	}
+}
+
+// Checks that the response to a write request (function codes 05, 06, 15
+// and 16) echoes the address and value (or quantity) of the request, i.e.
+// the first 4 bytes of its payload, to catch devices or gateways writing
+// to the wrong place.
+func (mc *ModbusClient) checkWriteEcho(req *pdu, res *pdu) error {
+	if len(res.payload) != 4 {
+		mc.logger.Warningf("unexpected write echo length for function code "+
+			"0x%02x: expected 4 bytes, got %v (%x)",
+			req.functionCode, len(res.payload), res.payload)
+		return ErrProtocol
+	}
+
+	if !bytes.Equal(res.payload, req.payload[0:4]) {
+		mc.logger.Warningf("write echo mismatch for function code 0x%02x: "+
+			"sent address 0x%04x and value/quantity 0x%04x, got address "+
+			"0x%04x and value/quantity 0x%04x", req.functionCode,
+			bytesToUint16(BIG_ENDIAN, req.payload[0:2]),
+			bytesToUint16(BIG_ENDIAN, req.payload[2:4]),
+			bytesToUint16(BIG_ENDIAN, res.payload[0:2]),
+			bytesToUint16(BIG_ENDIAN, res.payload[2:4]))
+		return ErrProtocol
 	}
 
 	return nil
 }
 
-func (mc *ModbusClient) executeRequest(req *pdu) (*pdu, error) {
+// Note: expects mc.lock to be held by the caller.
+func (mc *ModbusClient) executeRequest(req *pdu) (res *pdu, err error) {
+	var t transport = mc.transport
+
+	// handles check the state of their parent (see unitTransport)
+	if !mc.connected && mc.transportType != modbusUnitHandle {
+		return nil, ErrNotConnected
+	}
+
+	if mc.conf.Pipelined {
+		// let other goroutines issue requests while this one is in flight
+		mc.lock.Unlock()
+		defer mc.lock.Lock()
+	}
+
+	if mc.sched != nil {
+		// let requests from handles queue up while this one waits for
+		// its turn
+		mc.lock.Unlock()
+		defer mc.lock.Lock()
+
+		// cannot fail without a context
+		mc.sched.acquire(context.Background(), req.unitId)
+		defer mc.sched.release()
+	}
+
+	if mc.conf.Observer != nil {
+		defer func(ts time.Time) {
+			observedErr := err
+			// report exception responses as errors
+			if err == nil && res != nil &&
+				res.functionCode == (req.functionCode|0x80) && len(res.payload) == 1 {
+				observedErr = mapExceptionCodeToError(req.functionCode, res.payload[0])
+			}
+			mc.conf.Observer.RequestCompleted(req.functionCode, time.Since(ts), observedErr)
+		}(time.Now())
+	}
+
 	// send the request over the wire, wait for and decode the response
-	res, err := mc.transport.ExecuteRequest(req)
+	res, err = t.ExecuteRequest(req)
 	if err != nil {
-		// map i/o timeouts to ErrRequestTimedOut
-		if os.IsTimeout(err) {
-			return nil, ErrRequestTimedOut
+		// make i/o timeouts match ErrRequestTimedOut while keeping the
+		// underlying error (but let context deadlines through)
+		var te *timeoutError
+		if os.IsTimeout(err) && !errors.Is(err, context.DeadlineExceeded) &&
+			!errors.As(err, &te) {
+			err = &timeoutError{err: err}
+		}
+		mc.setLastError(err)
+		if errors.Is(err, ErrProtocol) && mc.conf.DumpFramesOnProtocolError {
+			mc.dumpRecentFrames()
 		}
 		return nil, err
 	}
+	// broadcast requests (serial links only) get no response
+	if res == nil {
+		return nil, nil
+	}
 	// make sure the source unit id matches that of the request
 	if (res.functionCode&0x80) == 0x00 && res.unitId != req.unitId {
 		return nil, ErrBadUnitId
@@ -1145,3 +3852,149 @@ func (mc *ModbusClient) executeRequest(req *pdu) (*pdu, error) {
 	}
 	return res, nil
 }
+
+// Logs the frames kept in the history of the client, if any.
+func (mc *ModbusClient) dumpRecentFrames() {
+	records := mc.RecentFrames()
+
+	mc.logger.Warningf("protocol error, dumping %v recent frames", len(records))
+	for _, record := range records {
+		mc.logger.Warningf("%s %v %s", record.Time.Format(time.RFC3339Nano),
+			record.Direction, FormatFrame(record.Frame))
+	}
+}
+
+// Makes sure the limits of the profile are usable.
+func (dp DeviceProfile) validate() error {
+	switch {
+	case dp.MaxReadRegisters < 0 || dp.MaxReadRegisters == 1:
+		return fmt.Errorf("invalid device profile MaxReadRegisters (%v): must be 0 or at least 2",
+			dp.MaxReadRegisters)
+	case dp.MaxWriteRegisters < 0 || dp.MaxWriteRegisters == 1:
+		return fmt.Errorf("invalid device profile MaxWriteRegisters (%v): must be 0 or at least 2",
+			dp.MaxWriteRegisters)
+	case dp.MaxReadCoils < 0:
+		return fmt.Errorf("invalid device profile MaxReadCoils (%v): must not be negative",
+			dp.MaxReadCoils)
+	}
+
+	return nil
+}
+
+// Returns the maximum number of registers per read request.
+func (mc *ModbusClient) maxReadRegisters() uint16 {
+	return profileLimit(mc.conf.DeviceProfile.MaxReadRegisters, maxReadRegisters)
+}
+
+// Returns the maximum number of registers per write request.
+func (mc *ModbusClient) maxWriteRegisters() uint16 {
+	return profileLimit(mc.conf.DeviceProfile.MaxWriteRegisters, maxWriteRegisters)
+}
+
+// Returns the maximum number of coils or discrete inputs per read request.
+func (mc *ModbusClient) maxReadCoils() uint16 {
+	return profileLimit(mc.conf.DeviceProfile.MaxReadCoils, maxReadCoils)
+}
+
+// Logs that a quantity of what exceeds limit, pointing at the device profile
+// if it is to blame.
+func (mc *ModbusClient) logLimitExceeded(what string, limit uint16, specLimit uint16) {
+	if limit < specLimit {
+		mc.logger.Errorf("quantity of %s exceeds %v (device profile limit)", what, limit)
+	} else {
+		mc.logger.Errorf("quantity of %s exceeds %v", what, limit)
+	}
+}
+
+// Returns the device profile limit if set and lower than the spec limit,
+// or the spec limit otherwise.
+func profileLimit(limit int, specLimit uint16) uint16 {
+	if limit > 0 && limit < int(specLimit) {
+		return uint16(limit)
+	}
+
+	return specLimit
+}
+
+// Records err as the most recent transport-level failure, unless it stems
+// from the caller (context done, client not connected).
+func (mc *ModbusClient) setLastError(err error) {
+	if mc.parent != nil {
+		mc.parent.setLastError(err)
+		return
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrNotConnected) {
+		return
+	}
+
+	mc.lastErr.Store(&err)
+}
+
+// Transport used by unit id and context handles, running requests across
+// the transport of the parent client.
+type unitTransport struct {
+	parent *ModbusClient
+	ctx    context.Context
+}
+
+// Closing a handle leaves the parent's connection untouched.
+func (ut *unitTransport) Close() error {
+	return nil
+}
+
+// Runs a request across the parent's transport.
+func (ut *unitTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	var ctx context.Context = ut.ctx
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return ut.ExecuteRequestContext(ctx, req)
+}
+
+// Runs a request across the parent's transport, holding the parent's lock
+// unless pipelining or round-robin scheduling is enabled (in which case the
+// request waits for its turn instead).
+func (ut *unitTransport) ExecuteRequestContext(ctx context.Context, req *pdu) (*pdu, error) {
+	ut.parent.lock.Lock()
+	t := ut.parent.transport
+
+	// handles of handles defer the check to their own parent
+	if !ut.parent.connected && ut.parent.transportType != modbusUnitHandle {
+		ut.parent.lock.Unlock()
+		return nil, ErrNotConnected
+	}
+
+	switch {
+	case ut.parent.sched != nil:
+		sched := ut.parent.sched
+		ut.parent.lock.Unlock()
+
+		err := sched.acquire(ctx, req.unitId)
+		if err != nil {
+			return nil, err
+		}
+		defer sched.release()
+	case ut.parent.conf.Pipelined:
+		ut.parent.lock.Unlock()
+	default:
+		defer ut.parent.lock.Unlock()
+	}
+
+	if ct, ok := t.(contextTransport); ok {
+		return ct.ExecuteRequestContext(ctx, req)
+	}
+
+	return t.ExecuteRequest(req)
+}
+
+func (ut *unitTransport) ReadRequest() (*pdu, error) {
+	return nil, errors.New("unimplemented")
+}
+
+func (ut *unitTransport) WriteResponse(res *pdu) error {
+	return errors.New("unimplemented")
+}
@@ -0,0 +1,2067 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Transport replaying canned responses, used to exercise client-side
+// response validation against misbehaving devices.
+type testTransport struct {
+	// called with each request, returns the response to hand to the client
+	handler func(req *pdu) (*pdu, error)
+}
+
+func (tt *testTransport) Close() error {
+	return nil
+}
+
+func (tt *testTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	return tt.handler(req)
+}
+
+func (tt *testTransport) ReadRequest() (*pdu, error) {
+	return nil, errors.New("unimplemented")
+}
+
+func (tt *testTransport) WriteResponse(res *pdu) error {
+	return errors.New("unimplemented")
+}
+
+// Returns a client talking to unit id 1 through a testTransport.
+func newTestClient(handler func(req *pdu) (*pdu, error)) *ModbusClient {
+	return &ModbusClient{
+		logger:     newLogger("test-client", nil),
+		endianness: BIG_ENDIAN,
+		wordOrder:  HIGH_WORD_FIRST,
+		unitId:     1,
+		transport:  &testTransport{handler: handler},
+		connected:  true,
+	}
+}
+
+func TestClientReadDiscreteInputsResponseValidation(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var err error
+	var dis []bool
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != 0x02 {
+			t.Errorf("expected function code 0x02, got 0x%02x", req.functionCode)
+		}
+		return res, nil
+	})
+
+	// 10 inputs fit in 2 bytes, extra bits should be ignored
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x02,
+		payload:      []byte{0x02, 0x05, 0xfe},
+	}
+	dis, err = client.ReadDiscreteInputs(0x0000, 10)
+	if err != nil {
+		t.Errorf("ReadDiscreteInputs() should have succeeded, got: %v", err)
+	}
+	if len(dis) != 10 {
+		t.Fatalf("expected 10 values, got: %v", len(dis))
+	}
+	for i, v := range []bool{
+		true, false, true, false, false, false, false, false,
+		false, true,
+	} {
+		if dis[i] != v {
+			t.Errorf("expected %v at position %v, got: %v", v, i, dis[i])
+		}
+	}
+
+	// a byte count inconsistent with the quantity should be rejected
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x02,
+		payload:      []byte{0x01, 0x05},
+	}
+	_, err = client.ReadDiscreteInputs(0x0000, 10)
+	if err != ErrProtocol {
+		t.Errorf("ReadDiscreteInputs() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// so should a byte count field disagreeing with the payload length
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x02,
+		payload:      []byte{0x03, 0x05, 0x00},
+	}
+	_, err = client.ReadDiscreteInputs(0x0000, 10)
+	if err != ErrProtocol {
+		t.Errorf("ReadDiscreteInputs() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x82,
+		payload:      []byte{0x02},
+	}
+	_, err = client.ReadDiscreteInputs(0x0000, 10)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadDiscreteInputs() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// quantities outside of 1..2000 should be rejected
+	_, err = client.ReadDiscreteInputs(0x0000, 0)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadDiscreteInputs() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientReadCoilsResponseValidation(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var err error
+	var coils []bool
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		return res, nil
+	})
+
+	// 2000 coils take up 250 bytes
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x01,
+		payload:      append([]byte{250}, make([]byte, 250)...),
+	}
+	res.payload[250] = 0x80
+	coils, err = client.ReadCoils(0x0000, 2000)
+	if err != nil {
+		t.Errorf("ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 2000 || !coils[1999] || coils[1998] {
+		t.Errorf("unexpected coil values")
+	}
+
+	// a truncated bitfield should be rejected rather than under-fill the
+	// result...
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x01,
+		payload:      append([]byte{249}, make([]byte, 249)...),
+	}
+	_, err = client.ReadCoils(0x0000, 2000)
+	if err != ErrProtocol {
+		t.Errorf("ReadCoils() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// ... even if the byte count field claims otherwise
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x01,
+		payload:      append([]byte{250}, make([]byte, 249)...),
+	}
+	_, err = client.ReadCoils(0x0000, 2000)
+	if err != ErrProtocol {
+		t.Errorf("ReadCoils() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// 9 coils need 2 bytes
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x01,
+		payload:      []byte{0x01, 0xff},
+	}
+	_, err = client.ReadCoils(0x0000, 9)
+	if err != ErrProtocol {
+		t.Errorf("ReadCoils() should have returned ErrProtocol, got: %v", err)
+	}
+}
+
+func TestClientWriteSingleEchoValidation(t *testing.T) {
+	var client *ModbusClient
+	var echo []byte
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      req.payload,
+		}
+		if echo != nil {
+			res.payload = echo
+		}
+		return res, nil
+	})
+
+	// well-behaved devices echo the request back
+	err = client.WriteCoil(0x0010, false)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+	err = client.WriteCoil(0x0010, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+	err = client.WriteRegister(0x0020, 0x1234)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	// an ON echo to an OFF write should be rejected
+	echo = []byte{0x00, 0x10, 0xff, 0x00}
+	err = client.WriteCoil(0x0010, false)
+	if err != ErrProtocol {
+		t.Errorf("WriteCoil() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// so should an echo of the wrong address
+	echo = []byte{0x00, 0x11, 0xff, 0x00}
+	err = client.WriteCoil(0x0010, true)
+	if err != ErrProtocol {
+		t.Errorf("WriteCoil() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// or of a different register value
+	echo = []byte{0x00, 0x20, 0x12, 0x35}
+	err = client.WriteRegister(0x0020, 0x1234)
+	if err != ErrProtocol {
+		t.Errorf("WriteRegister() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// or of a short payload
+	echo = []byte{0x00, 0x20}
+	err = client.WriteRegister(0x0020, 0x1234)
+	if err != ErrProtocol {
+		t.Errorf("WriteRegister() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode | 0x80,
+			payload:      []byte{0x03},
+		}, nil
+	})
+	err = client.WriteRegister(0x0020, 0x1234)
+	if !errors.Is(err, ErrIllegalDataValue) {
+		t.Errorf("WriteRegister() should have returned ErrIllegalDataValue, got: %v", err)
+	}
+}
+
+func TestClientWriteMultipleEchoValidation(t *testing.T) {
+	var client *ModbusClient
+	var echo []byte
+	var fc uint8
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      req.payload[0:4],
+		}
+		if echo != nil {
+			res.payload = echo
+		}
+		if fc != 0 {
+			res.functionCode = fc
+		}
+		return res, nil
+	})
+
+	// well-behaved devices echo the address and quantity back
+	err = client.WriteCoils(0x0010, []bool{true, false, true})
+	if err != nil {
+		t.Errorf("WriteCoils() should have succeeded, got: %v", err)
+	}
+	err = client.WriteRegisters(0x0020, []uint16{0x1234, 0x5678})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	// an echo of the wrong address should be rejected
+	echo = []byte{0x00, 0x11, 0x00, 0x03}
+	err = client.WriteCoils(0x0010, []bool{true, false, true})
+	if err != ErrProtocol {
+		t.Errorf("WriteCoils() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// so should an echo of the wrong quantity
+	echo = []byte{0x00, 0x20, 0x00, 0x01}
+	err = client.WriteRegisters(0x0020, []uint16{0x1234, 0x5678})
+	if err != ErrProtocol {
+		t.Errorf("WriteRegisters() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// or of a long payload
+	echo = []byte{0x00, 0x20, 0x00, 0x02, 0x00}
+	err = client.WriteRegisters(0x0020, []uint16{0x1234, 0x5678})
+	if err != ErrProtocol {
+		t.Errorf("WriteRegisters() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// as should responses bearing an unexpected function code
+	echo = nil
+	fc = FC_READ_HOLDING_REGISTERS
+	err = client.WriteRegisters(0x0020, []uint16{0x1234, 0x5678})
+	if err != ErrProtocol {
+		t.Errorf("WriteRegisters() should have returned ErrProtocol, got: %v", err)
+	}
+}
+
+func TestClientMaskWriteRegister(t *testing.T) {
+	var client *ModbusClient
+	var echo []byte
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != 0x16 {
+			t.Errorf("expected function code 0x16, got 0x%02x", req.functionCode)
+		}
+		for i, b := range []byte{
+			0x00, 0x04, // register address
+			0x00, 0xf2, // AND mask
+			0x00, 0x25, // OR mask
+		} {
+			if req.payload[i] != b {
+				t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+					b, i, req.payload[i])
+			}
+		}
+
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      req.payload,
+		}
+		if echo != nil {
+			res.payload = echo
+		}
+		return res, nil
+	})
+
+	err = client.MaskWriteRegister(0x0004, 0x00f2, 0x0025)
+	if err != nil {
+		t.Errorf("MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+
+	// an echo of different masks should be rejected
+	echo = []byte{0x00, 0x04, 0x00, 0xf2, 0x00, 0x24}
+	err = client.MaskWriteRegister(0x0004, 0x00f2, 0x0025)
+	if err != ErrProtocol {
+		t.Errorf("MaskWriteRegister() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: 0x96,
+			payload:      []byte{0x02},
+		}, nil
+	})
+	err = client.MaskWriteRegister(0x0004, 0x00f2, 0x0025)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("MaskWriteRegister() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+}
+
+func TestClientReadWriteBit(t *testing.T) {
+	var client *ModbusClient
+	var reg uint16 = 0x8001
+	var maskWrite bool = true
+	var fcs []uint8
+	var err error
+
+	// device holding a single register, at address 0x0010
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		fcs = append(fcs, req.functionCode)
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      req.payload,
+		}
+
+		switch {
+		case req.functionCode == FC_READ_HOLDING_REGISTERS:
+			res.payload = append([]byte{0x02}, uint16ToBytes(BIG_ENDIAN, reg)...)
+		case req.functionCode == FC_WRITE_SINGLE_REGISTER:
+			reg = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		case req.functionCode == FC_MASK_WRITE_REGISTER && maskWrite:
+			andMask := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+			orMask := bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+			reg = (reg & andMask) | (orMask &^ andMask)
+		default:
+			res.functionCode |= 0x80
+			res.payload = []byte{0x01}
+		}
+		return res, nil
+	})
+
+	for bitPos, expected := range map[uint8]bool{0: true, 1: false, 15: true} {
+		value, err := client.ReadBit(0x0010, bitPos, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("ReadBit() should have succeeded, got: %v", err)
+		}
+		if value != expected {
+			t.Errorf("expected %v for bit %v, got: %v", expected, bitPos, value)
+		}
+	}
+
+	// writes should go through mask write register when supported
+	fcs = nil
+	err = client.WriteBit(0x0010, 3, true)
+	if err != nil {
+		t.Errorf("WriteBit() should have succeeded, got: %v", err)
+	}
+	err = client.WriteBit(0x0010, 15, false)
+	if err != nil {
+		t.Errorf("WriteBit() should have succeeded, got: %v", err)
+	}
+	if reg != 0x0009 || !slices.Equal(fcs, []uint8{0x16, 0x16}) {
+		t.Errorf("unexpected register value 0x%04x (function codes: %v)", reg, fcs)
+	}
+
+	// bit positions should follow the byte order of the client
+	client.SetEncoding(LITTLE_ENDIAN, HIGH_WORD_FIRST)
+	err = client.WriteBit(0x0010, 0, true)
+	if err != nil {
+		t.Errorf("WriteBit() should have succeeded, got: %v", err)
+	}
+	if reg != 0x0109 {
+		t.Errorf("unexpected register value 0x%04x", reg)
+	}
+	client.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+
+	// and fall back to read-modify-write otherwise
+	fcs = nil
+	maskWrite = false
+	err = client.WriteBit(0x0010, 0, false)
+	if err != nil {
+		t.Errorf("WriteBit() should have succeeded, got: %v", err)
+	}
+	if reg != 0x0108 || !slices.Equal(fcs, []uint8{0x16, 0x03, 0x06}) {
+		t.Errorf("unexpected register value 0x%04x (function codes: %v)", reg, fcs)
+	}
+
+	_, err = client.ReadBit(0x0010, 16, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadBit() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	err = client.WriteBit(0x0010, 16, true)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("WriteBit() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientReadWriteRegisters(t *testing.T) {
+	var client *ModbusClient
+	var regs []uint16
+	var err error
+	var calls int
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		calls++
+		if req.functionCode != 0x17 {
+			t.Errorf("expected function code 0x17, got 0x%02x", req.functionCode)
+		}
+		if len(req.payload) != 13 {
+			t.Fatalf("expected 13 bytes of payload, got %v", len(req.payload))
+		}
+		for i, b := range []byte{
+			0x00, 0x03, // read address
+			0x00, 0x02, // read quantity
+			0x00, 0x0e, // write address
+			0x00, 0x02, // write quantity
+			0x04,       // byte count
+			0x00, 0xff, // first value
+			0xab, 0xcd, // second value
+		} {
+			if req.payload[i] != b {
+				t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+					b, i, req.payload[i])
+			}
+		}
+
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{0x04, 0x00, 0xfe, 0x0a, 0xcd},
+		}, nil
+	})
+
+	regs, err = client.ReadWriteRegisters(0x0003, 2, 0x000e, []uint16{0x00ff, 0xabcd})
+	if err != nil {
+		t.Errorf("ReadWriteRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x00fe || regs[1] != 0x0acd {
+		t.Errorf("expected {0x00fe, 0x0acd}, got: %v", regs)
+	}
+
+	// out of range quantities should be rejected without hitting the wire
+	_, err = client.ReadWriteRegisters(0x0000, 0, 0x0000, []uint16{0x0001})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0x0000, 126, 0x0000, []uint16{0x0001})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0x0000, 1, 0x0000, nil)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0x0000, 1, 0x0000, make([]uint16, 122))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0xffff, 2, 0x0000, []uint16{0x0001})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 request on the wire, got %v", calls)
+	}
+}
+
+func TestClientReportServerID(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var id []byte
+	var runIndicator bool
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != 0x11 || len(req.payload) != 0 {
+			t.Errorf("unexpected request: 0x%02x, %v", req.functionCode, req.payload)
+		}
+		return res, nil
+	})
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x11,
+		payload:      []byte{0x04, 0x41, 0x42, 0x43, 0xff},
+	}
+	id, runIndicator, err = client.ReportServerID()
+	if err != nil {
+		t.Errorf("ReportServerID() should have succeeded, got: %v", err)
+	}
+	if string(id) != "ABC" {
+		t.Errorf("expected \"ABC\" as server id, got: %q", id)
+	}
+	if !runIndicator {
+		t.Error("expected the run indicator to be ON")
+	}
+
+	// a zero byte count should yield an empty id
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x11,
+		payload:      []byte{0x00},
+	}
+	id, runIndicator, err = client.ReportServerID()
+	if err != nil {
+		t.Errorf("ReportServerID() should have succeeded, got: %v", err)
+	}
+	if id == nil || len(id) != 0 || runIndicator {
+		t.Errorf("expected an empty id and an OFF run indicator, got: %v, %v", id, runIndicator)
+	}
+
+	// a byte count disagreeing with the payload length should be rejected
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x11,
+		payload:      []byte{0x03, 0x41, 0x00},
+	}
+	_, _, err = client.ReportServerID()
+	if err != ErrProtocol {
+		t.Errorf("ReportServerID() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// so should an empty payload
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x11,
+	}
+	_, _, err = client.ReportServerID()
+	if err != ErrProtocol {
+		t.Errorf("ReportServerID() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x91,
+		payload:      []byte{0x01},
+	}
+	_, _, err = client.ReportServerID()
+	if !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("ReportServerID() should have returned ErrIllegalFunction, got: %v", err)
+	}
+}
+
+func TestClientReadDeviceIdentification(t *testing.T) {
+	var client *ModbusClient
+	var objects map[uint8][]byte
+	var conformityLevel uint8
+	var err error
+	var requests [][]byte
+
+	// play the role of a device splitting its objects across 2 responses
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		requests = append(requests, req.payload)
+
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: 0x2b,
+		}
+		switch req.payload[2] {
+		case 0x00:
+			res.payload = []byte{
+				0x0e, 0x02, 0x82, 0xff, 0x02, 0x02,
+				0x00, 0x04, 'A', 'C', 'M', 'E',
+				0x01, 0x02, 'X', '1',
+			}
+		case 0x02:
+			res.payload = []byte{
+				0x0e, 0x02, 0x82, 0x00, 0x00, 0x03,
+				0x02, 0x03, '1', '.', '0',
+				0x03, 0x00,
+				0x04, 0x01, 'P',
+			}
+		default:
+			// loop back to the start
+			res.payload = []byte{0x0e, 0x02, 0x82, 0xff, 0x00, 0x00}
+		}
+		return res, nil
+	})
+
+	objects, conformityLevel, err = client.ReadDeviceIdentification(DEVICE_ID_REGULAR, OBJECT_ID_VENDOR_NAME)
+	if err != nil {
+		t.Fatalf("ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if conformityLevel != 0x82 {
+		t.Errorf("expected 0x82 as conformity level, got: 0x%02x", conformityLevel)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got: %v", len(requests))
+	}
+	if string(requests[1]) != string([]byte{0x0e, 0x02, 0x02}) {
+		t.Errorf("unexpected continuation request: %v", requests[1])
+	}
+	if len(objects) != 5 {
+		t.Errorf("expected 5 objects, got: %v", len(objects))
+	}
+	for id, v := range map[uint8]string{
+		OBJECT_ID_VENDOR_NAME:          "ACME",
+		OBJECT_ID_PRODUCT_CODE:         "X1",
+		OBJECT_ID_MAJOR_MINOR_REVISION: "1.0",
+		OBJECT_ID_VENDOR_URL:           "",
+		OBJECT_ID_PRODUCT_NAME:         "P",
+	} {
+		if string(objects[id]) != v {
+			t.Errorf("expected %q for object 0x%02x, got: %q", v, id, objects[id])
+		}
+	}
+
+	// devices not making progress should not make us loop forever
+	_, _, err = client.ReadDeviceIdentification(DEVICE_ID_REGULAR, 0x05)
+	if err != ErrProtocol {
+		t.Errorf("ReadDeviceIdentification() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// invalid read device id codes should be rejected
+	_, _, err = client.ReadDeviceIdentification(0x05, 0x00)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadDeviceIdentification() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// truncated object lists should be rejected
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: 0x2b,
+			payload:      []byte{0x0e, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x04, 'A'},
+		}, nil
+	})
+	_, _, err = client.ReadDeviceIdentification(DEVICE_ID_BASIC, 0x00)
+	if err != ErrProtocol {
+		t.Errorf("ReadDeviceIdentification() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: 0xab,
+			payload:      []byte{0x02},
+		}, nil
+	})
+	_, _, err = client.ReadDeviceIdentification(DEVICE_ID_SPECIFIC, 0x80)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadDeviceIdentification() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+}
+
+func TestClientDiagnostics(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var value uint16
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != 0x08 || len(req.payload) != 4 {
+			t.Errorf("unexpected request: 0x%02x, %v", req.functionCode, req.payload)
+		}
+		if res == nil {
+			// echo the request back
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      req.payload,
+			}, nil
+		}
+		return res, nil
+	})
+
+	value, err = client.Diagnostics(DIAG_RETURN_QUERY_DATA, 0xa537)
+	if err != nil {
+		t.Errorf("Diagnostics() should have succeeded, got: %v", err)
+	}
+	if value != 0xa537 {
+		t.Errorf("expected 0xa537, got: 0x%04x", value)
+	}
+
+	_, err = client.Diagnostics(DIAG_CLEAR_COUNTERS, 0x0000)
+	if err != nil {
+		t.Errorf("Diagnostics() should have succeeded, got: %v", err)
+	}
+
+	// counters should be returned as is
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x08,
+		payload:      []byte{0x00, 0x0b, 0x01, 0x2c},
+	}
+	value, err = client.Diagnostics(DIAG_RETURN_BUS_MESSAGE_COUNT, 0x0000)
+	if err != nil {
+		t.Errorf("Diagnostics() should have succeeded, got: %v", err)
+	}
+	if value != 300 {
+		t.Errorf("expected 300, got: %v", value)
+	}
+
+	// altered loopback data should be rejected
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x08,
+		payload:      []byte{0x00, 0x00, 0xa5, 0x36},
+	}
+	_, err = client.Diagnostics(DIAG_RETURN_QUERY_DATA, 0xa537)
+	if err != ErrProtocol {
+		t.Errorf("Diagnostics() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// so should a mismatched sub-function
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x08,
+		payload:      []byte{0x00, 0x0c, 0x00, 0x00},
+	}
+	_, err = client.Diagnostics(DIAG_RETURN_BUS_MESSAGE_COUNT, 0x0000)
+	if err != ErrProtocol {
+		t.Errorf("Diagnostics() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x88,
+		payload:      []byte{0x01},
+	}
+	_, err = client.Diagnostics(DIAG_RETURN_QUERY_DATA, 0x0000)
+	if !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("Diagnostics() should have returned ErrIllegalFunction, got: %v", err)
+	}
+}
+
+func TestClientDiagnosticCounter(t *testing.T) {
+	var client *ModbusClient
+	var subFunctions []uint16
+	var value uint16
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		subFunction := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		subFunctions = append(subFunctions, subFunction)
+		if req.functionCode != 0x08 ||
+			bytesToUint16(BIG_ENDIAN, req.payload[2:4]) != 0x0000 {
+			t.Errorf("unexpected request: 0x%02x, %v", req.functionCode, req.payload)
+		}
+
+		// return the sub-function code times 10 as counter value
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload: append(req.payload[0:2:2],
+				uint16ToBytes(BIG_ENDIAN, 10*subFunction)...),
+		}, nil
+	})
+
+	for _, counter := range []uint16{
+		DIAG_RETURN_BUS_MESSAGE_COUNT,
+		DIAG_RETURN_BUS_COMM_ERROR_COUNT,
+		DIAG_RETURN_BUS_EXCEPTION_ERROR_COUNT,
+		DIAG_RETURN_SERVER_MESSAGE_COUNT,
+		DIAG_RETURN_SERVER_NO_RESPONSE_COUNT,
+		DIAG_RETURN_SERVER_NAK_COUNT,
+		DIAG_RETURN_SERVER_BUSY_COUNT,
+		DIAG_RETURN_BUS_CHAR_OVERRUN_COUNT,
+	} {
+		value, err = client.DiagnosticCounter(counter)
+		if err != nil {
+			t.Errorf("DiagnosticCounter(0x%02x) should have succeeded, got: %v", counter, err)
+		}
+		if value != 10*counter {
+			t.Errorf("expected %v, got: %v", 10*counter, value)
+		}
+	}
+	if len(subFunctions) != 8 {
+		t.Errorf("expected 8 requests, got: %v", subFunctions)
+	}
+
+	// sub-functions other than counters should be rejected
+	subFunctions = nil
+	for _, counter := range []uint16{DIAG_CLEAR_COUNTERS, DIAG_CLEAR_OVERRUN_COUNTER} {
+		_, err = client.DiagnosticCounter(counter)
+		if err != ErrUnexpectedParameters {
+			t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+		}
+	}
+	if len(subFunctions) != 0 {
+		t.Errorf("no request should have been sent, got: %v", subFunctions)
+	}
+}
+
+func TestClientReadExceptionStatus(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var status uint8
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != 0x07 || len(req.payload) != 0 {
+			t.Errorf("unexpected request: 0x%02x, %v", req.functionCode, req.payload)
+		}
+		return res, nil
+	})
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x07,
+		payload:      []byte{0x6d},
+	}
+	status, err = client.ReadExceptionStatus()
+	if err != nil {
+		t.Errorf("ReadExceptionStatus() should have succeeded, got: %v", err)
+	}
+	if status != 0x6d {
+		t.Errorf("expected 0x6d, got: 0x%02x", status)
+	}
+
+	// responses should hold exactly one byte
+	for _, payload := range [][]byte{{}, {0x6d, 0x00}} {
+		res = &pdu{
+			unitId:       1,
+			functionCode: 0x07,
+			payload:      payload,
+		}
+		_, err = client.ReadExceptionStatus()
+		if err != ErrProtocol {
+			t.Errorf("ReadExceptionStatus() should have returned ErrProtocol, got: %v", err)
+		}
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x87,
+		payload:      []byte{0x01},
+	}
+	_, err = client.ReadExceptionStatus()
+	if !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("ReadExceptionStatus() should have returned ErrIllegalFunction, got: %v", err)
+	}
+}
+
+func TestClientCommEventCounterAndLog(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var status, eventCount, messageCount uint16
+	var events []byte
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if len(req.payload) != 0 {
+			t.Errorf("expected an empty request payload, got: %v", req.payload)
+		}
+		return res, nil
+	})
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x0b,
+		payload:      []byte{0xff, 0xff, 0x01, 0x08},
+	}
+	status, eventCount, err = client.GetCommEventCounter()
+	if err != nil {
+		t.Errorf("GetCommEventCounter() should have succeeded, got: %v", err)
+	}
+	if status != 0xffff || eventCount != 0x0108 {
+		t.Errorf("unexpected status/event count: 0x%04x, 0x%04x", status, eventCount)
+	}
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x0b,
+		payload:      []byte{0x00, 0x00, 0x01},
+	}
+	_, _, err = client.GetCommEventCounter()
+	if err != ErrProtocol {
+		t.Errorf("GetCommEventCounter() should have returned ErrProtocol, got: %v", err)
+	}
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x0c,
+		payload: []byte{
+			0x08,       // byte count
+			0x00, 0x00, // status
+			0x01, 0x08, // event count
+			0x01, 0x21, // message count
+			0x20, 0x00, // events
+		},
+	}
+	status, eventCount, messageCount, events, err = client.GetCommEventLog()
+	if err != nil {
+		t.Errorf("GetCommEventLog() should have succeeded, got: %v", err)
+	}
+	if status != 0x0000 || eventCount != 0x0108 || messageCount != 0x0121 {
+		t.Errorf("unexpected status/event count/message count: 0x%04x, 0x%04x, 0x%04x",
+			status, eventCount, messageCount)
+	}
+	if len(events) != 2 || events[0] != 0x20 || events[1] != 0x00 {
+		t.Errorf("expected {0x20, 0x00} as events, got: %v", events)
+	}
+
+	// a byte count disagreeing with the payload length should be rejected
+	res.payload[0] = 0x09
+	_, _, _, _, err = client.GetCommEventLog()
+	if err != ErrProtocol {
+		t.Errorf("GetCommEventLog() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x8c,
+		payload:      []byte{0x01},
+	}
+	_, _, _, _, err = client.GetCommEventLog()
+	if !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("GetCommEventLog() should have returned ErrIllegalFunction, got: %v", err)
+	}
+}
+
+func TestClientReadFIFOQueue(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var values []uint16
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != 0x18 ||
+			len(req.payload) != 2 || req.payload[0] != 0x04 || req.payload[1] != 0xde {
+			t.Errorf("unexpected request: 0x%02x, %v", req.functionCode, req.payload)
+		}
+		return res, nil
+	})
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x18,
+		payload: []byte{
+			0x00, 0x06, // byte count
+			0x00, 0x02, // FIFO count
+			0x01, 0xb8, // FIFO value
+			0x12, 0x84, // FIFO value
+		},
+	}
+	values, err = client.ReadFIFOQueue(0x04de)
+	if err != nil {
+		t.Errorf("ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(values) != 2 || values[0] != 0x01b8 || values[1] != 0x1284 {
+		t.Errorf("expected {0x01b8, 0x1284}, got: %v", values)
+	}
+
+	// empty queues should yield no values
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x18,
+		payload:      []byte{0x00, 0x02, 0x00, 0x00},
+	}
+	values, err = client.ReadFIFOQueue(0x04de)
+	if err != nil {
+		t.Errorf("ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(values) != 0 {
+		t.Errorf("expected no values, got: %v", values)
+	}
+
+	// inconsistent byte and FIFO counts should be rejected
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x18,
+		payload:      []byte{0x00, 0x06, 0x00, 0x01, 0x01, 0xb8, 0x12, 0x84},
+	}
+	_, err = client.ReadFIFOQueue(0x04de)
+	if err != ErrProtocol {
+		t.Errorf("ReadFIFOQueue() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// so should FIFO counts above 31
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x18,
+		payload:      append([]byte{0x00, 0x42, 0x00, 0x20}, make([]byte, 64)...),
+	}
+	_, err = client.ReadFIFOQueue(0x04de)
+	if err != ErrProtocol {
+		t.Errorf("ReadFIFOQueue() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x98,
+		payload:      []byte{0x03},
+	}
+	_, err = client.ReadFIFOQueue(0x04de)
+	if !errors.Is(err, ErrIllegalDataValue) {
+		t.Errorf("ReadFIFOQueue() should have returned ErrIllegalDataValue, got: %v", err)
+	}
+}
+
+func TestClientFileRecords(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var values [][]uint16
+	var regs []uint16
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if res == nil {
+			// echo the request back
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      req.payload,
+			}, nil
+		}
+		if req.functionCode == 0x14 && len(req.payload) == 15 {
+			for i, b := range []byte{
+				0x0e,                                     // byte count
+				0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02, // file 4, record 1, 2 regs
+				0x06, 0x00, 0x03, 0x00, 0x09, 0x00, 0x01, // file 3, record 9, 1 reg
+			} {
+				if req.payload[i] != b {
+					t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+						b, i, req.payload[i])
+				}
+			}
+		}
+		return res, nil
+	})
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x14,
+		payload: []byte{
+			0x0a,                   // response data length
+			0x03, 0x06, 0x0d, 0xfe, // sub-response 1 (too short)
+			0x05, 0x06, 0x33, 0xcd, 0x00, 0x40, // sub-response 2 (too long)
+		},
+	}
+	// sub-response length fields should be checked against sub-requests
+	_, err = client.ReadFileRecords([]FileRecord{
+		{FileNumber: 4, RecordNumber: 1, RecordLength: 2},
+		{FileNumber: 3, RecordNumber: 9, RecordLength: 1},
+	})
+	if err != ErrProtocol {
+		t.Errorf("ReadFileRecords() should have returned ErrProtocol, got: %v", err)
+	}
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x14,
+		payload: []byte{
+			0x0a,                               // response data length
+			0x05, 0x06, 0x0d, 0xfe, 0x00, 0x20, // sub-response 1
+			0x03, 0x06, 0x33, 0xcd, // sub-response 2
+		},
+	}
+	values, err = client.ReadFileRecords([]FileRecord{
+		{FileNumber: 4, RecordNumber: 1, RecordLength: 2},
+		{FileNumber: 3, RecordNumber: 9, RecordLength: 1},
+	})
+	if err != nil {
+		t.Fatalf("ReadFileRecords() should have succeeded, got: %v", err)
+	}
+	if len(values) != 2 ||
+		len(values[0]) != 2 || values[0][0] != 0x0dfe || values[0][1] != 0x0020 ||
+		len(values[1]) != 1 || values[1][0] != 0x33cd {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	// bad reference types should be rejected
+	res.payload[8] = 0x07
+	_, err = client.ReadFileRecords([]FileRecord{
+		{FileNumber: 4, RecordNumber: 1, RecordLength: 2},
+		{FileNumber: 3, RecordNumber: 9, RecordLength: 1},
+	})
+	if err != ErrProtocol {
+		t.Errorf("ReadFileRecords() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// batches which do not fit in a single PDU should be rejected
+	_, err = client.ReadFileRecord(4, 0, 122)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	err = client.WriteFileRecord(4, 0, make([]uint16, 123))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("WriteFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// so should invalid file and record numbers
+	_, err = client.ReadFileRecord(0, 0, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadFileRecord(1, 0x270f, 2)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// writes should check the echo
+	res = nil
+	err = client.WriteFileRecords([]FileRecord{
+		{FileNumber: 4, RecordNumber: 7, Values: []uint16{0x06af, 0x04be}},
+		{FileNumber: 5, RecordNumber: 0, Values: []uint16{0x1234}},
+	})
+	if err != nil {
+		t.Errorf("WriteFileRecords() should have succeeded, got: %v", err)
+	}
+
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x15,
+		payload: []byte{
+			0x09, 0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x01, 0x06, 0xae,
+		},
+	}
+	err = client.WriteFileRecord(4, 7, []uint16{0x06af})
+	if err != ErrProtocol {
+		t.Errorf("WriteFileRecord() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// exceptions should map to typed errors
+	res = &pdu{
+		unitId:       1,
+		functionCode: 0x94,
+		payload:      []byte{0x02},
+	}
+	regs, err = client.ReadFileRecord(4, 1, 2)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadFileRecord() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	if regs != nil {
+		t.Errorf("expected no values, got: %v", regs)
+	}
+}
+
+func TestClientChunkedFloat32s(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var regs [0x10000]uint16
+	var chunks [][2]uint16
+	var failAddr int = -1
+	var chunkErr *ChunkError
+	var values []float32 = make([]float32, 150)
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		chunks = append(chunks, [2]uint16{addr, qty})
+
+		if int(addr) == failAddr {
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode | 0x80,
+				payload:      []byte{0x04},
+			}, nil
+		}
+
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+		switch req.functionCode {
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			copy(regs[addr:addr+qty], bytesToUint16s(BIG_ENDIAN, req.payload[5:]))
+			res.payload = req.payload[0:4]
+		case FC_READ_INPUT_REGISTERS:
+			res.payload = append([]byte{uint8(2 * qty)},
+				uint16sToBytes(BIG_ENDIAN, regs[addr:addr+qty])...)
+		default:
+			t.Errorf("unexpected function code 0x%02x", req.functionCode)
+		}
+		return res, nil
+	})
+	client.SetEncoding(BIG_ENDIAN, LOW_WORD_FIRST)
+
+	for i := range values {
+		values[i] = float32(i) * -1.5
+	}
+
+	// 150 values should take 3 requests, without splitting any of them
+	err = client.WriteFloat32s(0x1000, values)
+	if err != nil {
+		t.Fatalf("WriteFloat32s() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 3 ||
+		chunks[0] != [2]uint16{0x1000, 122} || chunks[1] != [2]uint16{0x107a, 122} ||
+		chunks[2] != [2]uint16{0x10f4, 56} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// values should honour the word order
+	if [2]uint16(regs[0x1002:0x1004]) != [2]uint16{0x0000, 0xbfc0} {
+		t.Errorf("unexpected registers: %04x", regs[0x1002:0x1004])
+	}
+
+	chunks = nil
+	readValues, err := client.ReadFloat32s(0x1000, 150, INPUT_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadFloat32s() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 3 ||
+		chunks[0] != [2]uint16{0x1000, 124} || chunks[1] != [2]uint16{0x107c, 124} ||
+		chunks[2] != [2]uint16{0x10f8, 52} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+	if !slices.Equal(readValues, values) {
+		t.Errorf("unexpected values: %v", readValues)
+	}
+
+	// failures should stop the operation and report how far it went
+	chunks = nil
+	failAddr = 0x107a
+	err = client.WriteFloat32s(0x1000, values)
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("WriteFloat32s() should have returned a ChunkError, got: %v", err)
+	}
+	if chunkErr.Addr != 0x107a || chunkErr.Done != 61 || len(chunks) != 2 {
+		t.Errorf("unexpected chunk error: %v (%v chunks)", chunkErr, len(chunks))
+	}
+
+	failAddr = 0x107c
+	_, err = client.ReadFloat32s(0x1000, 150, INPUT_REGISTER)
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("ReadFloat32s() should have returned a ChunkError, got: %v", err)
+	}
+	if chunkErr.Addr != 0x107c || chunkErr.Done != 62 ||
+		!errors.Is(err, ErrServerDeviceFailure) {
+		t.Errorf("unexpected chunk error: %v", chunkErr)
+	}
+
+	// operations past the end of the address space should be rejected
+	err = client.WriteFloat32s(0xff80, values)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("WriteFloat32s() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadFloat32s(0xff80, 150, INPUT_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadFloat32s() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientVerifyWrites(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var regs [0x10000]uint16
+	var fcs []uint8
+	var reads [][2]uint16
+	var unitIds []uint8
+	var applyWrites bool = true
+	var chunkErr *ChunkError
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		fcs = append(fcs, req.functionCode)
+		unitIds = append(unitIds, req.unitId)
+
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      req.payload[0:4],
+		}
+		switch req.functionCode {
+		case FC_WRITE_SINGLE_REGISTER:
+			if applyWrites {
+				regs[addr] = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+			}
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			if applyWrites {
+				qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+				copy(regs[addr:addr+qty], bytesToUint16s(BIG_ENDIAN, req.payload[5:]))
+			}
+		case FC_READ_HOLDING_REGISTERS:
+			qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+			reads = append(reads, [2]uint16{addr, qty})
+			res.payload = append([]byte{uint8(2 * qty)},
+				uint16sToBytes(BIG_ENDIAN, regs[addr:addr+qty])...)
+		default:
+			t.Errorf("unexpected function code 0x%02x", req.functionCode)
+		}
+		return res, nil
+	})
+
+	// writes should not be read back by default
+	err = client.WriteRegister(0x10, 0x1234)
+	if err != nil || len(fcs) != 1 {
+		t.Errorf("WriteRegister() should have succeeded without read-back, "+
+			"got: %v (%v requests)", err, len(fcs))
+	}
+
+	// once enabled (here through a handle), writes should be read back
+	client.WithUnitId(1).SetVerifyWrites(true)
+	fcs = nil
+	err = client.WriteRegister(0x10, 0x5678)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+	err = client.WriteRegisters(0x20, []uint16{0x0001, 0x0002, 0x0003})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(fcs, []uint8{0x06, 0x03, 0x10, 0x03}) {
+		t.Errorf("unexpected requests: %v", fcs)
+	}
+
+	// writes acknowledged but not applied should be caught
+	applyWrites = false
+	err = client.WriteRegister(0x10, 0x9abc)
+	if err != ErrVerifyFailed {
+		t.Errorf("expected %v, got: %v", ErrVerifyFailed, err)
+	}
+
+	err = client.WriteFloat32(0x30, 1.5)
+	if err != ErrVerifyFailed {
+		t.Errorf("expected %v, got: %v", ErrVerifyFailed, err)
+	}
+
+	err = client.WriteRegisters(0x40, make([]uint16, 130))
+	if err != nil {
+		t.Errorf("zeroes should match the untouched registers, got: %v", err)
+	}
+	err = client.WriteRegisters(0x40, slices.Repeat([]uint16{0xffff}, 130))
+	if !errors.As(err, &chunkErr) || chunkErr.Done != 0 ||
+		!errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("expected a ChunkError wrapping %v, got: %v", ErrVerifyFailed, err)
+	}
+
+	// read-backs should be split by the read limit and addressed to the
+	// unit id written to
+	applyWrites = true
+	client.conf.DeviceProfile = DeviceProfile{MaxReadRegisters: 50}
+	reads, unitIds = nil, nil
+	err = client.WithUnitId(7).WriteRegisters(0x40, make([]uint16, 120))
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(reads, [][2]uint16{{0x40, 50}, {0x72, 50}, {0xa4, 20}}) {
+		t.Errorf("unexpected read-backs: %v", reads)
+	}
+	if !slices.Equal(unitIds, []uint8{7, 7, 7, 7}) {
+		t.Errorf("unexpected unit ids: %v", unitIds)
+	}
+	client.conf.DeviceProfile = DeviceProfile{}
+
+	// broadcast writes cannot be read back
+	fcs = nil
+	client.SetUnitId(0)
+	client.WriteRegister(0x10, 0x9abc)
+	if !slices.Equal(fcs, []uint8{0x06}) {
+		t.Errorf("unexpected requests: %v", fcs)
+	}
+	client.SetUnitId(1)
+
+	// disabling verification should stop read-backs
+	client.SetVerifyWrites(false)
+	fcs = nil
+	err = client.WriteRegister(0x10, 0x9abc)
+	if err != nil || len(fcs) != 1 {
+		t.Errorf("WriteRegister() should have succeeded without read-back, "+
+			"got: %v (%v requests)", err, len(fcs))
+	}
+}
+
+func TestClientDeviceProfile(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var chunks [][2]uint16
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		chunks = append(chunks, [2]uint16{addr, qty})
+
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+		switch req.functionCode {
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			res.payload = req.payload[0:4]
+		case FC_READ_HOLDING_REGISTERS:
+			res.payload = append([]byte{uint8(2 * qty)}, make([]byte, 2*qty)...)
+		case FC_READ_COILS:
+			res.payload = append([]byte{uint8((qty + 7) / 8)}, make([]byte, (qty+7)/8)...)
+		}
+		return res, nil
+	})
+	client.conf.DeviceProfile = DeviceProfile{
+		MaxReadRegisters:  32,
+		MaxWriteRegisters: 16,
+		MaxReadCoils:      100,
+	}
+
+	// bulk reads and writes should be split by the profile limits
+	_, err = client.ReadRegistersBlock(0, 70)
+	if err != nil {
+		t.Fatalf("ReadRegistersBlock() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(chunks, [][2]uint16{{0, 32}, {32, 32}, {64, 6}}) {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	chunks = nil
+	err = client.WriteRegisters(0, make([]uint16, 20))
+	if err != nil {
+		t.Fatalf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(chunks, [][2]uint16{{0, 16}, {16, 4}}) {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	chunks = nil
+	_, err = client.ReadFloat32s(0, 20, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadFloat32s() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(chunks, [][2]uint16{{0, 32}, {32, 8}}) {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// single requests above the limits should be rejected without being sent
+	chunks = nil
+	_, err = client.ReadRegisters(0, 33, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+	}
+
+	_, err = client.ReadCoils(0, 101)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+	}
+
+	_, err = client.ReadCoils(0, 100)
+	if err != nil {
+		t.Errorf("ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 1 {
+		t.Errorf("unexpected requests: %v", chunks)
+	}
+
+	// limits above the spec maximums should be ignored
+	chunks = nil
+	client.conf.DeviceProfile = DeviceProfile{MaxReadRegisters: 500}
+	_, err = client.ReadRegistersBlock(0, 200)
+	if err != nil {
+		t.Fatalf("ReadRegistersBlock() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(chunks, [][2]uint16{{0, 125}, {125, 75}}) {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// unusable limits should be rejected
+	for _, profile := range []DeviceProfile{
+		{MaxReadRegisters: 1},
+		{MaxWriteRegisters: 1},
+		{MaxReadRegisters: -1},
+		{MaxReadCoils: -1},
+	} {
+		_, err = NewClient(&ClientConfiguration{
+			URL:           "tcp://device:502",
+			DeviceProfile: profile,
+		})
+		if err == nil {
+			t.Errorf("NewClient() should have failed with profile %+v", profile)
+		}
+	}
+
+	_, err = NewClient(&ClientConfiguration{
+		URL:           "tcp://device:502",
+		DeviceProfile: DeviceProfile{MaxReadRegisters: 2, MaxWriteRegisters: 2},
+	})
+	if err != nil {
+		t.Errorf("NewClient() should have succeeded, got: %v", err)
+	}
+}
+
+func TestClientReadRegistersBlock(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+	var chunks [][2]uint16
+	var failAddr int = -1
+	var chunkErr *ChunkError
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		var addr, qty uint16
+
+		if req.functionCode != 0x03 {
+			t.Errorf("expected function code 0x03, got 0x%02x", req.functionCode)
+		}
+		if req.unitId != 1 {
+			t.Errorf("expected unit id 1, got %v", req.unitId)
+		}
+
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		qty = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		chunks = append(chunks, [2]uint16{addr, qty})
+
+		if int(addr) == failAddr {
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: 0x83,
+				payload:      []byte{0x02},
+			}, nil
+		}
+
+		// each register holds its own address
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: 0x03,
+			payload:      []byte{uint8(2 * qty)},
+		}
+		for i := uint16(0); i < qty; i++ {
+			res.payload = append(res.payload, uint16ToBytes(BIG_ENDIAN, addr+i)...)
+		}
+		return res, nil
+	})
+
+	// 400 registers should take 4 requests
+	regs, err = client.ReadRegistersBlock(0x1000, 400)
+	if err != nil {
+		t.Fatalf("ReadRegistersBlock() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 400 {
+		t.Fatalf("expected 400 registers, got: %v", len(regs))
+	}
+	for i, reg := range regs {
+		if reg != 0x1000+uint16(i) {
+			t.Errorf("expected 0x%04x at index %v, got: 0x%04x", 0x1000+i, i, reg)
+		}
+	}
+	if len(chunks) != 4 ||
+		chunks[0] != [2]uint16{0x1000, 125} || chunks[1] != [2]uint16{0x107d, 125} ||
+		chunks[2] != [2]uint16{0x10fa, 125} || chunks[3] != [2]uint16{0x1177, 25} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// an exception should stop the read and report the failed sub-range
+	chunks = nil
+	failAddr = 0x107d
+	regs, err = client.ReadRegistersBlock(0x1000, 400)
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("ReadRegistersBlock() should have returned a ChunkError, got: %v", err)
+	}
+	if chunkErr.Addr != 0x107d || chunkErr.Done != 125 {
+		t.Errorf("unexpected chunk error: %v", chunkErr)
+	}
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadRegistersBlock() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	if regs != nil || len(chunks) != 2 {
+		t.Errorf("no further requests should have been sent, got: %v", chunks)
+	}
+
+	// reads past the end of the address space should be rejected
+	_, err = client.ReadRegistersBlock(0xff00, 0x200)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadRegistersBlock() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientReadRegistersScattered(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var values map[uint16]uint16
+	var chunks [][2]uint16
+	var chunkErr *ChunkError
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		var addr, qty uint16
+
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		qty = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		chunks = append(chunks, [2]uint16{addr, qty})
+
+		if addr == 0x9000 {
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: 0x83,
+				payload:      []byte{0x02},
+			}, nil
+		}
+
+		// each register holds its own address + 1
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: 0x03,
+			payload:      []byte{uint8(2 * qty)},
+		}
+		for i := uint16(0); i < qty; i++ {
+			res.payload = append(res.payload, uint16ToBytes(BIG_ENDIAN, addr+i+1)...)
+		}
+		return res, nil
+	})
+
+	// 10, 11 and 40..42 should be read in 2 requests with a max gap of 5,
+	// duplicates and ordering notwithstanding
+	values, err = client.ReadRegistersScattered([]uint16{42, 10, 40, 11, 41, 40}, 5)
+	if err != nil {
+		t.Fatalf("ReadRegistersScattered() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0] != [2]uint16{10, 2} || chunks[1] != [2]uint16{40, 3} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+	if len(values) != 5 {
+		t.Errorf("expected 5 values, got: %v", values)
+	}
+	for _, addr := range []uint16{10, 11, 40, 41, 42} {
+		if values[addr] != addr+1 {
+			t.Errorf("expected %v at address %v, got: %v", addr+1, addr, values[addr])
+		}
+	}
+
+	// a large enough gap should merge everything into a single request
+	chunks = nil
+	values, err = client.ReadRegistersScattered([]uint16{10, 11, 40, 41, 42}, 28)
+	if err != nil {
+		t.Fatalf("ReadRegistersScattered() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 1 || chunks[0] != [2]uint16{10, 33} || len(values) != 5 {
+		t.Errorf("unexpected chunks: %v (values: %v)", chunks, values)
+	}
+
+	// windows should never exceed 125 registers
+	chunks = nil
+	_, err = client.ReadRegistersScattered([]uint16{0, 124, 125, 0xffff}, 0xffff)
+	if err != nil {
+		t.Fatalf("ReadRegistersScattered() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 3 || chunks[0] != [2]uint16{0, 125} ||
+		chunks[1] != [2]uint16{125, 1} || chunks[2] != [2]uint16{0xffff, 1} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// exceptions should stop the read and report the failed window
+	chunks = nil
+	_, err = client.ReadRegistersScattered([]uint16{0x8000, 0x9000, 0xa000}, 0)
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("ReadRegistersScattered() should have returned a ChunkError, got: %v", err)
+	}
+	if chunkErr.Addr != 0x9000 || chunkErr.Done != 1 || len(chunks) != 2 {
+		t.Errorf("unexpected chunk error: %v (chunks: %v)", chunkErr, chunks)
+	}
+
+	_, err = client.ReadRegistersScattered(nil, 0)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadRegistersScattered() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientSetEndianness(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+	var u32 uint32
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{0x04, 0x11, 0x22, 0x33, 0x44},
+		}, nil
+	})
+
+	err = client.SetEndianness(LITTLE_ENDIAN)
+	if err != nil {
+		t.Fatalf("SetEndianness() should have succeeded, got: %v", err)
+	}
+
+	regs, err = client.ReadRegisters(0, 2, HOLDING_REGISTER)
+	if err != nil || regs[0] != 0x2211 || regs[1] != 0x4433 {
+		t.Errorf("unexpected registers: %04x (err: %v)", regs, err)
+	}
+
+	// the word order should be left untouched
+	u32, err = client.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil || u32 != 0x22114433 {
+		t.Errorf("unexpected value: 0x%08x (err: %v)", u32, err)
+	}
+
+	// handles should be able to override the client default
+	handle := client.WithUnitId(1)
+	handle.SetEndianness(BIG_ENDIAN)
+	u32, err = handle.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil || u32 != 0x11223344 {
+		t.Errorf("unexpected value: 0x%08x (err: %v)", u32, err)
+	}
+
+	err = client.SetEndianness(Endianness(3))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("SetEndianness() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientSignedIntegers(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var i16 int16
+	var i32 int32
+	var i64 int64
+	var written []byte
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		switch req.functionCode {
+		case FC_READ_HOLDING_REGISTERS:
+			qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+			res := &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      []byte{uint8(qty * 2)},
+			}
+			for range qty {
+				res.payload = append(res.payload, 0xff, 0xfe)
+			}
+			return res, nil
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			written = req.payload[5:]
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      req.payload[0:4],
+			}, nil
+		}
+		return nil, ErrProtocol
+	})
+
+	i16, err = client.ReadInt16(0, HOLDING_REGISTER)
+	if err != nil || i16 != -2 {
+		t.Errorf("unexpected value: %v (err: %v)", i16, err)
+	}
+
+	i32, err = client.ReadInt32(0, HOLDING_REGISTER)
+	if err != nil || i32 != -65538 {
+		t.Errorf("unexpected value: %v (err: %v)", i32, err)
+	}
+
+	i64, err = client.ReadInt64(0, HOLDING_REGISTER)
+	if err != nil || i64 != -281479271743490 {
+		t.Errorf("unexpected value: %v (err: %v)", i64, err)
+	}
+
+	err = client.WriteInt32(0, -2)
+	if err != nil || !bytes.Equal(written, []byte{0xff, 0xff, 0xff, 0xfe}) {
+		t.Errorf("unexpected payload: % x (err: %v)", written, err)
+	}
+
+	client.SetEncoding(BIG_ENDIAN, LOW_WORD_FIRST)
+	err = client.WriteInt64s(0, []int64{-2})
+	if err != nil || !bytes.Equal(written, []byte{
+		0xff, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected payload: % x (err: %v)", written, err)
+	}
+}
+
+func TestClientStrings(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var s string
+	var written []byte
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		switch req.functionCode {
+		case FC_READ_HOLDING_REGISTERS:
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload: []byte{
+					0x08,
+					'P', 'u', 'm', 'p', '1', ' ', 0x00, 0x00,
+				},
+			}, nil
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			written = req.payload[5:]
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      req.payload[0:4],
+			}, nil
+		}
+		return nil, ErrProtocol
+	})
+
+	s, err = client.ReadString(0, 4, HOLDING_REGISTER)
+	if err != nil || s != "Pump1" {
+		t.Errorf("unexpected value: %q (err: %v)", s, err)
+	}
+
+	err = client.WriteString(0, "abc")
+	if err != nil || !bytes.Equal(written, []byte{'a', 'b', 'c', 0x00}) {
+		t.Errorf("unexpected payload: % x (err: %v)", written, err)
+	}
+
+	// vendors packing the first character in the low byte
+	client.SetEndianness(LITTLE_ENDIAN)
+	s, err = client.ReadString(0, 4, HOLDING_REGISTER)
+	if err != nil || s != "uPpm 1" {
+		t.Errorf("unexpected value: %q (err: %v)", s, err)
+	}
+
+	err = client.WriteString(0, "abc")
+	if err != nil || !bytes.Equal(written, []byte{'b', 'a', 0x00, 'c'}) {
+		t.Errorf("unexpected payload: % x (err: %v)", written, err)
+	}
+
+	// register counts which cannot be read in a single request
+	for _, count := range []uint16{0, 126, 0x8001} {
+		_, err = client.ReadString(0, count, HOLDING_REGISTER)
+		if err != ErrUnexpectedParameters {
+			t.Errorf("expected %v for %v registers, got: %v", ErrUnexpectedParameters, count, err)
+		}
+	}
+}
+
+func TestClientSetTimeout(t *testing.T) {
+	client, err := NewClient(&ClientConfiguration{URL: "tcp://device:502"})
+	if err != nil {
+		t.Fatalf("NewClient() should have succeeded, got: %v", err)
+	}
+
+	client.SetTimeout(2 * time.Second)
+	if client.conf.Timeout != 2*time.Second {
+		t.Errorf("expected a timeout of 2s, got: %v", client.conf.Timeout)
+	}
+
+	// invalid timeouts should be ignored
+	client.SetTimeout(0)
+	client.WithUnitId(0x02).SetTimeout(-time.Second)
+	if client.conf.Timeout != 2*time.Second {
+		t.Errorf("expected a timeout of 2s, got: %v", client.conf.Timeout)
+	}
+}
+
+func TestClientDefaultUnitId(t *testing.T) {
+	for _, tc := range []struct {
+		conf   ClientConfiguration
+		unitId uint8
+	}{
+		{ClientConfiguration{URL: "tcp://device:502"}, 0xff},
+		{ClientConfiguration{URL: "udp://device:502"}, 0xff},
+		{ClientConfiguration{URL: "rtuovertcp://device:502"}, 0x01},
+		{ClientConfiguration{URL: "rtu:///dev/ttyUSB0"}, 0x01},
+		{ClientConfiguration{URL: "tcp://device:502", DefaultUnitId: 0x05}, 0x05},
+		{ClientConfiguration{URL: "rtu:///dev/ttyUSB0", DefaultUnitId: 0x05}, 0x05},
+	} {
+		client, err := NewClient(&tc.conf)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		if client.unitId != tc.unitId {
+			t.Errorf("%s: expected unit id 0x%02x, got: 0x%02x",
+				tc.conf.URL, tc.unitId, client.unitId)
+		}
+
+		// SetUnitId() should override the default
+		client.SetUnitId(0)
+		if client.unitId != 0 {
+			t.Errorf("%s: expected unit id 0x00, got: 0x%02x",
+				tc.conf.URL, client.unitId)
+		}
+	}
+}
+
+func TestClientScanBus(t *testing.T) {
+	var client *ModbusClient
+	var found []uint8
+	var probed []uint8
+	var cancel context.CancelFunc
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		probed = append(probed, req.unitId)
+
+		switch req.unitId {
+		case 3:
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      []byte{0x02, 0x00, 0x00},
+			}, nil
+		case 5:
+			// devices without a register at address 0 are still present
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode | 0x80,
+				payload:      []byte{0x02},
+			}, nil
+		case 6:
+			return nil, ErrBadCRC
+		case 8:
+			if cancel != nil {
+				cancel()
+			}
+		}
+		return nil, ErrRequestTimedOut
+	})
+
+	// unit id 0 (broadcast) should be skipped
+	found, err = client.ScanBus(context.Background(), 0, 10, nil)
+	if err != nil {
+		t.Fatalf("ScanBus() should have succeeded, got: %v", err)
+	}
+	if !slices.Equal(found, []uint8{3, 5}) {
+		t.Errorf("expected unit ids [3 5], got: %v", found)
+	}
+	if !slices.Equal(probed, []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+		t.Errorf("unexpected probed unit ids: %v", probed)
+	}
+
+	// custom probes should be used instead of the default one
+	probed = nil
+	found, err = client.ScanBus(context.Background(), 3, 3,
+		func(unitId uint8) (uint8, []byte) {
+			return FC_READ_INPUT_REGISTERS, []byte{0x00, 0x10, 0x00, 0x01}
+		})
+	if err != nil || !slices.Equal(found, []uint8{3}) {
+		t.Errorf("unexpected result: %v (err: %v)", found, err)
+	}
+
+	// cancelling the context should stop the scan
+	probed = nil
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	cancel = cancelCtx
+
+	found, err = client.ScanBus(ctx, 1, 255, nil)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if !slices.Equal(found, []uint8{3, 5}) || len(probed) != 8 {
+		t.Errorf("unexpected result: %v (probed: %v)", found, probed)
+	}
+
+	_, err = client.ScanBus(context.Background(), 10, 1, nil)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+}
+
+func TestClientPingAndLastError(t *testing.T) {
+	var client *ModbusClient
+	var res *pdu
+	var resErr error
+	var err error
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		if req.functionCode != FC_DIAGNOSTICS || req.unitId != 1 ||
+			!bytes.Equal(req.payload, []byte{0x00, 0x00, 0x00, 0x00}) {
+			t.Errorf("unexpected probe: %+v", req)
+		}
+		return res, resErr
+	})
+
+	if client.LastError() != nil {
+		t.Errorf("LastError() should be nil, got: %v", client.LastError())
+	}
+
+	// devices echoing the probe should be reachable
+	res = &pdu{unitId: 1, functionCode: FC_DIAGNOSTICS, payload: []byte{0x00, 0x00, 0x00, 0x00}}
+	err = client.Ping()
+	if err != nil {
+		t.Errorf("Ping() should have succeeded, got: %v", err)
+	}
+
+	// as should devices replying with an exception
+	res = &pdu{unitId: 1, functionCode: FC_DIAGNOSTICS | 0x80, payload: []byte{0x01}}
+	err = client.Ping()
+	if err != nil {
+		t.Errorf("Ping() should have succeeded, got: %v", err)
+	}
+	if client.LastError() != nil {
+		t.Errorf("LastError() should be nil, got: %v", client.LastError())
+	}
+
+	// transport failures should be reported and recorded
+	res, resErr = nil, ErrRequestTimedOut
+	err = client.Ping()
+	if err != ErrRequestTimedOut {
+		t.Errorf("Ping() should have returned ErrRequestTimedOut, got: %v", err)
+	}
+	if client.LastError() != ErrRequestTimedOut {
+		t.Errorf("LastError() should be ErrRequestTimedOut, got: %v", client.LastError())
+	}
+
+	// and kept until superseded, including by failures of handles
+	res = &pdu{unitId: 1, functionCode: FC_DIAGNOSTICS, payload: []byte{0x00, 0x00, 0x00, 0x00}}
+	resErr = nil
+	client.Ping()
+	if client.LastError() != ErrRequestTimedOut {
+		t.Errorf("LastError() should be ErrRequestTimedOut, got: %v", client.LastError())
+	}
+
+	res, resErr = nil, ErrBadCRC
+	client.WithUnitId(1).Ping()
+	if client.LastError() != ErrBadCRC || client.WithUnitId(2).LastError() != ErrBadCRC {
+		t.Errorf("LastError() should be ErrBadCRC, got: %v", client.LastError())
+	}
+
+	// context cancellations are not transport failures
+	res, resErr = nil, context.Canceled
+	err = client.Ping()
+	if err != context.Canceled || client.LastError() != ErrBadCRC {
+		t.Errorf("LastError() should be ErrBadCRC, got: %v", client.LastError())
+	}
+}
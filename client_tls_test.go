@@ -1,10 +1,16 @@
 package modbus
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"testing"
 	"time"
@@ -575,3 +581,193 @@ func runMockTLSServer(t *testing.T, serverKeyPair tls.Certificate,
 		}
 	}
 }
+
+func TestTLSClientMutualAuth(t *testing.T) {
+	var err error
+	var server *ModbusServer
+	var client *ModbusClient
+	var th *tlsRoleTestHandler
+	var serverKeyPair, clientKeyPair tls.Certificate
+	var serverCp, clientCp *x509.CertPool
+	var sock *tls.Conn
+	var peerCerts []*x509.Certificate
+	var regs []uint16
+
+	// generate self-signed key pairs for both ends, trusting each other
+	serverKeyPair = generateTestKeyPair(t, "localhost", "server-role")
+	clientKeyPair = generateTestKeyPair(t, "test client", "operator")
+
+	serverCp = x509.NewCertPool()
+	serverCp.AddCert(clientKeyPair.Leaf)
+	clientCp = x509.NewCertPool()
+	clientCp.AddCert(serverKeyPair.Leaf)
+
+	th = &tlsRoleTestHandler{}
+	server, err = NewServer(&ServerConfiguration{
+		URL:           "tcp+tls://localhost:5803",
+		MaxClients:    2,
+		TLSServerCert: &serverKeyPair,
+		TLSClientCAs:  serverCp,
+	}, th)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	// DialTLS() should yield a connection usable as is
+	sock, err = DialTLS("localhost:5803", &tls.Config{
+		Certificates: []tls.Certificate{clientKeyPair},
+		RootCAs:      clientCp,
+		// should be raised to TLS 1.2
+		MinVersion: tls.VersionTLS10,
+	}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("DialTLS() should have succeeded, got: %v", err)
+	}
+	if sock.ConnectionState().Version < tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 or higher, got: 0x%04x", sock.ConnectionState().Version)
+	}
+	sock.Close()
+
+	// connecting without a client certificate should fail
+	sock, err = DialTLS("localhost:5803", &tls.Config{
+		RootCAs: clientCp,
+	}, 2*time.Second)
+	if err == nil {
+		// TLS 1.3 servers may only report the failure on the first read
+		sock.SetDeadline(time.Now().Add(time.Second))
+		_, err = sock.Read(make([]byte, 1))
+		sock.Close()
+	}
+	if err == nil {
+		t.Error("connecting without a client certificate should have failed")
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:           "tcp+tls://localhost:5803",
+		TLSClientCert: &clientKeyPair,
+		TLSRootCAs:    clientCp,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// no certificate should be returned before the client is connected
+	if client.PeerCertificates() != nil {
+		t.Error("PeerCertificates() should have returned nil")
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// the role of the client should make it to the handler
+	regs, err = client.ReadHoldingRegisters(0x0000, 1)
+	if err != nil {
+		t.Errorf("client.ReadHoldingRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0x1234 {
+		t.Errorf("expected {0x1234}, got: %v", regs)
+	}
+	if th.lastRole != "operator" {
+		t.Errorf("expected 'operator' as client role, got: '%s'", th.lastRole)
+	}
+
+	// the server certificate (and its role) should be available to the client
+	peerCerts = client.PeerCertificates()
+	if len(peerCerts) != 1 {
+		t.Fatalf("expected 1 peer certificate, got: %v", len(peerCerts))
+	}
+	if !peerCerts[0].Equal(serverKeyPair.Leaf) {
+		t.Error("peer certificate should have been the server certificate")
+	}
+	if server.extractRole(peerCerts[0]) != "server-role" {
+		t.Errorf("expected 'server-role' as server role, got: '%s'",
+			server.extractRole(peerCerts[0]))
+	}
+
+	// unit id handles should return the same
+	if len(client.WithUnitId(2).PeerCertificates()) != 1 {
+		t.Error("expected 1 peer certificate from the unit id handle")
+	}
+}
+
+// Generates a self-signed ECDSA key pair for localhost, with the given common
+// name and modbus role.
+func generateTestKeyPair(t *testing.T, commonName string, role string) tls.Certificate {
+	var err error
+	var key *ecdsa.PrivateKey
+	var der []byte
+	var roleExt []byte
+	var template *x509.Certificate
+	var keyPair tls.Certificate
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	roleExt, err = asn1.MarshalWithParams(role, "utf8")
+	if err != nil {
+		t.Fatalf("failed to encode role: %v", err)
+	}
+
+	template = &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		ExtraExtensions: []pkix.Extension{
+			{Id: modbusRoleOID, Value: roleExt},
+		},
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	keyPair.Certificate = [][]byte{der}
+	keyPair.PrivateKey = key
+	keyPair.Leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return keyPair
+}
+
+// Handler serving holding register reads and recording the client role.
+type tlsRoleTestHandler struct {
+	lastRole string
+}
+
+func (th *tlsRoleTestHandler) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	return nil, ErrIllegalFunction
+}
+
+func (th *tlsRoleTestHandler) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	return nil, ErrIllegalFunction
+}
+
+func (th *tlsRoleTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	th.lastRole = req.ClientRole
+	return []uint16{0x1234}, nil
+}
+
+func (th *tlsRoleTestHandler) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	return nil, ErrIllegalFunction
+}
@@ -951,7 +951,7 @@ func performBoolScan(client *modbus.ModbusClient, isCoil bool) {
 		} else {
 			val, err = client.ReadDiscreteInput(uint16(addr))
 		}
-		if err == modbus.ErrIllegalDataAddress || err == modbus.ErrIllegalFunction {
+		if errors.Is(err, modbus.ErrIllegalDataAddress) || errors.Is(err, modbus.ErrIllegalFunction) {
 			// the register does not exist
 			continue
 		} else if err != nil {
@@ -990,7 +990,7 @@ func performRegisterScan(client *modbus.ModbusClient, isHoldingReg bool) {
 		} else {
 			val, err = client.ReadRegister(uint16(addr), modbus.INPUT_REGISTER)
 		}
-		if err == modbus.ErrIllegalDataAddress || err == modbus.ErrIllegalFunction {
+		if errors.Is(err, modbus.ErrIllegalDataAddress) || errors.Is(err, modbus.ErrIllegalFunction) {
 			// the register does not exist
 			continue
 		} else if err != nil {
@@ -1030,15 +1030,16 @@ func performUnitIdScan(client *modbus.ModbusClient) {
 			fmt.Printf("0x%02x (%3v): ok\n", unitId, unitId)
 			countOk++
 
-		case modbus.ErrRequestTimedOut:
-			countTimeout++
-
 		case modbus.ErrGWTargetFailedToRespond:
 			countGWTimeout++
 
 		default:
-			fmt.Printf("0x%02x (%3v): %v\n", unitId, unitId, err)
-			countErr++
+			if errors.Is(err, modbus.ErrRequestTimedOut) {
+				countTimeout++
+			} else {
+				fmt.Printf("0x%02x (%3v): %v\n", unitId, unitId, err)
+				countErr++
+			}
 		}
 	}
 
@@ -1079,16 +1080,16 @@ func performPing(client *modbus.ModbusClient, count uint16, interval time.Durati
 			maxRTT = rtt
 		}
 
-		switch err {
+		switch {
 		// mask illegal data address and illegal function errors since we
 		// only care about getting a response from the target device
 		// (on which holding reg #0 may or may not exist)
-		case nil, modbus.ErrIllegalDataAddress, modbus.ErrIllegalFunction:
+		case err == nil, err == modbus.ErrIllegalDataAddress, err == modbus.ErrIllegalFunction:
 			okCount++
 			fmt.Printf("ok: seq = %v, time: %v\n",
 				run+1, rtt.Round(time.Microsecond))
 
-		case modbus.ErrRequestTimedOut, modbus.ErrGWTargetFailedToRespond:
+		case errors.Is(err, modbus.ErrRequestTimedOut), err == modbus.ErrGWTargetFailedToRespond:
 			timeoutCount++
 			fmt.Printf("timeout (%v): seq = %v, time: %v\n",
 				err, run+1, rtt.Round(time.Microsecond))
@@ -1273,6 +1274,8 @@ Supported transports and associated target schemes:
   - Modbus TCP (MBAP):                                    tcp://host:port
   - Modbus TCP over TLS (MBAPS or Modbus Security):       tcp+tls://host:port
   - Modbus TCP over UDP (MBAP over UDP):                  udp://host:port
+  - Modbus ASCII using a local serial device:             ascii:///path/to/device
+  - Modbus ASCII over TCP (ASCII framing over TCP):       asciiovertcp://host:port
 Note that UDP transports are not part of the Modbus protocol specification.
 
 Examples:
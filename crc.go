@@ -64,3 +64,32 @@ func (c *crc) isEqual(low byte, high byte) (yes bool) {
 	yes = (bytesToUint16(LITTLE_ENDIAN, []byte{low, high}) == c.crc)
 	return
 }
+
+// Returns the modbus CRC-16 (polynomial 0xa001, reflected, initial value
+// 0xffff) of data.
+// The CRC is sent low byte first on the wire (see AppendCRC16()).
+func CRC16(data []byte) uint16 {
+	var c crc
+
+	c.init()
+	c.add(data)
+
+	return c.crc
+}
+
+// Appends the CRC-16 of frame to frame, low byte first, as expected at the
+// end of RTU frames.
+func AppendCRC16(frame []byte) []byte {
+	return append(frame, uint16ToBytes(LITTLE_ENDIAN, CRC16(frame))...)
+}
+
+// Returns true if the last two bytes of frame hold the CRC-16 of the
+// preceding bytes, low byte first (e.g. a complete RTU frame).
+func CheckCRC16(frame []byte) bool {
+	if len(frame) < 2 {
+		return false
+	}
+
+	return CRC16(frame[0:len(frame)-2]) ==
+		bytesToUint16(LITTLE_ENDIAN, frame[len(frame)-2:])
+}
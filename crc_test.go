@@ -100,3 +100,35 @@ func TestCRCIsEqual(t *testing.T) {
 		t.Error("isEqual() should have returned true")
 	}
 }
+
+func TestCRC16(t *testing.T) {
+	var frame []byte
+
+	if CRC16([]byte{0x01, 0x02, 0x03, 0x04, 0x05}) != 0xbb2a {
+		t.Errorf("expected 0xbb2a, saw 0x%04x", CRC16([]byte{0x01, 0x02, 0x03, 0x04, 0x05}))
+	}
+
+	// read holding registers request (unit id 0x11, addr 0x006b, qty 3)
+	frame = AppendCRC16([]byte{0x11, 0x03, 0x00, 0x6b, 0x00, 0x03})
+	if len(frame) != 8 || frame[6] != 0x76 || frame[7] != 0x87 {
+		t.Errorf("expected {0x76, 0x87} as CRC, got % x", frame[6:])
+	}
+
+	if !CheckCRC16(frame) {
+		t.Errorf("CheckCRC16() should have returned true")
+	}
+
+	frame[3] ^= 0x01
+	if CheckCRC16(frame) {
+		t.Errorf("CheckCRC16() should have returned false")
+	}
+
+	if CheckCRC16([]byte{0xff}) || CheckCRC16(nil) {
+		t.Errorf("CheckCRC16() should have returned false on short frames")
+	}
+
+	// the CRC of an empty frame is the initial value
+	if !CheckCRC16([]byte{0xff, 0xff}) {
+		t.Errorf("CheckCRC16() should have returned true")
+	}
+}
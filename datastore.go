@@ -0,0 +1,175 @@
+package modbus
+
+import (
+	"sync"
+)
+
+// In-memory data store backing coils, discrete inputs, holding and input
+// registers with slices.
+// DataStore implements the RequestHandler interface and can be passed as is
+// to NewServer(): requests are served from the store regardless of their
+// unit id, and accesses falling outside of the configured address spaces
+// are answered with an illegal data address exception.
+// All methods are safe for concurrent use, allowing the application to
+// update or read values while the server is running.
+type DataStore struct {
+	// OnCoilsWrite, if set, is called after coils have been written by a
+	// client, with the base address and the values written.
+	OnCoilsWrite func(addr uint16, values []bool)
+
+	// OnHoldingRegistersWrite, if set, is called after holding registers
+	// have been written by a client, with the base address and the values
+	// written.
+	OnHoldingRegistersWrite func(addr uint16, values []uint16)
+
+	lock             sync.RWMutex
+	coils            []bool
+	discreteInputs   []bool
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+}
+
+// Returns a new data store holding the given number of coils, discrete
+// inputs, holding and input registers, all starting at address 0 and
+// initialized to zero.
+func NewDataStore(coils int, discreteInputs int, holdingRegisters int, inputRegisters int) *DataStore {
+	return &DataStore{
+		coils:            make([]bool, coils),
+		discreteInputs:   make([]bool, discreteInputs),
+		holdingRegisters: make([]uint16, holdingRegisters),
+		inputRegisters:   make([]uint16, inputRegisters),
+	}
+}
+
+// Returns quantity coils starting at addr.
+func (ds *DataStore) GetCoils(addr uint16, quantity uint16) ([]bool, error) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return getValues(ds.coils, addr, quantity)
+}
+
+// Sets coils starting at addr.
+func (ds *DataStore) SetCoils(addr uint16, values []bool) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	return setValues(ds.coils, addr, values)
+}
+
+// Returns quantity discrete inputs starting at addr.
+func (ds *DataStore) GetDiscreteInputs(addr uint16, quantity uint16) ([]bool, error) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return getValues(ds.discreteInputs, addr, quantity)
+}
+
+// Sets discrete inputs starting at addr.
+func (ds *DataStore) SetDiscreteInputs(addr uint16, values []bool) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	return setValues(ds.discreteInputs, addr, values)
+}
+
+// Returns quantity holding registers starting at addr.
+func (ds *DataStore) GetHoldingRegisters(addr uint16, quantity uint16) ([]uint16, error) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return getValues(ds.holdingRegisters, addr, quantity)
+}
+
+// Sets holding registers starting at addr.
+func (ds *DataStore) SetHoldingRegisters(addr uint16, values []uint16) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	return setValues(ds.holdingRegisters, addr, values)
+}
+
+// Returns quantity input registers starting at addr.
+func (ds *DataStore) GetInputRegisters(addr uint16, quantity uint16) ([]uint16, error) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	return getValues(ds.inputRegisters, addr, quantity)
+}
+
+// Sets input registers starting at addr.
+func (ds *DataStore) SetInputRegisters(addr uint16, values []uint16) error {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	return setValues(ds.inputRegisters, addr, values)
+}
+
+// Serves read coils (0x01), write single coil (0x05) and write multiple
+// coils (0x0f) requests.
+func (ds *DataStore) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	if req.IsWrite {
+		err = ds.SetCoils(req.Addr, req.Args)
+		if err != nil {
+			return
+		}
+
+		if ds.OnCoilsWrite != nil {
+			ds.OnCoilsWrite(req.Addr, req.Args)
+		}
+	}
+
+	return ds.GetCoils(req.Addr, req.Quantity)
+}
+
+// Serves read discrete inputs (0x02) requests.
+func (ds *DataStore) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	return ds.GetDiscreteInputs(req.Addr, req.Quantity)
+}
+
+// Serves read holding registers (0x03), write single register (0x06) and
+// write multiple registers (0x10) requests.
+func (ds *DataStore) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if req.IsWrite {
+		err = ds.SetHoldingRegisters(req.Addr, req.Args)
+		if err != nil {
+			return
+		}
+
+		if ds.OnHoldingRegistersWrite != nil {
+			ds.OnHoldingRegistersWrite(req.Addr, req.Args)
+		}
+	}
+
+	return ds.GetHoldingRegisters(req.Addr, req.Quantity)
+}
+
+// Serves read input registers (0x04) requests.
+func (ds *DataStore) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	return ds.GetInputRegisters(req.Addr, req.Quantity)
+}
+
+// Returns a copy of quantity items of table starting at addr, or
+// ErrIllegalDataAddress if any of them falls outside of the table.
+func getValues[T bool | uint16](table []T, addr uint16, quantity uint16) ([]T, error) {
+	if int(addr)+int(quantity) > len(table) {
+		return nil, ErrIllegalDataAddress
+	}
+
+	values := make([]T, quantity)
+	copy(values, table[addr:])
+
+	return values, nil
+}
+
+// Copies values into table starting at addr, or returns
+// ErrIllegalDataAddress if any of them falls outside of the table.
+func setValues[T bool | uint16](table []T, addr uint16, values []T) error {
+	if int(addr)+len(values) > len(table) {
+		return ErrIllegalDataAddress
+	}
+
+	copy(table[addr:], values)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDataStore(t *testing.T) {
+	var ds *DataStore
+	var err error
+	var bools []bool
+	var regs []uint16
+
+	ds = NewDataStore(10, 5, 20, 3)
+
+	// all values should start at zero
+	bools, err = ds.GetCoils(0, 10)
+	if err != nil {
+		t.Errorf("GetCoils() should have succeeded, got: %v", err)
+	}
+	for i, v := range bools {
+		if v {
+			t.Errorf("expected false at position %v", i)
+		}
+	}
+
+	// accesses past the end of each table should fail
+	_, err = ds.GetCoils(0, 11)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("GetCoils() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	_, err = ds.GetDiscreteInputs(5, 1)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("GetDiscreteInputs() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	err = ds.SetHoldingRegisters(19, []uint16{0x0001, 0x0002})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("SetHoldingRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	err = ds.SetInputRegisters(0xffff, []uint16{0x0001})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("SetInputRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// values should read back as written
+	err = ds.SetInputRegisters(1, []uint16{0x1234, 0x5678})
+	if err != nil {
+		t.Errorf("SetInputRegisters() should have succeeded, got: %v", err)
+	}
+	regs, err = ds.GetInputRegisters(0, 3)
+	if err != nil {
+		t.Errorf("GetInputRegisters() should have succeeded, got: %v", err)
+	}
+	for i, v := range []uint16{0x0000, 0x1234, 0x5678} {
+		if regs[i] != v {
+			t.Errorf("expected 0x%04x at position %v, got: 0x%04x", v, i, regs[i])
+		}
+	}
+
+	// returned slices should be copies
+	regs[1] = 0xffff
+	regs, _ = ds.GetInputRegisters(1, 1)
+	if regs[0] != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x", regs[0])
+	}
+}
+
+func TestTCPServerWithDataStore(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var ds *DataStore
+	var err error
+	var regs []uint16
+	var coils []bool
+	var writtenAddr uint16
+	var writtenValues []uint16
+
+	ds = NewDataStore(16, 16, 16, 16)
+	ds.OnHoldingRegistersWrite = func(addr uint16, values []uint16) {
+		writtenAddr = addr
+		writtenValues = values
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5506",
+		MaxClients: 1,
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5506",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// values set by the application should be visible to clients
+	ds.SetInputRegisters(2, []uint16{0xcafe})
+	ds.SetDiscreteInputs(15, []bool{true})
+
+	regs, err = client.ReadRegisters(2, 1, INPUT_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if regs[0] != 0xcafe {
+		t.Errorf("expected 0xcafe, got: 0x%04x", regs[0])
+	}
+
+	coils, err = client.ReadDiscreteInputs(14, 2)
+	if err != nil {
+		t.Errorf("client.ReadDiscreteInputs() should have succeeded, got: %v", err)
+	}
+	if coils[0] || !coils[1] {
+		t.Errorf("expected {false, true}, got: %v", coils)
+	}
+
+	// values written by clients should be visible to the application
+	err = client.WriteRegisters(4, []uint16{0x0102, 0x0304})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+	regs, _ = ds.GetHoldingRegisters(4, 2)
+	if regs[0] != 0x0102 || regs[1] != 0x0304 {
+		t.Errorf("expected {0x0102, 0x0304}, got: %v", regs)
+	}
+	if writtenAddr != 4 || len(writtenValues) != 2 {
+		t.Errorf("unexpected write callback arguments: %v, %v", writtenAddr, writtenValues)
+	}
+
+	err = client.WriteCoil(7, true)
+	if err != nil {
+		t.Errorf("client.WriteCoil() should have succeeded, got: %v", err)
+	}
+	coils, _ = ds.GetCoils(7, 1)
+	if !coils[0] {
+		t.Errorf("expected coil 7 to be set")
+	}
+
+	// accesses outside of the store should yield illegal data address
+	// exceptions
+	_, err = client.ReadRegisters(10, 7, HOLDING_REGISTER)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("client.ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	err = client.WriteCoils(15, []bool{true, true})
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("client.WriteCoils() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package modbus
+
+// Enron (a.k.a. Daniel) modbus is a dialect used by flow computers, where
+// some register ranges (typically 5001-5999 for floats and 7001-7999 for
+// 32-bit integers) hold 32-bit registers: each register counts as one in
+// addresses and quantities but carries 4 bytes on the wire.
+// Since those devices usually also expose regular 16-bit registers, Enron
+// registers are accessed through the dedicated methods below rather than
+// through a client-wide setting.
+// Values are decoded using the byte and word order of the client (see
+// SetEncoding()).
+
+// Reads multiple 32-bit Enron registers as unsigned integers
+// (function code 03 or 04). quantity must be between 1 and 62.
+func (mc *ModbusClient) ReadEnronUint32s(addr uint16, quantity uint16, regType RegType) (values []uint32, err error) {
+	var mbPayload []byte
+
+	mbPayload, err = mc.readEnronRegisters(addr, quantity, regType)
+	if err != nil {
+		return
+	}
+
+	values = bytesToUint32s(mc.endianness, mc.wordOrder, mbPayload)
+
+	return
+}
+
+// Reads multiple 32-bit Enron registers as floats
+// (function code 03 or 04). quantity must be between 1 and 62.
+func (mc *ModbusClient) ReadEnronFloat32s(addr uint16, quantity uint16, regType RegType) (values []float32, err error) {
+	var mbPayload []byte
+
+	mbPayload, err = mc.readEnronRegisters(addr, quantity, regType)
+	if err != nil {
+		return
+	}
+
+	values = bytesToFloat32s(mc.endianness, mc.wordOrder, mbPayload)
+
+	return
+}
+
+// Writes multiple 32-bit Enron registers as unsigned integers
+// (function code 16). values must hold between 1 and 61 values.
+func (mc *ModbusClient) WriteEnronUint32s(addr uint16, values []uint32) (err error) {
+	var payload []byte
+
+	for _, value := range values {
+		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+
+	err = mc.writeRegistersWidth(addr, payload, 4)
+
+	return
+}
+
+// Writes multiple 32-bit Enron registers as floats
+// (function code 16). values must hold between 1 and 61 values.
+func (mc *ModbusClient) WriteEnronFloat32s(addr uint16, values []float32) (err error) {
+	var payload []byte
+
+	for _, value := range values {
+		payload = append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+
+	err = mc.writeRegistersWidth(addr, payload, 4)
+
+	return
+}
+
+// Reads multiple 32-bit Enron registers, as bytes.
+func (mc *ModbusClient) readEnronRegisters(addr uint16, quantity uint16, regType RegType) (bytes []byte, err error) {
+	mc.lock.Lock()
+	unitId := mc.unitId
+	mc.lock.Unlock()
+
+	return mc.readUnitRegistersWidth(unitId, addr, quantity, regType, 4)
+}
@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClientEnronRegisters(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var lastReq *pdu
+	var u32s []uint32
+	var f32s []float32
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		lastReq = req
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		switch req.functionCode {
+		case FC_READ_HOLDING_REGISTERS:
+			// 2 32-bit registers at 7001
+			res.payload = []byte{
+				0x08,
+				0x00, 0x01, 0x00, 0x02,
+				0xde, 0xad, 0xbe, 0xef,
+			}
+		case FC_READ_INPUT_REGISTERS:
+			// 3.14 as a single 32-bit register at 5001
+			res.payload = []byte{0x04, 0x40, 0x48, 0xf5, 0xc3}
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			res.payload = req.payload[0:4]
+		}
+		return res, nil
+	})
+
+	// each register should carry 4 bytes
+	u32s, err = client.ReadEnronUint32s(7001, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadEnronUint32s() should have succeeded, got: %v", err)
+	}
+	if !bytes.Equal(lastReq.payload, []byte{0x1b, 0x59, 0x00, 0x02}) {
+		t.Errorf("unexpected request payload: %x", lastReq.payload)
+	}
+	if len(u32s) != 2 || u32s[0] != 0x00010002 || u32s[1] != 0xdeadbeef {
+		t.Errorf("unexpected values: %x", u32s)
+	}
+
+	f32s, err = client.ReadEnronFloat32s(5001, 1, INPUT_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadEnronFloat32s() should have succeeded, got: %v", err)
+	}
+	if len(f32s) != 1 || f32s[0] != 3.14 {
+		t.Errorf("unexpected values: %v", f32s)
+	}
+
+	// 16-bit register widths should be rejected
+	_, err = client.ReadEnronUint32s(7001, 4, HOLDING_REGISTER)
+	if err != ErrProtocol {
+		t.Errorf("expected %v, got: %v", ErrProtocol, err)
+	}
+
+	err = client.WriteEnronUint32s(7001, []uint32{0x01020304, 0x05060708})
+	if err != nil {
+		t.Fatalf("WriteEnronUint32s() should have succeeded, got: %v", err)
+	}
+	if !bytes.Equal(lastReq.payload, []byte{
+		0x1b, 0x59, 0x00, 0x02, 0x08,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	}) {
+		t.Errorf("unexpected request payload: %x", lastReq.payload)
+	}
+
+	err = client.WriteEnronFloat32s(5001, []float32{3.14})
+	if err != nil {
+		t.Fatalf("WriteEnronFloat32s() should have succeeded, got: %v", err)
+	}
+	if !bytes.Equal(lastReq.payload, []byte{
+		0x13, 0x89, 0x00, 0x01, 0x04, 0x40, 0x48, 0xf5, 0xc3,
+	}) {
+		t.Errorf("unexpected request payload: %x", lastReq.payload)
+	}
+
+	// quantities should be limited to what fits in a PDU
+	lastReq = nil
+	_, err = client.ReadEnronFloat32s(5001, 63, INPUT_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+	}
+
+	err = client.WriteEnronUint32s(7001, make([]uint32, 62))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+	}
+	if lastReq != nil {
+		t.Errorf("no request should have been sent, got: %v", lastReq)
+	}
+
+	_, err = client.ReadEnronUint32s(7001, 62, HOLDING_REGISTER)
+	if err != ErrProtocol || lastReq == nil {
+		t.Errorf("62 registers should have been requested, got: %v", err)
+	}
+}
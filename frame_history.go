@@ -0,0 +1,61 @@
+package modbus
+
+import (
+	"sync"
+	"time"
+)
+
+// Frame sent or received by a client, as returned by RecentFrames().
+type FrameRecord struct {
+	Time      time.Time
+	Direction Direction
+	// raw frame (MBAP header + PDU)
+	Frame []byte
+}
+
+// Ring buffer holding the most recent frames sent and received.
+type frameHistory struct {
+	lock    sync.Mutex
+	records []FrameRecord
+	// index of the oldest record once the buffer is full
+	next int
+}
+
+// Returns a frame history holding up to size frames.
+func newFrameHistory(size int) *frameHistory {
+	return &frameHistory{
+		records: make([]FrameRecord, 0, size),
+	}
+}
+
+// Records a copy of frame, evicting the oldest record if the buffer is full.
+func (fh *frameHistory) add(direction Direction, frame []byte) {
+	var record FrameRecord = FrameRecord{
+		Time:      time.Now(),
+		Direction: direction,
+		Frame:     append([]byte(nil), frame...),
+	}
+
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	if len(fh.records) < cap(fh.records) {
+		fh.records = append(fh.records, record)
+		return
+	}
+
+	fh.records[fh.next] = record
+	fh.next = (fh.next + 1) % len(fh.records)
+}
+
+// Returns a copy of the records, oldest first.
+func (fh *frameHistory) snapshot() (records []FrameRecord) {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	records = make([]FrameRecord, 0, len(fh.records))
+	records = append(records, fh.records[fh.next:]...)
+	records = append(records, fh.records[:fh.next]...)
+
+	return
+}
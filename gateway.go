@@ -0,0 +1,63 @@
+package modbus
+
+import (
+	"sync"
+)
+
+// Gateway is a client to a modbus TCP to RTU (serial) gateway, forwarding
+// requests to the device selected by the unit id of each request.
+// Devices behind the gateway are addressed through per unit id handles
+// (see Unit()), all sharing the same connection and transaction id space.
+// Requests made through handles are serialized so that frames from
+// concurrent handles never interleave (unless Pipelined is set in the
+// client configuration, in which case the gateway is expected to handle
+// concurrent transactions). Set Scheduling to SCHEDULING_ROUND_ROBIN in the
+// client configuration to keep slow devices from starving the others.
+type Gateway struct {
+	client  *ModbusClient
+	lock    sync.Mutex
+	handles map[uint8]*ModbusClient
+}
+
+// Returns a new Gateway object, using the same configuration object and
+// URL format as NewClient() (e.g. tcp://gateway:502).
+func NewGateway(conf *ClientConfiguration) (gw *Gateway, err error) {
+	gw = &Gateway{
+		handles: make(map[uint8]*ModbusClient),
+	}
+
+	gw.client, err = NewClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Opens the connection to the gateway.
+func (gw *Gateway) Open() error {
+	return gw.client.Open()
+}
+
+// Closes the connection to the gateway.
+func (gw *Gateway) Close() error {
+	return gw.client.Close()
+}
+
+// Returns a client handle addressing the device at unitId behind the
+// gateway. Handles are created on first use and reused afterwards, so that
+// settings applied to a handle (e.g. with SetEncoding()) stick to the
+// device. Calling Open() or Close() on a handle has no effect: the
+// connection is managed through the Gateway object.
+func (gw *Gateway) Unit(unitId uint8) *ModbusClient {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+
+	handle, ok := gw.handles[unitId]
+	if !ok {
+		handle = gw.client.WithUnitId(unitId)
+		gw.handles[unitId] = handle
+	}
+
+	return handle
+}
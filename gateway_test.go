@@ -0,0 +1,97 @@
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test handler playing the role of devices behind a gateway, each holding
+// its unit id in all of its holding registers.
+type gatewayTestHandler struct {
+	tcpTestHandler
+}
+
+func (th *gatewayTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if req.UnitId == 0x10 {
+		// no such device
+		err = ErrGWTargetFailedToRespond
+		return
+	}
+
+	for i := 0; i < int(req.Quantity); i++ {
+		res = append(res, uint16(req.UnitId))
+	}
+
+	return
+}
+
+func TestGateway(t *testing.T) {
+	var server *ModbusServer
+	var gw *Gateway
+	var err error
+	var wg sync.WaitGroup
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5507",
+		MaxClients: 1,
+	}, &gatewayTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	gw, err = NewGateway(&ClientConfiguration{
+		URL:     "tcp://localhost:5507",
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create gateway: %v", err)
+	}
+
+	err = gw.Open()
+	if err != nil {
+		t.Fatalf("failed to open gateway: %v", err)
+	}
+	defer gw.Close()
+
+	// handles should be reused
+	if gw.Unit(3) != gw.Unit(3) {
+		t.Errorf("Unit() should have returned the same handle")
+	}
+
+	// concurrent handles should each address their own device over the
+	// shared connection
+	for unitId := uint8(1); unitId <= 8; unitId++ {
+		wg.Add(1)
+		go func(unitId uint8) {
+			defer wg.Done()
+
+			for i := 0; i < 10; i++ {
+				regs, err := gw.Unit(unitId).ReadHoldingRegisters(0, 4)
+				if err != nil {
+					t.Errorf("unit %v: ReadHoldingRegisters() should have succeeded, got: %v",
+						unitId, err)
+					return
+				}
+				for _, reg := range regs {
+					if reg != uint16(unitId) {
+						t.Errorf("unit %v: unexpected register value %v", unitId, reg)
+					}
+				}
+			}
+		}(unitId)
+	}
+	wg.Wait()
+
+	_, err = gw.Unit(0x10).ReadHoldingRegisters(0, 1)
+	if !errors.Is(err, ErrGWTargetFailedToRespond) {
+		t.Errorf("ReadHoldingRegisters() should have returned ErrGWTargetFailedToRespond, got: %v", err)
+	}
+}
@@ -6,42 +6,108 @@ import (
 	"os"
 )
 
+type LogLevel uint
+
+const (
+	LOG_DEBUG   LogLevel = 1
+	LOG_INFO    LogLevel = 2
+	LOG_WARNING LogLevel = 3
+	LOG_ERROR   LogLevel = 4
+	LOG_OFF     LogLevel = 5
+)
+
+// LogFunc receives log messages along with structured fields, as an
+// alternative to plain text output (e.g. to route messages to a structured
+// logging library).
+// fields always contains a "source" key identifying the emitting object
+// (e.g. "modbus-client(localhost:502)"), plus message-specific keys.
+type LogFunc func(level LogLevel, msg string, fields map[string]any)
+
 type logger struct {
 	prefix       string
 	customLogger *log.Logger
+	level        LogLevel
+	logFunc      LogFunc
 }
 
 func newLogger(prefix string, customLogger *log.Logger) (l *logger) {
 	l = &logger{
 		prefix:       prefix,
 		customLogger: customLogger,
+		level:        LOG_INFO,
 	}
 
 	return
 }
 
+// Returns a new logger with the given prefix, inheriting the output, level
+// and log function of l (if l is nil, the new logger uses defaults).
+func (l *logger) derive(prefix string) (child *logger) {
+	if l == nil {
+		return newLogger(prefix, nil)
+	}
+
+	child = newLogger(prefix, l.customLogger)
+	child.level = l.level
+	child.logFunc = l.logFunc
+
+	return
+}
+
+// Sets the minimum level of messages to output (0 selects LOG_INFO) and the
+// optional structured log function.
+func (l *logger) configure(level LogLevel, logFunc LogFunc) {
+	if level == 0 {
+		level = LOG_INFO
+	}
+	l.level = level
+	l.logFunc = logFunc
+}
+
+func (l *logger) Debug(msg string) {
+	l.log(LOG_DEBUG, msg, nil)
+}
+
+func (l *logger) Debugf(format string, msg ...interface{}) {
+	if l.enabled(LOG_DEBUG) {
+		l.log(LOG_DEBUG, fmt.Sprintf(format, msg...), nil)
+	}
+}
+
 func (l *logger) Info(msg string) {
-	l.write(fmt.Sprintf("%s [info]: %s\n", l.prefix, msg))
+	l.log(LOG_INFO, msg, nil)
 }
 
 func (l *logger) Infof(format string, msg ...interface{}) {
-	l.write(fmt.Sprintf("%s [info]: %s\n", l.prefix, fmt.Sprintf(format, msg...)))
+	if l.enabled(LOG_INFO) {
+		l.log(LOG_INFO, fmt.Sprintf(format, msg...), nil)
+	}
 }
 
 func (l *logger) Warning(msg string) {
-	l.write(fmt.Sprintf("%s [warn]: %s\n", l.prefix, msg))
+	l.log(LOG_WARNING, msg, nil)
 }
 
 func (l *logger) Warningf(format string, msg ...interface{}) {
-	l.write(fmt.Sprintf("%s [warn]: %s\n", l.prefix, fmt.Sprintf(format, msg...)))
+	if l.enabled(LOG_WARNING) {
+		l.log(LOG_WARNING, fmt.Sprintf(format, msg...), nil)
+	}
+}
+
+// Logs a warning along with structured fields. Fields are passed as is to
+// the log function if one is set, and ignored otherwise.
+func (l *logger) Warningw(msg string, fields map[string]any) {
+	l.log(LOG_WARNING, msg, fields)
 }
 
 func (l *logger) Error(msg string) {
-	l.write(fmt.Sprintf("%s [error]: %s\n", l.prefix, msg))
+	l.log(LOG_ERROR, msg, nil)
 }
 
 func (l *logger) Errorf(format string, msg ...interface{}) {
-	l.write(fmt.Sprintf("%s [error]: %s\n", l.prefix, fmt.Sprintf(format, msg...)))
+	if l.enabled(LOG_ERROR) {
+		l.log(LOG_ERROR, fmt.Sprintf(format, msg...), nil)
+	}
 }
 
 func (l *logger) Fatal(msg string) {
@@ -54,6 +120,40 @@ func (l *logger) Fatalf(format string, msg ...interface{}) {
 	os.Exit(1)
 }
 
+func (l *logger) enabled(level LogLevel) bool {
+	return level >= l.level && l.level != LOG_OFF
+}
+
+func (l *logger) log(level LogLevel, msg string, fields map[string]any) {
+	var tag string
+
+	if !l.enabled(level) {
+		return
+	}
+
+	if l.logFunc != nil {
+		if fields == nil {
+			fields = make(map[string]any, 1)
+		}
+		fields["source"] = l.prefix
+		l.logFunc(level, msg, fields)
+		return
+	}
+
+	switch level {
+	case LOG_DEBUG:
+		tag = "debug"
+	case LOG_INFO:
+		tag = "info"
+	case LOG_WARNING:
+		tag = "warn"
+	default:
+		tag = "error"
+	}
+
+	l.write(fmt.Sprintf("%s [%s]: %s\n", l.prefix, tag, msg))
+}
+
 func (l *logger) write(msg string) {
 	if l.customLogger == nil {
 		os.Stdout.WriteString(msg)
@@ -33,3 +33,77 @@ func TestServerCustomLogger(t *testing.T) {
 		t.Errorf("unexpected logger output '%s'", buf.String())
 	}
 }
+
+func TestLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	var l *logger
+
+	l = newLogger("test", log.New(&buf, "", 0))
+
+	// debug messages should be filtered out by default
+	l.Debugf("debug %v", 1)
+	l.Infof("info %v", 2)
+	if buf.String() != "test [info]: info 2\n" {
+		t.Errorf("unexpected logger output '%s'", buf.String())
+	}
+
+	buf.Reset()
+	l.configure(LOG_ERROR, nil)
+	l.Warning("warning")
+	l.Error("error")
+	if buf.String() != "test [error]: error\n" {
+		t.Errorf("unexpected logger output '%s'", buf.String())
+	}
+
+	buf.Reset()
+	l.configure(LOG_OFF, nil)
+	l.Error("error")
+	if buf.Len() != 0 {
+		t.Errorf("unexpected logger output '%s'", buf.String())
+	}
+}
+
+func TestLoggerLogFunc(t *testing.T) {
+	var buf bytes.Buffer
+	var l *logger
+	var levels []LogLevel
+	var msgs []string
+	var fields []map[string]any
+
+	l = newLogger("test", log.New(&buf, "", 0))
+	l.configure(LOG_DEBUG, func(level LogLevel, msg string, f map[string]any) {
+		levels = append(levels, level)
+		msgs = append(msgs, msg)
+		fields = append(fields, f)
+	})
+
+	l.Debug("debug")
+	l.Warningw("unexpected transaction id", map[string]any{
+		"expected_txn_id": uint16(1),
+		"received_txn_id": uint16(2),
+	})
+
+	// nothing should have been written to the text logger
+	if buf.Len() != 0 {
+		t.Errorf("unexpected logger output '%s'", buf.String())
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %v", len(msgs))
+	}
+	if levels[0] != LOG_DEBUG || msgs[0] != "debug" || fields[0]["source"] != "test" {
+		t.Errorf("unexpected first message: %v, %v, %v", levels[0], msgs[0], fields[0])
+	}
+	if levels[1] != LOG_WARNING ||
+		fields[1]["expected_txn_id"] != uint16(1) ||
+		fields[1]["received_txn_id"] != uint16(2) ||
+		fields[1]["source"] != "test" {
+		t.Errorf("unexpected second message: %v, %v, %v", levels[1], msgs[1], fields[1])
+	}
+
+	// derived loggers should inherit the level and log function
+	l.derive("child").Infof("info")
+	if len(msgs) != 3 || fields[2]["source"] != "child" {
+		t.Errorf("expected a message from the derived logger, got %v", msgs)
+	}
+}
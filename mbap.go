@@ -0,0 +1,196 @@
+package modbus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PDU is a modbus request or response, along with the unit id it is
+// addressed to or originates from.
+type PDU struct {
+	UnitId       uint8
+	FunctionCode uint8
+	Payload      []byte
+}
+
+// Turns p into an MBAP frame (MBAP header + PDU) with transaction id txnId,
+// as sent over tcp, tcp+tls and udp transports.
+// Requests and responses are framed alike: frames returned for responses are
+// byte-for-byte those written by the server (for the same transaction id),
+// making this usable to pre-build responses, e.g. in gateways or tests.
+func EncodeMBAP(txnId uint16, p *PDU) []byte {
+	return encodeMBAPFrame(txnId, mbapProtocolId, &pdu{
+		unitId:       p.UnitId,
+		functionCode: p.FunctionCode,
+		payload:      p.Payload,
+	})
+}
+
+// Parses a complete MBAP frame (MBAP header + PDU), e.g. the payload of a
+// captured TCP segment, and returns its PDU and transaction id.
+// ErrProtocol is returned if frame is truncated or if its length does not
+// match that of the MBAP header, and ErrUnknownProtocolId if the protocol
+// id of the header is not 0 (modbus). The returned payload does not alias
+// frame.
+func DecodeMBAP(frame []byte) (*PDU, uint16, error) {
+	p, err := decodeMBAPFrame(frame, mbapProtocolId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &PDU{
+		UnitId:       p.unitId,
+		FunctionCode: p.functionCode,
+		Payload:      p.payload,
+	}, p.txnId, nil
+}
+
+// Turns a PDU into an MBAP frame (MBAP header + PDU) and returns it as bytes.
+// protocolId is mbapProtocolId (modbus) unless a tunneling protocol reusing
+// the MBAP header is in use.
+func encodeMBAPFrame(txnId uint16, protocolId uint16, p *pdu) []byte {
+	var frame []byte = make([]byte, mbapHeaderLength+1+len(p.payload))
+	// length (covers unit identifier + function code + payload fields)
+	var length int = 2 + len(p.payload)
+
+	// transaction identifier
+	frame[0] = byte(txnId >> 8)
+	frame[1] = byte(txnId)
+	// protocol identifier
+	frame[2] = byte(protocolId >> 8)
+	frame[3] = byte(protocolId)
+	// length
+	frame[4] = byte(length >> 8)
+	frame[5] = byte(length)
+	// unit identifier
+	frame[6] = p.unitId
+	// function code
+	frame[7] = p.functionCode
+	// payload
+	copy(frame[8:], p.payload)
+
+	return frame
+}
+
+// Decodes a complete MBAP frame into a PDU, copying the payload out of
+// frame. Frames bearing a protocol id other than protocolId are rejected
+// with ErrUnknownProtocolId.
+func decodeMBAPFrame(frame []byte, protocolId uint16) (*pdu, error) {
+	// expect at least an MBAP header and a function code
+	if len(frame) < mbapHeaderLength+1 {
+		return nil, ErrProtocol
+	}
+
+	// the length field covers the unit id and the PDU
+	if int(bytesToUint16(BIG_ENDIAN, frame[4:6])) != len(frame)-mbapHeaderLength+1 {
+		return nil, ErrProtocol
+	}
+
+	// validate the protocol identifier
+	if bytesToUint16(BIG_ENDIAN, frame[2:4]) != protocolId {
+		return nil, ErrUnknownProtocolId
+	}
+
+	return &pdu{
+		unitId:       frame[6],
+		functionCode: frame[7],
+		payload:      append([]byte(nil), frame[8:]...),
+		txnId:        bytesToUint16(BIG_ENDIAN, frame[0:2]),
+	}, nil
+}
+
+// Returns a human-readable dump of an MBAP frame (MBAP header + PDU), e.g.
+// "txn=0x0001 proto=0x0000 len=6 unit=0x01 fc=0x03 (Read Holding Registers)
+// addr=100 qty=10", for diagnostics and bug reports.
+// As frames do not tell requests from responses, payloads are decoded as
+// whichever of the two they are shaped like (responses first). Malformed
+// frames are dumped as far as possible rather than rejected.
+func FormatFrame(frame []byte) string {
+	var sb strings.Builder
+
+	if len(frame) < mbapHeaderLength+1 {
+		return fmt.Sprintf("truncated frame (% x)", frame)
+	}
+
+	fmt.Fprintf(&sb, "txn=0x%04x proto=0x%04x len=%v unit=0x%02x fc=0x%02x",
+		bytesToUint16(BIG_ENDIAN, frame[0:2]), bytesToUint16(BIG_ENDIAN, frame[2:4]),
+		bytesToUint16(BIG_ENDIAN, frame[4:6]), frame[6], frame[7])
+
+	if _, ok := functionCodeNames[frame[7]&0x7f]; ok {
+		fmt.Fprintf(&sb, " (%s)", FunctionCodeName(frame[7]))
+	}
+
+	if payload := formatPayload(frame[7], frame[8:]); payload != "" {
+		sb.WriteString(" " + payload)
+	}
+
+	// the length field covers the unit id and the PDU
+	if int(bytesToUint16(BIG_ENDIAN, frame[4:6])) != len(frame)-mbapHeaderLength+1 {
+		fmt.Fprintf(&sb, " [length mismatch: %v bytes follow the length field]",
+			len(frame)-mbapHeaderLength+1)
+	}
+
+	return sb.String()
+}
+
+// Decodes the payload of a request or response into key=value pairs.
+func formatPayload(functionCode uint8, p []byte) string {
+	// responses carrying a byte count are told apart by their length
+	var isResponse bool = len(p) >= 1 && int(p[0]) == len(p)-1
+
+	switch {
+	case functionCode&0x80 != 0 && len(p) == 1:
+		return fmt.Sprintf("exception=0x%02x (%v)", p[0],
+			mapExceptionCodeToError(functionCode, p[0]))
+
+	case (functionCode == FC_READ_HOLDING_REGISTERS || functionCode == FC_READ_INPUT_REGISTERS ||
+		functionCode == FC_READ_WRITE_MULTIPLE_REGISTERS) && isResponse && len(p)%2 == 1:
+		return fmt.Sprintf("byte_count=%v values=%04x", p[0],
+			bytesToUint16s(BIG_ENDIAN, p[1:]))
+
+	case (functionCode == FC_READ_COILS || functionCode == FC_READ_DISCRETE_INPUTS) &&
+		isResponse:
+		return fmt.Sprintf("byte_count=%v data=% x", p[0], p[1:])
+
+	case (functionCode == FC_READ_COILS || functionCode == FC_READ_DISCRETE_INPUTS ||
+		functionCode == FC_READ_HOLDING_REGISTERS || functionCode == FC_READ_INPUT_REGISTERS ||
+		functionCode == FC_WRITE_MULTIPLE_COILS || functionCode == FC_WRITE_MULTIPLE_REGISTERS) &&
+		len(p) == 4:
+		return fmt.Sprintf("addr=%v qty=%v", bytesToUint16(BIG_ENDIAN, p[0:2]),
+			bytesToUint16(BIG_ENDIAN, p[2:4]))
+
+	case (functionCode == FC_WRITE_SINGLE_COIL || functionCode == FC_WRITE_SINGLE_REGISTER) &&
+		len(p) == 4:
+		return fmt.Sprintf("addr=%v value=0x%04x", bytesToUint16(BIG_ENDIAN, p[0:2]),
+			bytesToUint16(BIG_ENDIAN, p[2:4]))
+
+	case functionCode == FC_WRITE_MULTIPLE_COILS && len(p) >= 5 && int(p[4]) == len(p)-5:
+		return fmt.Sprintf("addr=%v qty=%v byte_count=%v data=% x",
+			bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4]),
+			p[4], p[5:])
+
+	case functionCode == FC_WRITE_MULTIPLE_REGISTERS && len(p) >= 5 &&
+		int(p[4]) == len(p)-5 && p[4]%2 == 0:
+		return fmt.Sprintf("addr=%v qty=%v byte_count=%v values=%04x",
+			bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4]),
+			p[4], bytesToUint16s(BIG_ENDIAN, p[5:]))
+
+	case functionCode == FC_MASK_WRITE_REGISTER && len(p) == 6:
+		return fmt.Sprintf("addr=%v and_mask=0x%04x or_mask=0x%04x",
+			bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4]),
+			bytesToUint16(BIG_ENDIAN, p[4:6]))
+
+	case functionCode == FC_READ_WRITE_MULTIPLE_REGISTERS && len(p) >= 9 &&
+		int(p[8]) == len(p)-9 && p[8]%2 == 0:
+		return fmt.Sprintf("read_addr=%v read_qty=%v write_addr=%v write_qty=%v "+
+			"byte_count=%v values=%04x",
+			bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4]),
+			bytesToUint16(BIG_ENDIAN, p[4:6]), bytesToUint16(BIG_ENDIAN, p[6:8]),
+			p[8], bytesToUint16s(BIG_ENDIAN, p[9:]))
+
+	case len(p) > 0:
+		return fmt.Sprintf("data=% x", p)
+	}
+
+	return ""
+}
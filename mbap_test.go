@@ -0,0 +1,166 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeMBAP(t *testing.T) {
+	var frame []byte
+	var p *PDU
+	var txnId uint16
+	var err error
+
+	frame = EncodeMBAP(0x1234, &PDU{
+		UnitId:       0x11,
+		FunctionCode: 0x03,
+		Payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	if !bytes.Equal(frame, []byte{
+		0x12, 0x34, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length
+		0x11, 0x03, // unit id and function code
+		0x00, 0x6b, 0x00, 0x03, // payload
+	}) {
+		t.Errorf("unexpected frame: % x", frame)
+	}
+
+	p, txnId, err = DecodeMBAP(frame)
+	if err != nil {
+		t.Fatalf("DecodeMBAP() should have succeeded, got: %v", err)
+	}
+	if txnId != 0x1234 || p.UnitId != 0x11 || p.FunctionCode != 0x03 ||
+		!bytes.Equal(p.Payload, []byte{0x00, 0x6b, 0x00, 0x03}) {
+		t.Errorf("unexpected pdu: 0x%04x, %+v", txnId, p)
+	}
+
+	// the payload should not alias the frame
+	frame[8] = 0xff
+	if p.Payload[0] != 0x00 {
+		t.Errorf("payload should not alias the frame")
+	}
+
+	// truncated frames and inconsistent lengths should be rejected
+	for _, bad := range [][]byte{
+		nil,
+		{0x12, 0x34, 0x00, 0x00, 0x00, 0x01, 0x11},
+		{0x12, 0x34, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6b, 0x00},
+		{0x12, 0x34, 0x00, 0x00, 0x00, 0x02, 0x11, 0x03, 0x00},
+	} {
+		_, _, err = DecodeMBAP(bad)
+		if err != ErrProtocol {
+			t.Errorf("DecodeMBAP(% x) should have returned ErrProtocol, got: %v", bad, err)
+		}
+	}
+
+	_, _, err = DecodeMBAP([]byte{0x12, 0x34, 0x00, 0x01, 0x00, 0x02, 0x11, 0x03})
+	if err != ErrUnknownProtocolId {
+		t.Errorf("DecodeMBAP() should have returned ErrUnknownProtocolId, got: %v", err)
+	}
+}
+
+func TestEncodeMBAPMatchesServerResponses(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var res *PDU = &PDU{
+		UnitId:       0x11,
+		FunctionCode: 0x03,
+		Payload:      []byte{0x04, 0x12, 0x34, 0x56, 0x78},
+	}
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tt = newTCPTransport(p2, 500*time.Millisecond, nil)
+
+	// read a request to pick up its transaction id
+	go p1.Write([]byte{
+		0xab, 0xcd, 0x00, 0x00, 0x00, 0x06,
+		0x11, 0x03, 0x00, 0x00, 0x00, 0x02,
+	})
+
+	_, err = tt.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got: %v", err)
+	}
+
+	go func() {
+		err := tt.WriteResponse(&pdu{
+			unitId:       res.UnitId,
+			functionCode: res.FunctionCode,
+			payload:      res.Payload,
+		})
+		if err != nil {
+			t.Errorf("WriteResponse() should have succeeded, got: %v", err)
+		}
+	}()
+
+	frame := make([]byte, 13)
+	_, err = io.ReadFull(p1, frame)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	if !bytes.Equal(frame, EncodeMBAP(0xabcd, res)) {
+		t.Errorf("EncodeMBAP() should match the server response, got: % x, "+
+			"expected: % x", EncodeMBAP(0xabcd, res), frame)
+	}
+}
+
+func TestFormatFrame(t *testing.T) {
+	for _, tc := range []struct {
+		frame    []byte
+		expected string
+	}{
+		{
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x64, 0x00, 0x0a},
+			"txn=0x0001 proto=0x0000 len=6 unit=0x01 fc=0x03 (Read Holding Registers) " +
+				"addr=100 qty=10",
+		},
+		{
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0x01, 0x04, 0x04, 0x12, 0x34, 0x56, 0x78},
+			"txn=0x0001 proto=0x0000 len=7 unit=0x01 fc=0x04 (Read Input Registers) " +
+				"byte_count=4 values=[1234 5678]",
+		},
+		{
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0xff, 0x05, 0x00, 0x10, 0xff, 0x00},
+			"txn=0x0002 proto=0x0000 len=6 unit=0xff fc=0x05 (Write Single Coil) " +
+				"addr=16 value=0xff00",
+		},
+		{
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x0b, 0x01, 0x10, 0x00, 0x20, 0x00, 0x02,
+				0x04, 0x00, 0x0a, 0x01, 0x02},
+			"txn=0x0003 proto=0x0000 len=11 unit=0x01 fc=0x10 (Write Multiple Registers) " +
+				"addr=32 qty=2 byte_count=4 values=[000a 0102]",
+		},
+		{
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x02},
+			"txn=0x0004 proto=0x0000 len=3 unit=0x01 fc=0x83 (Read Holding Registers " +
+				"exception) exception=0x02 (illegal data address)",
+		},
+		{
+			[]byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x04, 0x01, 0x64, 0x01, 0x02},
+			"txn=0x0005 proto=0x0000 len=4 unit=0x01 fc=0x64 data=01 02",
+		},
+		{
+			[]byte{0x00, 0x06, 0x00, 0x00, 0x00, 0x08, 0x01, 0x03, 0x00, 0x64},
+			"txn=0x0006 proto=0x0000 len=8 unit=0x01 fc=0x03 (Read Holding Registers) " +
+				"data=00 64 [length mismatch: 4 bytes follow the length field]",
+		},
+		{
+			[]byte{0x00, 0x07, 0x00, 0x00},
+			"truncated frame (00 07 00 00)",
+		},
+	} {
+		if res := FormatFrame(tc.frame); res != tc.expected {
+			t.Errorf("FormatFrame(% x): expected '%s', got '%s'",
+				tc.frame, tc.expected, res)
+		}
+	}
+}
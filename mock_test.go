@@ -0,0 +1,244 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMockClient(t *testing.T) {
+	var mt *MockTransport
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+	var reqs []MockRequest
+
+	mt = NewMockTransport()
+	client = NewMockClient(mt)
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("Open() should have succeeded, got: %v", err)
+	}
+
+	// queued responses should be handed out in order
+	mt.Queue(
+		MockResponse{FunctionCode: 0x03, Payload: []byte{0x04, 0x12, 0x34, 0x56, 0x78}},
+		MockException(0x03, 0x02),
+		MockResponse{Err: ErrProtocol},
+	)
+
+	regs, err = client.ReadHoldingRegisters(0x1000, 2)
+	if err != nil {
+		t.Fatalf("ReadHoldingRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+		t.Errorf("unexpected registers: %v", regs)
+	}
+
+	_, err = client.ReadHoldingRegisters(0x1000, 2)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadHoldingRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	_, err = client.ReadHoldingRegisters(0x1000, 2)
+	if err != ErrProtocol {
+		t.Errorf("ReadHoldingRegisters() should have returned ErrProtocol, got: %v", err)
+	}
+
+	// unanswered requests should time out
+	_, err = client.ReadHoldingRegisters(0x1000, 2)
+	if err != ErrRequestTimedOut {
+		t.Errorf("ReadHoldingRegisters() should have returned ErrRequestTimedOut, got: %v", err)
+	}
+
+	// the exact bytes sent should be captured
+	reqs = mt.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("expected 4 requests, got: %v", len(reqs))
+	}
+	if reqs[0].UnitId != 1 || reqs[0].FunctionCode != 0x03 ||
+		!bytes.Equal(reqs[0].Payload, []byte{0x10, 0x00, 0x00, 0x02}) {
+		t.Errorf("unexpected request: %+v", reqs[0])
+	}
+
+	// the handler should answer once the queue is empty
+	mt.Reset()
+	mt.Handle(func(req MockRequest) MockResponse {
+		return MockResponse{FunctionCode: req.FunctionCode, Payload: req.Payload}
+	})
+
+	client.SetUnitId(0x22)
+	err = client.WriteRegister(0x0002, 0xabcd)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	reqs = mt.Requests()
+	if len(reqs) != 1 || reqs[0].UnitId != 0x22 || reqs[0].FunctionCode != 0x06 ||
+		!bytes.Equal(reqs[0].Payload, []byte{0x00, 0x02, 0xab, 0xcd}) {
+		t.Errorf("unexpected requests: %+v", reqs)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Errorf("Close() should have succeeded, got: %v", err)
+	}
+}
+
+type testObserver struct {
+	functionCodes []uint8
+	errs          []error
+}
+
+func (to *testObserver) RequestCompleted(functionCode uint8, latency time.Duration, err error) {
+	to.functionCodes = append(to.functionCodes, functionCode)
+	to.errs = append(to.errs, err)
+}
+
+func TestClientObserver(t *testing.T) {
+	var mt *MockTransport
+	var client *ModbusClient
+	var to *testObserver
+	var modbusErr *ModbusError
+
+	mt = NewMockTransport()
+	client = NewMockClient(mt)
+	to = &testObserver{}
+	client.conf.Observer = to
+
+	mt.Queue(
+		MockResponse{FunctionCode: 0x03, Payload: []byte{0x02, 0x00, 0x01}},
+		MockException(0x01, 0x02),
+		MockResponse{Err: ErrProtocol},
+	)
+
+	client.ReadRegister(0x0000, HOLDING_REGISTER)
+	client.ReadCoil(0x0000)
+	client.WriteRegister(0x0000, 0x0001)
+	// unanswered requests should be reported as timeouts, including those
+	// made through handles
+	client.WithUnitId(5).ReadRegister(0x0000, INPUT_REGISTER)
+
+	if len(to.errs) != 4 {
+		t.Fatalf("expected 4 events, got: %v", len(to.errs))
+	}
+	if to.functionCodes[0] != 0x03 || to.errs[0] != nil {
+		t.Errorf("unexpected event: 0x%02x, %v", to.functionCodes[0], to.errs[0])
+	}
+	if to.functionCodes[1] != 0x01 || !errors.As(to.errs[1], &modbusErr) ||
+		modbusErr.ExceptionCode != 0x02 {
+		t.Errorf("unexpected event: 0x%02x, %v", to.functionCodes[1], to.errs[1])
+	}
+	if to.functionCodes[2] != 0x06 || to.errs[2] != ErrProtocol {
+		t.Errorf("unexpected event: 0x%02x, %v", to.functionCodes[2], to.errs[2])
+	}
+	if to.functionCodes[3] != 0x04 || to.errs[3] != ErrRequestTimedOut {
+		t.Errorf("unexpected event: 0x%02x, %v", to.functionCodes[3], to.errs[3])
+	}
+}
+
+func TestClientIsConnected(t *testing.T) {
+	var client *ModbusClient
+	var err error
+
+	client, err = NewClient(&ClientConfiguration{URL: "tcp://localhost:5508"})
+	if err != nil {
+		t.Fatalf("NewClient() should have succeeded, got: %v", err)
+	}
+
+	// requests made before Open() should fail, including through handles
+	if client.IsConnected() {
+		t.Errorf("IsConnected() should have returned false")
+	}
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrNotConnected {
+		t.Errorf("ReadRegister() should have returned ErrNotConnected, got: %v", err)
+	}
+	_, err = client.WithUnitId(2).WithUnitId(3).ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrNotConnected {
+		t.Errorf("ReadRegister() should have returned ErrNotConnected, got: %v", err)
+	}
+
+	// mock clients come connected, until closed
+	client = NewMockClient(NewMockTransport())
+	if !client.IsConnected() || !client.WithUnitId(2).IsConnected() {
+		t.Errorf("IsConnected() should have returned true")
+	}
+	_, err = client.WithUnitId(2).WithUnitId(3).ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrRequestTimedOut {
+		t.Errorf("ReadRegister() should have returned ErrRequestTimedOut, got: %v", err)
+	}
+
+	client.Close()
+	if client.IsConnected() {
+		t.Errorf("IsConnected() should have returned false")
+	}
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrNotConnected {
+		t.Errorf("ReadRegister() should have returned ErrNotConnected, got: %v", err)
+	}
+
+	// and can be reopened
+	err = client.Open()
+	if err != nil || !client.IsConnected() {
+		t.Errorf("Open() should have succeeded, got: %v", err)
+	}
+}
+
+func TestClientExecuteRaw(t *testing.T) {
+	var mt *MockTransport
+	var client *ModbusClient
+	var fc uint8
+	var payload []byte
+	var err error
+	var reqs []MockRequest
+
+	mt = NewMockTransport()
+	client = NewMockClient(mt)
+
+	mt.Queue(
+		MockResponse{FunctionCode: 0x65, Payload: []byte{0xde, 0xad, 0xbe, 0xef}},
+		MockException(0x65, 0x01),
+		MockResponse{FunctionCode: 0x66},
+	)
+
+	fc, payload, err = client.ExecuteRaw(0x07, 0x65, []byte{0x01, 0x02})
+	if err != nil {
+		t.Fatalf("ExecuteRaw() should have succeeded, got: %v", err)
+	}
+	if fc != 0x65 || !bytes.Equal(payload, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("unexpected response: 0x%02x, % x", fc, payload)
+	}
+
+	reqs = mt.Requests()
+	if len(reqs) != 1 || reqs[0].UnitId != 0x07 || reqs[0].FunctionCode != 0x65 ||
+		!bytes.Equal(reqs[0].Payload, []byte{0x01, 0x02}) {
+		t.Errorf("unexpected requests: %+v", reqs)
+	}
+
+	// exceptions should be surfaced as typed errors
+	fc, _, err = client.ExecuteRaw(0x07, 0x65, nil)
+	if !errors.Is(err, ErrIllegalFunction) || fc != 0xe5 {
+		t.Errorf("ExecuteRaw() should have returned ErrIllegalFunction, got: 0x%02x, %v", fc, err)
+	}
+
+	// as should responses to another function code
+	_, _, err = client.ExecuteRaw(0x07, 0x65, nil)
+	if err != ErrProtocol {
+		t.Errorf("ExecuteRaw() should have returned ErrProtocol, got: %v", err)
+	}
+
+	for _, fc = range []uint8{0x00, 0x80, 0xe5} {
+		_, _, err = client.ExecuteRaw(0x07, fc, nil)
+		if err != ErrUnexpectedParameters {
+			t.Errorf("ExecuteRaw(0x%02x) should have returned ErrUnexpectedParameters, got: %v", fc, err)
+		}
+	}
+
+	_, _, err = client.ExecuteRaw(0x07, 0x65, make([]byte, 253))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ExecuteRaw() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+}
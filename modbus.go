@@ -9,40 +9,85 @@ type pdu struct {
 	unitId       uint8
 	functionCode uint8
 	payload      []byte
+	// transaction id the frame was received with (MBAP framing only,
+	// informational)
+	txnId uint16
 }
 
+// Function codes.
 const (
 	// coils
-	fcReadCoils          uint8 = 0x01
-	fcWriteSingleCoil    uint8 = 0x05
-	fcWriteMultipleCoils uint8 = 0x0f
+	FC_READ_COILS           uint8 = 0x01
+	FC_WRITE_SINGLE_COIL    uint8 = 0x05
+	FC_WRITE_MULTIPLE_COILS uint8 = 0x0f
 
 	// discrete inputs
-	fcReadDiscreteInputs uint8 = 0x02
+	FC_READ_DISCRETE_INPUTS uint8 = 0x02
 
 	// 16-bit input/holding registers
-	fcReadHoldingRegisters   uint8 = 0x03
-	fcReadInputRegisters     uint8 = 0x04
-	fcWriteSingleRegister    uint8 = 0x06
-	fcWriteMultipleRegisters uint8 = 0x10
-	fcMaskWriteRegister      uint8 = 0x16
-	//fcReadWriteMultipleRegisters uint8 = 0x17
-	//fcReadFifoQueue              uint8 = 0x18
+	FC_READ_HOLDING_REGISTERS        uint8 = 0x03
+	FC_READ_INPUT_REGISTERS          uint8 = 0x04
+	FC_WRITE_SINGLE_REGISTER         uint8 = 0x06
+	FC_WRITE_MULTIPLE_REGISTERS      uint8 = 0x10
+	FC_MASK_WRITE_REGISTER           uint8 = 0x16
+	FC_READ_WRITE_MULTIPLE_REGISTERS uint8 = 0x17
+	FC_READ_FIFO_QUEUE               uint8 = 0x18
+
+	// diagnostics (serial line only)
+	FC_READ_EXCEPTION_STATUS  uint8 = 0x07
+	FC_DIAGNOSTICS            uint8 = 0x08
+	FC_GET_COMM_EVENT_COUNTER uint8 = 0x0b
+	FC_GET_COMM_EVENT_LOG     uint8 = 0x0c
+	FC_REPORT_SERVER_ID       uint8 = 0x11
+
+	// encapsulated interface transport
+	FC_ENCAPSULATED_INTERFACE uint8 = 0x2b
 
 	// file access
-	// fcReadFileRecord  uint8 = 0x14
-	// fcWriteFileRecord uint8 = 0x15
-
-	// exception codes
-	exIllegalFunction         uint8 = 0x01
-	exIllegalDataAddress      uint8 = 0x02
-	exIllegalDataValue        uint8 = 0x03
-	exServerDeviceFailure     uint8 = 0x04
-	exAcknowledge             uint8 = 0x05
-	exServerDeviceBusy        uint8 = 0x06
-	exMemoryParityError       uint8 = 0x08
-	exGWPathUnavailable       uint8 = 0x0a
-	exGWTargetFailedToRespond uint8 = 0x0b
+	FC_READ_FILE_RECORD  uint8 = 0x14
+	FC_WRITE_FILE_RECORD uint8 = 0x15
+)
+
+// Exception codes (see ModbusError).
+const (
+	EX_ILLEGAL_FUNCTION            uint8 = 0x01
+	EX_ILLEGAL_DATA_ADDRESS        uint8 = 0x02
+	EX_ILLEGAL_DATA_VALUE          uint8 = 0x03
+	EX_SERVER_DEVICE_FAILURE       uint8 = 0x04
+	EX_ACKNOWLEDGE                 uint8 = 0x05
+	EX_SERVER_DEVICE_BUSY          uint8 = 0x06
+	EX_MEMORY_PARITY_ERROR         uint8 = 0x08
+	EX_GW_PATH_UNAVAILABLE         uint8 = 0x0a
+	EX_GW_TARGET_FAILED_TO_RESPOND uint8 = 0x0b
+)
+
+const (
+	// read device identification MEI type
+	meiReadDeviceId uint8 = 0x0e
+
+	// reference type of file record sub-requests
+	fileRecordRefType uint8 = 0x06
+
+	// maximum number of coils/discrete inputs per read request
+	maxReadCoils = 2000
+	// maximum number of registers per read holding/input registers request
+	maxReadRegisters = 125
+	// maximum number of registers per write multiple registers request
+	maxWriteRegisters = 123
+	// maximum number of 32-bit Enron registers per read and write request
+	maxReadEnronRegisters  = 62
+	maxWriteEnronRegisters = 61
+	// maximum number of registers per read/write multiple registers request
+	maxReadWriteReadRegisters  = 125
+	maxReadWriteWriteRegisters = 121
+	// maximum number of registers per read FIFO queue response
+	maxFIFOCount = 31
+	// maximum values of the byte count fields of read/write file record
+	// requests and responses (keeping PDUs within 253 bytes)
+	maxReadFileRecordByteCount  = 0xf5
+	maxWriteFileRecordByteCount = 0xfb
+	// highest addressable record number
+	maxFileRecordNumber = 0x270f
 )
 
 var (
@@ -64,58 +109,191 @@ var (
 	ErrBadTransactionId        = errors.New("bad transaction id")
 	ErrUnknownProtocolId       = errors.New("unknown protocol identifier")
 	ErrUnexpectedParameters    = errors.New("unexpected parameters")
+	ErrNotConnected            = errors.New("not connected")
+	ErrTooManyMismatchedFrames = errors.New("too many mismatched frames")
+	ErrBusBusy                 = errors.New("bus busy")
+	ErrConnClosed              = errors.New("connection closed")
+	ErrVerifyFailed            = errors.New("write verification failed")
 )
 
-// mapExceptionCodeToError turns a modbus exception code into a higher level Error object.
-func mapExceptionCodeToError(exceptionCode uint8) (err error) {
-	switch exceptionCode {
-	case exIllegalFunction:
+// Error returned when an i/o deadline expires while waiting for (part of)
+// a frame. It satisfies the net.Error interface, with Timeout() returning
+// true, and wraps both ErrRequestTimedOut and the underlying i/o error.
+type timeoutError struct {
+	err error
+}
+
+func (te *timeoutError) Error() string {
+	return fmt.Sprintf("%v (%v)", ErrRequestTimedOut, te.err)
+}
+
+func (te *timeoutError) Timeout() bool {
+	return true
+}
+
+func (te *timeoutError) Temporary() bool {
+	return true
+}
+
+func (te *timeoutError) Unwrap() []error {
+	return []error{ErrRequestTimedOut, te.err}
+}
+
+// Connection losses (closed or reset by the peer), matching both
+// ErrConnClosed and the underlying error with errors.Is()/errors.As().
+type connClosedError struct {
+	err error
+}
+
+func (cce *connClosedError) Error() string {
+	return fmt.Sprintf("%v (%v)", ErrConnClosed, cce.err)
+}
+
+func (cce *connClosedError) Unwrap() []error {
+	return []error{ErrConnClosed, cce.err}
+}
+
+// ChunkError is returned by operations spanning multiple requests when one of
+// them fails, to let the caller know how far the operation went.
+type ChunkError struct {
+	// Addr is the start address of the request which failed
+	Addr uint16
+	// Done is the number of items successfully processed before the failure
+	Done int
+	// Err is the error returned by the failed request
+	Err error
+}
+
+func (ce *ChunkError) Error() string {
+	return fmt.Sprintf("request at address 0x%04x failed after %v items: %v",
+		ce.Addr, ce.Done, ce.Err)
+}
+
+func (ce *ChunkError) Unwrap() error {
+	return ce.Err
+}
+
+// ModbusError is returned when a remote device replies with an exception
+// response. It matches the sentinel error associated with its exception code
+// (e.g. ErrIllegalDataAddress for exception code 0x02) when passed to
+// errors.Is().
+type ModbusError struct {
+	// FunctionCode is the function code of the request which triggered
+	// the exception (without the exception bit set)
+	FunctionCode uint8
+	// ExceptionCode is the exception code returned by the device
+	ExceptionCode uint8
+}
+
+func (me *ModbusError) Error() string {
+	return me.sentinel().Error()
+}
+
+func (me *ModbusError) Is(target error) bool {
+	return me.sentinel() == target
+}
+
+// Returns the sentinel error associated with the exception code.
+func (me *ModbusError) sentinel() (err error) {
+	switch me.ExceptionCode {
+	case EX_ILLEGAL_FUNCTION:
 		err = ErrIllegalFunction
-	case exIllegalDataAddress:
+	case EX_ILLEGAL_DATA_ADDRESS:
 		err = ErrIllegalDataAddress
-	case exIllegalDataValue:
+	case EX_ILLEGAL_DATA_VALUE:
 		err = ErrIllegalDataValue
-	case exServerDeviceFailure:
+	case EX_SERVER_DEVICE_FAILURE:
 		err = ErrServerDeviceFailure
-	case exAcknowledge:
+	case EX_ACKNOWLEDGE:
 		err = ErrAcknowledge
-	case exMemoryParityError:
+	case EX_MEMORY_PARITY_ERROR:
 		err = ErrMemoryParityError
-	case exServerDeviceBusy:
+	case EX_SERVER_DEVICE_BUSY:
 		err = ErrServerDeviceBusy
-	case exGWPathUnavailable:
+	case EX_GW_PATH_UNAVAILABLE:
 		err = ErrGWPathUnavailable
-	case exGWTargetFailedToRespond:
+	case EX_GW_TARGET_FAILED_TO_RESPOND:
 		err = ErrGWTargetFailedToRespond
 	default:
-		err = fmt.Errorf("unknown exception code (%v)", exceptionCode)
+		err = fmt.Errorf("unknown exception code (%v)", me.ExceptionCode)
 	}
 	return
 }
 
+// Names of function codes, as found in the spec.
+var functionCodeNames = map[uint8]string{
+	FC_READ_COILS:                    "Read Coils",
+	FC_READ_DISCRETE_INPUTS:          "Read Discrete Inputs",
+	FC_READ_HOLDING_REGISTERS:        "Read Holding Registers",
+	FC_READ_INPUT_REGISTERS:          "Read Input Registers",
+	FC_WRITE_SINGLE_COIL:             "Write Single Coil",
+	FC_WRITE_SINGLE_REGISTER:         "Write Single Register",
+	FC_READ_EXCEPTION_STATUS:         "Read Exception Status",
+	FC_DIAGNOSTICS:                   "Diagnostics",
+	FC_GET_COMM_EVENT_COUNTER:        "Get Comm Event Counter",
+	FC_GET_COMM_EVENT_LOG:            "Get Comm Event Log",
+	FC_WRITE_MULTIPLE_COILS:          "Write Multiple Coils",
+	FC_WRITE_MULTIPLE_REGISTERS:      "Write Multiple Registers",
+	FC_REPORT_SERVER_ID:              "Report Server ID",
+	FC_READ_FILE_RECORD:              "Read File Record",
+	FC_WRITE_FILE_RECORD:             "Write File Record",
+	FC_MASK_WRITE_REGISTER:           "Mask Write Register",
+	FC_READ_WRITE_MULTIPLE_REGISTERS: "Read/Write Multiple Registers",
+	FC_READ_FIFO_QUEUE:               "Read FIFO Queue",
+	FC_ENCAPSULATED_INTERFACE:        "Encapsulated Interface Transport",
+}
+
+// Returns the name of a function code (e.g. "Read Holding Registers" for
+// FC_READ_HOLDING_REGISTERS), with an " exception" suffix if the exception
+// bit is set, or a hex representation of unknown function codes.
+func FunctionCodeName(functionCode uint8) string {
+	name, ok := functionCodeNames[functionCode&0x7f]
+	if !ok {
+		return fmt.Sprintf("function code 0x%02x", functionCode)
+	}
+
+	if functionCode&0x80 != 0 {
+		return name + " exception"
+	}
+
+	return name
+}
+
+// mapExceptionCodeToError turns a modbus exception code into a higher level Error object.
+func mapExceptionCodeToError(functionCode uint8, exceptionCode uint8) error {
+	return &ModbusError{
+		FunctionCode:  functionCode & 0x7f,
+		ExceptionCode: exceptionCode,
+	}
+}
+
 // mapErrorToExceptionCode turns an Error object into a modbus exception code.
 func mapErrorToExceptionCode(err error) (exceptionCode uint8) {
-	switch err {
-	case ErrIllegalFunction:
-		exceptionCode = exIllegalFunction
-	case ErrIllegalDataAddress:
-		exceptionCode = exIllegalDataAddress
-	case ErrIllegalDataValue:
-		exceptionCode = exIllegalDataValue
-	case ErrServerDeviceFailure:
-		exceptionCode = exServerDeviceFailure
-	case ErrAcknowledge:
-		exceptionCode = exAcknowledge
-	case ErrMemoryParityError:
-		exceptionCode = exMemoryParityError
-	case ErrServerDeviceBusy:
-		exceptionCode = exServerDeviceBusy
-	case ErrGWPathUnavailable:
-		exceptionCode = exGWPathUnavailable
-	case ErrGWTargetFailedToRespond:
-		exceptionCode = exGWTargetFailedToRespond
+	var me *ModbusError
+
+	switch {
+	case errors.As(err, &me):
+		exceptionCode = me.ExceptionCode
+	case errors.Is(err, ErrIllegalFunction):
+		exceptionCode = EX_ILLEGAL_FUNCTION
+	case errors.Is(err, ErrIllegalDataAddress):
+		exceptionCode = EX_ILLEGAL_DATA_ADDRESS
+	case errors.Is(err, ErrIllegalDataValue):
+		exceptionCode = EX_ILLEGAL_DATA_VALUE
+	case errors.Is(err, ErrServerDeviceFailure):
+		exceptionCode = EX_SERVER_DEVICE_FAILURE
+	case errors.Is(err, ErrAcknowledge):
+		exceptionCode = EX_ACKNOWLEDGE
+	case errors.Is(err, ErrMemoryParityError):
+		exceptionCode = EX_MEMORY_PARITY_ERROR
+	case errors.Is(err, ErrServerDeviceBusy):
+		exceptionCode = EX_SERVER_DEVICE_BUSY
+	case errors.Is(err, ErrGWPathUnavailable):
+		exceptionCode = EX_GW_PATH_UNAVAILABLE
+	case errors.Is(err, ErrGWTargetFailedToRespond):
+		exceptionCode = EX_GW_TARGET_FAILED_TO_RESPOND
 	default:
-		exceptionCode = exServerDeviceFailure
+		exceptionCode = EX_SERVER_DEVICE_FAILURE
 	}
 
 	return
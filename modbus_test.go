@@ -0,0 +1,24 @@
+package modbus
+
+import (
+	"testing"
+)
+
+func TestFunctionCodeName(t *testing.T) {
+	for _, tc := range []struct {
+		functionCode uint8
+		expected     string
+	}{
+		{FC_READ_HOLDING_REGISTERS, "Read Holding Registers"},
+		{FC_WRITE_MULTIPLE_COILS, "Write Multiple Coils"},
+		{FC_READ_WRITE_MULTIPLE_REGISTERS, "Read/Write Multiple Registers"},
+		{FC_READ_COILS | 0x80, "Read Coils exception"},
+		{0x64, "function code 0x64"},
+		{0xe4, "function code 0xe4"},
+	} {
+		if name := FunctionCodeName(tc.functionCode); name != tc.expected {
+			t.Errorf("FunctionCodeName(0x%02x): expected '%s', got '%s'",
+				tc.functionCode, tc.expected, name)
+		}
+	}
+}
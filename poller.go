@@ -0,0 +1,176 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Block of registers read periodically by a Poller.
+type PollDefinition struct {
+	UnitId   uint8
+	RegType  RegType
+	Addr     uint16
+	Quantity uint16
+	// time between two consecutive reads of the block
+	Interval time.Duration
+}
+
+// Outcome of a read made by a Poller, delivered on its results channel.
+type PollResult struct {
+	// index of the definition in the list passed to NewPoller()
+	Index      int
+	Definition PollDefinition
+	// register values, nil if the read failed
+	Values []uint16
+	// error returned by the read, if any
+	Err error
+	// time at which the read completed
+	Time time.Time
+}
+
+// Poller reads blocks of registers on a schedule over the connection of a
+// client, delivering values on a channel (see Results()).
+// Reads are made one at a time, from a single goroutine, each definition
+// being polled every Interval (reads running late are rescheduled rather
+// than run back to back to catch up). Failed reads are reported as results
+// with Err set and do not stop the poller, nor affect other definitions.
+type Poller struct {
+	client  *ModbusClient
+	defs    []PollDefinition
+	results chan PollResult
+	lock    sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Returns a new Poller reading defs through client, which is expected to be
+// opened (and eventually closed) by the caller.
+func NewPoller(client *ModbusClient, defs []PollDefinition) (*Poller, error) {
+	for _, def := range defs {
+		if def.Interval <= 0 || def.Quantity == 0 ||
+			(def.RegType != HOLDING_REGISTER && def.RegType != INPUT_REGISTER) {
+			client.logger.Errorf("invalid poll definition: %+v", def)
+			return nil, ErrUnexpectedParameters
+		}
+	}
+
+	return &Poller{
+		client:  client,
+		defs:    append([]PollDefinition(nil), defs...),
+		results: make(chan PollResult, len(defs)),
+	}, nil
+}
+
+// Returns the channel results are delivered on.
+// The channel should be drained continuously: reads are not made while a
+// result is waiting to be delivered.
+func (p *Poller) Results() <-chan PollResult {
+	return p.results
+}
+
+// Starts polling. All definitions are read once right away, then every
+// Interval. Has no effect if the poller is already running.
+func (p *Poller) Start() {
+	var ctx context.Context
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.cancel != nil {
+		return
+	}
+
+	ctx, p.cancel = context.WithCancel(context.Background())
+	p.done = make(chan struct{})
+
+	go p.run(ctx, p.done)
+}
+
+// Stops polling, aborting any in-flight read, and waits for the polling
+// goroutine to exit. The results channel is left open, the poller can be
+// started again.
+func (p *Poller) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.cancel == nil {
+		return
+	}
+
+	p.cancel()
+	<-p.done
+	p.cancel = nil
+}
+
+// Polls definitions as they come due, until ctx is done.
+func (p *Poller) run(ctx context.Context, done chan struct{}) {
+	var next []time.Time = make([]time.Time, len(p.defs))
+	var handles map[uint8]*ModbusClient = make(map[uint8]*ModbusClient)
+	var timer *time.Timer = time.NewTimer(0)
+
+	defer close(done)
+	defer timer.Stop()
+
+	// all definitions are due right away
+	now := time.Now()
+	for i := range next {
+		next[i] = now
+	}
+
+	if len(next) == 0 {
+		<-ctx.Done()
+		return
+	}
+
+	for {
+		// pick the definition due first
+		due := 0
+		for i := range next {
+			if next[i].Before(next[due]) {
+				due = i
+			}
+		}
+
+		timer.Reset(time.Until(next[due]))
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		def := p.defs[due]
+		handle, ok := handles[def.UnitId]
+		if !ok {
+			handle = p.client.WithUnitId(def.UnitId).WithContext(ctx)
+			handles[def.UnitId] = handle
+		}
+
+		values, err := handle.ReadRegisters(def.Addr, def.Quantity, def.RegType)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.client.logger.Warningf("failed to poll %v registers at 0x%04x "+
+				"from unit id %v: %v", def.Quantity, def.Addr, def.UnitId, err)
+		}
+
+		// schedule the next read, skipping missed intervals
+		next[due] = next[due].Add(def.Interval)
+		if now = time.Now(); next[due].Before(now) {
+			next[due] = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case p.results <- PollResult{
+			Index:      due,
+			Definition: def,
+			Values:     values,
+			Err:        err,
+			Time:       now,
+		}:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPoller(t *testing.T) {
+	var mt *MockTransport = NewMockTransport()
+	var client *ModbusClient = NewMockClient(mt)
+	var poller *Poller
+	var err error
+	var counts [2]int
+	var failures int
+
+	mt.Handle(func(req MockRequest) MockResponse {
+		// unit #2 is offline
+		if req.UnitId == 2 {
+			return MockException(req.FunctionCode, 0x0b)
+		}
+		return MockResponse{
+			FunctionCode: req.FunctionCode,
+			Payload:      []byte{0x02, 0x00, req.Payload[1]},
+		}
+	})
+
+	_, err = NewPoller(client, []PollDefinition{
+		{UnitId: 1, RegType: HOLDING_REGISTER, Addr: 0, Quantity: 1},
+	})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("NewPoller() should have returned ErrUnexpectedParameters, got %v", err)
+	}
+
+	poller, err = NewPoller(client, []PollDefinition{
+		{UnitId: 1, RegType: HOLDING_REGISTER, Addr: 0x10, Quantity: 1,
+			Interval: 20 * time.Millisecond},
+		{UnitId: 2, RegType: INPUT_REGISTER, Addr: 0x20, Quantity: 1,
+			Interval: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewPoller() should have succeeded, got %v", err)
+	}
+
+	poller.Start()
+	timeout := time.After(230 * time.Millisecond)
+
+collect:
+	for {
+		select {
+		case res := <-poller.Results():
+			counts[res.Index]++
+			switch res.Index {
+			case 0:
+				if res.Err != nil || len(res.Values) != 1 || res.Values[0] != 0x10 {
+					t.Errorf("unexpected result: %+v", res)
+				}
+			case 1:
+				if !errors.Is(res.Err, ErrGWTargetFailedToRespond) {
+					t.Errorf("unexpected result: %+v", res)
+				}
+				failures++
+			}
+		case <-timeout:
+			break collect
+		}
+	}
+	poller.Stop()
+
+	// the failing definition should not prevent others from being polled,
+	// and should keep being polled itself
+	if counts[0] < 8 || counts[0] > 13 {
+		t.Errorf("expected ~12 results for definition #0, got %v", counts[0])
+	}
+	if failures < 3 || failures > 6 {
+		t.Errorf("expected ~5 results for definition #1, got %v", failures)
+	}
+
+	// requests should carry the unit id and function code of their definition
+	for _, req := range mt.Requests() {
+		if (req.UnitId == 1 && req.FunctionCode != 0x03) ||
+			(req.UnitId == 2 && req.FunctionCode != 0x04) {
+			t.Errorf("unexpected request: %+v", req)
+		}
+	}
+
+	// no more reads should be made once stopped
+	count := len(mt.Requests())
+	time.Sleep(50 * time.Millisecond)
+	if len(mt.Requests()) != count {
+		t.Errorf("poller should have stopped")
+	}
+}
@@ -0,0 +1,149 @@
+package modbus
+
+import (
+	"io"
+	"slices"
+)
+
+// RegisterReader is an io.Reader over a window of 16-bit registers, e.g.
+// to pull a file or firmware image out of a device.
+// Registers are read in order, as many at a time as fit in a single request
+// (function code 03 or 04), and unpacked as with ReadBytes(). Read() returns
+// io.EOF once the end of the window is reached.
+type RegisterReader struct {
+	client    *ModbusClient
+	regType   RegType
+	addr      uint16
+	remaining uint32
+	buf       []byte
+}
+
+// Returns a reader over the quantity registers of type regType starting at
+// addr, read through client.
+// Requests are addressed to the unit id of the client at the time they are
+// made: use WithUnitId() to pin it.
+func NewRegisterReader(client *ModbusClient, addr uint16, quantity uint16, regType RegType) (*RegisterReader, error) {
+	if quantity == 0 || uint32(addr)+uint32(quantity)-1 > 0xffff {
+		client.logger.Errorf("invalid register window (addr: %v, quantity: %v)", addr, quantity)
+		return nil, ErrUnexpectedParameters
+	}
+
+	return &RegisterReader{
+		client:    client,
+		regType:   regType,
+		addr:      addr,
+		remaining: uint32(quantity),
+	}, nil
+}
+
+// Reads up to len(p) bytes from the register window.
+func (rr *RegisterReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+
+	if len(rr.buf) == 0 {
+		if rr.remaining == 0 {
+			return 0, io.EOF
+		}
+
+		count := min(rr.remaining, uint32(rr.client.maxReadRegisters()))
+		rr.buf, err = rr.client.ReadBytes(rr.addr, uint16(2*count), rr.regType)
+		if err != nil {
+			return 0, err
+		}
+
+		rr.addr += uint16(count)
+		rr.remaining -= count
+	}
+
+	n = copy(p, rr.buf)
+	rr.buf = rr.buf[n:]
+
+	return
+}
+
+// RegisterWriter is an io.WriteCloser over a window of 16-bit registers,
+// e.g. to push a file or firmware image into a device's mailbox.
+// Written bytes are packed into registers as with WriteBytes() and sent in
+// order (function code 16) as soon as enough of them are buffered to fill a
+// request. Close() sends whatever is left, padding an odd trailing byte with
+// a null byte.
+type RegisterWriter struct {
+	client    *ModbusClient
+	addr      uint16
+	remaining uint32
+	buf       []byte
+}
+
+// Returns a writer over the quantity holding registers starting at addr,
+// written through client.
+// Requests are addressed to the unit id of the client at the time they are
+// made: use WithUnitId() to pin it.
+func NewRegisterWriter(client *ModbusClient, addr uint16, quantity uint16) (*RegisterWriter, error) {
+	if quantity == 0 || uint32(addr)+uint32(quantity)-1 > 0xffff {
+		client.logger.Errorf("invalid register window (addr: %v, quantity: %v)", addr, quantity)
+		return nil, ErrUnexpectedParameters
+	}
+
+	return &RegisterWriter{
+		client:    client,
+		addr:      addr,
+		remaining: uint32(quantity),
+	}, nil
+}
+
+// Buffers p, sending full requests as they become available.
+// Bytes past the end of the window are not accepted and io.ErrShortWrite is
+// returned. Should a request fail, its bytes remain buffered and are sent
+// again by the next call to Write() or Close().
+func (rw *RegisterWriter) Write(p []byte) (n int, err error) {
+	n = min(len(p), 2*int(rw.remaining)-len(rw.buf))
+	rw.buf = append(rw.buf, p[:n]...)
+
+	chunk := uint32(rw.client.maxWriteRegisters())
+	for uint32(len(rw.buf)) >= 2*chunk {
+		err = rw.flush(chunk)
+		if err != nil {
+			return
+		}
+	}
+
+	if n < len(p) {
+		err = io.ErrShortWrite
+	}
+
+	return
+}
+
+// Sends buffered bytes, if any, in as many requests as needed (more than a
+// request worth of bytes may remain buffered after a failed Write()).
+func (rw *RegisterWriter) Close() (err error) {
+	chunk := uint32(rw.client.maxWriteRegisters())
+	for len(rw.buf) > 0 {
+		err = rw.flush(min(uint32(len(rw.buf)+1)/2, chunk))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Sends the first count registers worth of buffered bytes (possibly ending
+// with a single, odd byte).
+func (rw *RegisterWriter) flush(count uint32) (err error) {
+	size := min(2*int(count), len(rw.buf))
+
+	// writeBytes() pads and swaps bytes in place
+	err = rw.client.WriteBytes(rw.addr, slices.Clone(rw.buf[:size]))
+	if err != nil {
+		return
+	}
+
+	rw.buf = rw.buf[size:]
+	rw.addr += uint16(count)
+	rw.remaining -= count
+
+	return
+}
@@ -0,0 +1,139 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestRegisterReaderWriter(t *testing.T) {
+	var client *ModbusClient
+	var rr *RegisterReader
+	var rw *RegisterWriter
+	var err error
+	var n int64
+	var regs [0x10000]uint16
+	var chunks [][2]uint16
+	var data []byte = make([]byte, 301)
+	var out []byte
+	var fail bool
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		chunks = append(chunks, [2]uint16{addr, qty})
+		if fail {
+			return nil, ErrRequestTimedOut
+		}
+
+		res := &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+		switch req.functionCode {
+		case FC_WRITE_MULTIPLE_REGISTERS:
+			copy(regs[addr:addr+qty], bytesToUint16s(BIG_ENDIAN, req.payload[5:]))
+			res.payload = req.payload[0:4]
+		case FC_READ_HOLDING_REGISTERS:
+			res.payload = append([]byte{uint8(2 * qty)},
+				uint16sToBytes(BIG_ENDIAN, regs[addr:addr+qty])...)
+		default:
+			t.Errorf("unexpected function code 0x%02x", req.functionCode)
+		}
+		return res, nil
+	})
+
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// bytes should be sent in full requests, the rest on close
+	rw, err = NewRegisterWriter(client, 0x1000, 200)
+	if err != nil {
+		t.Fatalf("NewRegisterWriter() should have succeeded, got: %v", err)
+	}
+	n, err = io.Copy(rw, bytes.NewReader(data))
+	if err != nil || n != 301 {
+		t.Fatalf("io.Copy() should have succeeded, got: %v, %v", n, err)
+	}
+	if len(chunks) != 1 || chunks[0] != [2]uint16{0x1000, 123} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	err = rw.Close()
+	if err != nil {
+		t.Fatalf("Close() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 2 || chunks[1] != [2]uint16{0x107b, 28} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+	if regs[0x1000] != 0x0001 || regs[0x1096] != 0x2c00 {
+		t.Errorf("unexpected registers: 0x%04x, 0x%04x", regs[0x1000], regs[0x1096])
+	}
+
+	// the window should be read back in as few requests as possible
+	chunks = nil
+	rr, err = NewRegisterReader(client, 0x1000, 151, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("NewRegisterReader() should have succeeded, got: %v", err)
+	}
+	out, err = io.ReadAll(rr)
+	if err != nil {
+		t.Fatalf("io.ReadAll() should have succeeded, got: %v", err)
+	}
+	if !bytes.Equal(out, append(data, 0x00)) {
+		t.Errorf("unexpected data: %v", out)
+	}
+	if len(chunks) != 2 || chunks[0] != [2]uint16{0x1000, 125} ||
+		chunks[1] != [2]uint16{0x107d, 26} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// writes past the end of the window should be refused
+	chunks = nil
+	rw, _ = NewRegisterWriter(client, 0x2000, 2)
+	_, err = rw.Write([]byte{0x01, 0x02, 0x03})
+	if err != nil {
+		t.Errorf("Write() should have succeeded, got: %v", err)
+	}
+	n2, err := rw.Write([]byte{0x04, 0x05})
+	if err != io.ErrShortWrite || n2 != 1 {
+		t.Errorf("expected 1 byte and %v, got: %v, %v", io.ErrShortWrite, n2, err)
+	}
+	err = rw.Close()
+	if err != nil || len(chunks) != 1 || regs[0x2000] != 0x0102 || regs[0x2001] != 0x0304 {
+		t.Errorf("unexpected close outcome: %v, %v", err, chunks)
+	}
+
+	// bytes left buffered by a failed request should be sent on close, in
+	// as many requests as needed
+	chunks = nil
+	fail = true
+	rw, _ = NewRegisterWriter(client, 0x3000, 200)
+	n2, err = rw.Write(data)
+	if err != ErrRequestTimedOut || n2 != 301 {
+		t.Errorf("expected 301 bytes and %v, got: %v, %v", ErrRequestTimedOut, n2, err)
+	}
+	fail = false
+	err = rw.Close()
+	if err != nil {
+		t.Fatalf("Close() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 3 || chunks[1] != [2]uint16{0x3000, 123} ||
+		chunks[2] != [2]uint16{0x307b, 28} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+	if regs[0x3000] != 0x0001 || regs[0x3096] != 0x2c00 {
+		t.Errorf("unexpected registers: 0x%04x, 0x%04x", regs[0x3000], regs[0x3096])
+	}
+
+	// invalid windows should be rejected
+	_, err = NewRegisterReader(client, 0xffff, 2, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+	}
+	_, err = NewRegisterWriter(client, 0x0000, 0)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected %v, got: %v", ErrUnexpectedParameters, err)
+	}
+}
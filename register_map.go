@@ -0,0 +1,188 @@
+package modbus
+
+import (
+	"slices"
+)
+
+type DataType uint
+
+const (
+	// data types of register map points, decoded using the byte and word
+	// order of the client (see SetEncoding())
+	TYPE_UINT16  DataType = 1
+	TYPE_INT16   DataType = 2
+	TYPE_UINT32  DataType = 3
+	TYPE_INT32   DataType = 4
+	TYPE_FLOAT32 DataType = 5
+	TYPE_UINT64  DataType = 6
+	TYPE_INT64   DataType = 7
+	TYPE_FLOAT64 DataType = 8
+)
+
+// Returns the number of 16-bit registers a value of type dt spans, or 0 if
+// dt is unknown.
+func (dt DataType) registers() uint16 {
+	switch dt {
+	case TYPE_UINT16, TYPE_INT16:
+		return 1
+	case TYPE_UINT32, TYPE_INT32, TYPE_FLOAT32:
+		return 2
+	case TYPE_UINT64, TYPE_INT64, TYPE_FLOAT64:
+		return 4
+	}
+
+	return 0
+}
+
+// Named value stored in one or more registers.
+type registerPoint struct {
+	name  string
+	addr  uint16
+	typ   DataType
+	scale float64
+}
+
+// RegisterMap reads a set of named points (values spanning one or more
+// registers) through a client, grouping them in as few requests as possible.
+type RegisterMap struct {
+	// maximum number of unused registers read between two points to merge
+	// them into the same request (defaults to 0, i.e. only adjacent or
+	// overlapping points are merged)
+	MaxGap uint16
+
+	client  *ModbusClient
+	regType RegType
+	points  []registerPoint
+}
+
+// Returns an empty register map reading registers of type regType through
+// client, which is expected to be opened (and eventually closed) by the
+// caller.
+func NewRegisterMap(client *ModbusClient, regType RegType) *RegisterMap {
+	return &RegisterMap{
+		client:  client,
+		regType: regType,
+	}
+}
+
+// Adds a point named name, holding a value of type typ stored at addr.
+// Values are multiplied by scale when read, a scale of 0 being treated as 1.
+func (rm *RegisterMap) AddPoint(name string, addr uint16, typ DataType, scale float64) error {
+	if typ.registers() == 0 {
+		rm.client.logger.Errorf("unknown data type %v for point '%s'", typ, name)
+		return ErrUnexpectedParameters
+	}
+
+	if uint32(addr)+uint32(typ.registers())-1 > 0xffff {
+		rm.client.logger.Errorf("point '%s' ends past register 0xffff", name)
+		return ErrUnexpectedParameters
+	}
+
+	for _, p := range rm.points {
+		if p.name == name {
+			rm.client.logger.Errorf("duplicate point '%s'", name)
+			return ErrUnexpectedParameters
+		}
+	}
+
+	if scale == 0 {
+		scale = 1
+	}
+
+	rm.points = append(rm.points, registerPoint{
+		name:  name,
+		addr:  addr,
+		typ:   typ,
+		scale: scale,
+	})
+
+	return nil
+}
+
+// Reads all points and returns their scaled values keyed by name.
+// Points are sorted by address and merged into windows of consecutive
+// registers (see MaxGap) fitting in a single request, all addressed to the
+// same unit id. Should one of them fail, no further requests are sent and a
+// *ChunkError reporting the start address of the failed window and how many
+// points were read is returned.
+func (rm *RegisterMap) ReadAll() (values map[string]float64, err error) {
+	var mbPayload []byte
+	var start, end uint32
+	var points []registerPoint
+
+	if len(rm.points) == 0 {
+		rm.client.logger.Error("no points in register map")
+		return nil, ErrUnexpectedParameters
+	}
+
+	points = slices.Clone(rm.points)
+	slices.SortStableFunc(points, func(a, b registerPoint) int {
+		return int(a.addr) - int(b.addr)
+	})
+
+	// keep the unit id consistent across windows
+	rm.client.lock.Lock()
+	unitId := rm.client.unitId
+	rm.client.lock.Unlock()
+
+	maxQuantity := uint32(rm.client.maxReadRegisters())
+	values = make(map[string]float64, len(points))
+	for i := 0; i < len(points); {
+		// grow the window as long as the next point starts close enough
+		// and the window still fits in a single request
+		first := i
+		start = uint32(points[i].addr)
+		end = start + uint32(points[i].typ.registers()) - 1
+		for i++; i < len(points); i++ {
+			pointEnd := uint32(points[i].addr) + uint32(points[i].typ.registers()) - 1
+			if uint32(points[i].addr) > end+1+uint32(rm.MaxGap) ||
+				max(end, pointEnd)-start+1 > maxQuantity {
+				break
+			}
+			end = max(end, pointEnd)
+		}
+
+		mbPayload, err = rm.client.readUnitRegisters(
+			unitId, uint16(start), uint16(end-start+1), rm.regType)
+		if err != nil {
+			return nil, &ChunkError{
+				Addr: uint16(start),
+				Done: len(values),
+				Err:  err,
+			}
+		}
+
+		for _, p := range points[first:i] {
+			offset := 2 * (uint32(p.addr) - start)
+			values[p.name] = rm.client.decodePoint(
+				p.typ, mbPayload[offset:offset+2*uint32(p.typ.registers())]) * p.scale
+		}
+	}
+
+	return
+}
+
+// Decodes the registers of a value of type typ, using the encoding of the
+// client.
+func (mc *ModbusClient) decodePoint(typ DataType, in []byte) float64 {
+	switch typ {
+	case TYPE_UINT16:
+		return float64(bytesToUint16(mc.endianness, in))
+	case TYPE_INT16:
+		return float64(int16(bytesToUint16(mc.endianness, in)))
+	case TYPE_UINT32:
+		return float64(bytesToUint32s(mc.endianness, mc.wordOrder, in)[0])
+	case TYPE_INT32:
+		return float64(int32(bytesToUint32s(mc.endianness, mc.wordOrder, in)[0]))
+	case TYPE_FLOAT32:
+		return float64(bytesToFloat32s(mc.endianness, mc.wordOrder, in)[0])
+	case TYPE_UINT64:
+		return float64(bytesToUint64s(mc.endianness, mc.wordOrder, in)[0])
+	case TYPE_INT64:
+		return float64(int64(bytesToUint64s(mc.endianness, mc.wordOrder, in)[0]))
+	case TYPE_FLOAT64:
+		return bytesToFloat64s(mc.endianness, mc.wordOrder, in)[0]
+	}
+
+	return 0
+}
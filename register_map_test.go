@@ -0,0 +1,127 @@
+package modbus
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRegisterMapReadAll(t *testing.T) {
+	var client *ModbusClient
+	var rm *RegisterMap
+	var err error
+	var regs [0x10000]uint16
+	var chunks [][2]uint16
+	var values map[string]float64
+	var chunkErr *ChunkError
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		qty := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		chunks = append(chunks, [2]uint16{addr, qty})
+
+		if req.functionCode != FC_READ_INPUT_REGISTERS {
+			t.Errorf("unexpected function code 0x%02x", req.functionCode)
+		}
+		if addr >= 0x2000 {
+			return &pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode | 0x80,
+				payload:      []byte{0x02},
+			}, nil
+		}
+
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload: append([]byte{uint8(2 * qty)},
+				uint16sToBytes(BIG_ENDIAN, regs[addr:addr+qty])...),
+		}, nil
+	})
+
+	regs[100] = 2305                          // 230.5 V
+	regs[101] = 0xfff6                        // -10
+	regs[102], regs[103] = 0x4048, 0xf5c3     // 3.14
+	regs[110], regs[111] = 0x0001, 0x0002     // 65538
+	copy(regs[300:304], []uint16{0, 0, 0, 7}) // 7
+
+	rm = NewRegisterMap(client, INPUT_REGISTER)
+	for _, p := range []struct {
+		name  string
+		addr  uint16
+		typ   DataType
+		scale float64
+	}{
+		{"voltage", 100, TYPE_UINT16, 0.1},
+		{"offset", 101, TYPE_INT16, 0},
+		{"temperature", 102, TYPE_FLOAT32, 1},
+		{"energy", 110, TYPE_UINT32, 1},
+		{"counter", 300, TYPE_UINT64, 2},
+	} {
+		err = rm.AddPoint(p.name, p.addr, p.typ, p.scale)
+		if err != nil {
+			t.Fatalf("AddPoint() should have succeeded, got: %v", err)
+		}
+	}
+
+	// invalid points should be rejected
+	if rm.AddPoint("voltage", 0, TYPE_UINT16, 1) != ErrUnexpectedParameters {
+		t.Error("AddPoint() should have rejected a duplicate name")
+	}
+	if rm.AddPoint("bogus", 0, DataType(42), 1) != ErrUnexpectedParameters {
+		t.Error("AddPoint() should have rejected an unknown type")
+	}
+	if rm.AddPoint("bogus", 0xfffe, TYPE_FLOAT64, 1) != ErrUnexpectedParameters {
+		t.Error("AddPoint() should have rejected a point ending past 0xffff")
+	}
+
+	// adjacent points should be merged, others read separately
+	values, err = rm.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 3 || chunks[0] != [2]uint16{100, 4} ||
+		chunks[1] != [2]uint16{110, 2} || chunks[2] != [2]uint16{300, 4} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+	if len(values) != 5 ||
+		math.Abs(values["voltage"]-230.5) > 1e-9 || values["offset"] != -10 ||
+		math.Abs(values["temperature"]-3.14) > 1e-6 ||
+		values["energy"] != 65538 || values["counter"] != 14 {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	// gaps of up to MaxGap registers should be read through
+	chunks = nil
+	rm.MaxGap = 6
+	_, err = rm.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() should have succeeded, got: %v", err)
+	}
+	if len(chunks) != 2 || chunks[0] != [2]uint16{100, 12} ||
+		chunks[1] != [2]uint16{300, 4} {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	// values should be decoded using the client encoding
+	client.SetEncoding(BIG_ENDIAN, LOW_WORD_FIRST)
+	values, err = rm.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() should have succeeded, got: %v", err)
+	}
+	if values["energy"] != 0x00020001 {
+		t.Errorf("unexpected energy value: %v", values["energy"])
+	}
+
+	// failures should stop the read and report how far it went
+	chunks = nil
+	rm.AddPoint("unmapped", 0x2000, TYPE_UINT16, 1)
+	_, err = rm.ReadAll()
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("ReadAll() should have returned a ChunkError, got: %v", err)
+	}
+	if chunkErr.Addr != 0x2000 || chunkErr.Done != 5 ||
+		!errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("unexpected chunk error: %v", chunkErr)
+	}
+}
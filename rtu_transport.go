@@ -4,7 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
 	"time"
 )
 
@@ -13,12 +13,19 @@ const (
 )
 
 type rtuTransport struct {
+	timeouts
 	logger       *logger
 	link         rtuLink
-	timeout      time.Duration
 	lastActivity time.Time
 	t35          time.Duration
 	t1           time.Duration
+	// minimum delay between the end of a response and the next request
+	interRequestDelay time.Duration
+	// if set, maximum time to wait for the line to go silent before
+	// transmitting
+	busIdleWindow time.Duration
+	// if set, the line is drained until silent after corrupted frames
+	resyncOnError bool
 }
 
 type rtuLink interface {
@@ -29,12 +36,12 @@ type rtuLink interface {
 }
 
 // Returns a new RTU transport.
-func newRTUTransport(link rtuLink, addr string, speed uint, timeout time.Duration, customLogger *log.Logger) *rtuTransport {
+func newRTUTransport(link rtuLink, addr string, speed uint, timeout time.Duration, parentLogger *logger) *rtuTransport {
 	rt := rtuTransport{
-		logger:  newLogger(fmt.Sprintf("rtu-transport(%s)", addr), customLogger),
-		link:    link,
-		timeout: timeout,
-		t1:      serialCharTime(speed),
+		timeouts: timeouts{timeout: timeout},
+		logger:   parentLogger.derive(fmt.Sprintf("rtu-transport(%s)", addr)),
+		link:     link,
+		t1:       serialCharTime(speed),
 	}
 	if speed >= 19200 {
 		// for baud rates equal to or greater than 19200 bauds, a fixed value of
@@ -58,18 +65,32 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (*pdu, error) {
 	var t time.Duration
 
 	// set an i/o deadline on the link
-	err := rt.link.SetDeadline(time.Now().Add(rt.timeout))
+	err := rt.link.SetDeadline(time.Now().Add(rt.timeoutFor(req.unitId)))
 	if err != nil {
 		return nil, err
 	}
 
-	// if the line was active less than 3.5 char times ago,
-	// let t3.5 expire before transmitting
-	t = time.Since(rt.lastActivity.Add(rt.t35))
+	// if the line was active less than 3.5 char times (or the inter-request
+	// delay, whichever is longer) ago, let it expire before transmitting
+	t = time.Since(rt.lastActivity.Add(max(rt.t35, rt.interRequestDelay)))
 	if t < 0 {
 		time.Sleep(t * (-1))
 	}
 
+	// make sure no other master is transmitting
+	if rt.busIdleWindow > 0 {
+		err = rt.waitForSilence(rt.busIdleWindow)
+		if err != nil {
+			return nil, err
+		}
+
+		// restore the i/o deadline
+		err = rt.link.SetDeadline(time.Now().Add(rt.timeoutFor(req.unitId)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	ts = time.Now()
 
 	// build an RTU ADU out of the request object and
@@ -84,6 +105,11 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (*pdu, error) {
 	// immediately rather than block until the buffer is drained
 	rt.lastActivity = ts.Add(time.Duration(n) * rt.t1)
 
+	// broadcast requests get no response
+	if isBroadcast(req) {
+		return nil, nil
+	}
+
 	// observe inter-frame delays
 	time.Sleep(rt.lastActivity.Add(rt.t35).Sub(time.Now()))
 
@@ -91,10 +117,7 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (*pdu, error) {
 	res, err := rt.readRTUFrame()
 
 	if errors.Is(err, ErrBadCRC) || errors.Is(err, ErrProtocol) || errors.Is(err, ErrShortFrame) {
-		// wait for and flush any data coming off the link to allow
-		// devices to re-sync
-		time.Sleep(time.Duration(maxRTUFrameLength) * rt.t1)
-		discard(rt.link)
+		rt.resync()
 	}
 
 	// mark the time if we heard anything back
@@ -102,17 +125,100 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (*pdu, error) {
 		rt.lastActivity = time.Now()
 	}
 
-	return res, nil
+	// make sure the response comes from the device the request was sent to
+	// (another master or line noise may be at play on multi-drop links)
+	if err == nil && res.unitId != req.unitId {
+		rt.logger.Warningw(fmt.Sprintf("received unexpected unit id "+
+			"(expected 0x%02x, received 0x%02x)",
+			req.unitId, res.unitId), map[string]any{
+			"expected_unit_id": req.unitId,
+			"received_unit_id": res.unitId,
+		})
+		return nil, ErrBadUnitId
+	}
+
+	return res, err
+}
+
+// Flushes any data coming off the link after a corrupted frame, to allow
+// devices to re-sync: either until the line has been silent for 3.5
+// character times (if resyncOnError is set, giving up after the default
+// timeout) or for as long as the longest frame takes to transmit.
+func (rt *rtuTransport) resync() {
+	if rt.resyncOnError {
+		err := rt.waitForSilence(rt.defaultTimeout())
+		if err != nil {
+			rt.logger.Warningf("failed to resync: %v", err)
+		}
+		return
+	}
+
+	time.Sleep(time.Duration(maxRTUFrameLength) * rt.t1)
+	discard(rt.link)
+}
+
+// Listens to the link until it has been silent for 3.5 character times,
+// discarding any data heard in the meantime.
+// Returns ErrBusBusy if the link doesn't go silent within window.
+func (rt *rtuTransport) waitForSilence(window time.Duration) error {
+	var rxbuf []byte = make([]byte, maxRTUFrameLength)
+	var deadline time.Time = time.Now().Add(window)
+	var quietUntil time.Time = time.Now().Add(rt.t35)
+
+	for time.Now().Before(quietUntil) {
+		if quietUntil.After(deadline) {
+			rt.logger.Warningf("line still busy after %v, giving up", window)
+			return ErrBusBusy
+		}
+
+		err := rt.link.SetDeadline(quietUntil)
+		if err != nil {
+			return err
+		}
+
+		n, err := rt.link.Read(rxbuf)
+		if n > 0 {
+			// traffic on the line: start over
+			quietUntil = time.Now().Add(rt.t35)
+		}
+		if err != nil && !errors.Is(err, ErrRequestTimedOut) && !os.IsTimeout(err) {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Reads a request from the rtu link.
 func (rt *rtuTransport) ReadRequest() (*pdu, error) {
-	// reading requests from RTU links is currently unsupported
-	return nil, errors.New("unimplemented")
+	// set an i/o deadline on the link
+	err := rt.link.SetDeadline(time.Now().Add(rt.defaultTimeout()))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := rt.readRTURequest()
+	if errors.Is(err, ErrBadCRC) || errors.Is(err, ErrProtocol) || errors.Is(err, ErrShortFrame) {
+		rt.resync()
+	}
+
+	// mark the time if we heard anything
+	if !errors.Is(err, ErrRequestTimedOut) {
+		rt.lastActivity = time.Now()
+	}
+
+	return req, err
 }
 
 // Writes a response to the rtu link.
 func (rt *rtuTransport) WriteResponse(res *pdu) error {
+	// refresh the i/o deadline, which may have expired while the request
+	// was being handled
+	err := rt.link.SetDeadline(time.Now().Add(rt.defaultTimeout()))
+	if err != nil {
+		return err
+	}
+
 	// build an RTU ADU out of the request object and
 	// send the final ADU+CRC on the wire
 	n, err := rt.link.Write(rt.assembleRTUFrame(res))
@@ -127,8 +233,6 @@ func (rt *rtuTransport) WriteResponse(res *pdu) error {
 func (rt *rtuTransport) readRTUFrame() (*pdu, error) {
 	var rxbuf []byte
 	var byteCount int
-	//var bytesNeeded int
-	var crc crc
 
 	rxbuf = make([]byte, maxRTUFrameLength)
 
@@ -142,6 +246,16 @@ func (rt *rtuTransport) readRTUFrame() (*pdu, error) {
 		return nil, err
 	}
 
+	// read device identification responses carry no byte count
+	if rxbuf[1] == FC_ENCAPSULATED_INTERFACE {
+		return rt.readDeviceIdFrame(rxbuf)
+	}
+
+	// read FIFO queue responses carry a 2-byte byte count
+	if rxbuf[1] == FC_READ_FIFO_QUEUE {
+		return rt.readFIFOFrame(rxbuf)
+	}
+
 	// figure out how many further bytes to read
 	bytesNeeded, err := expectedResponseLenth(uint8(rxbuf[1]), uint8(rxbuf[2]))
 	if err != nil {
@@ -165,65 +279,267 @@ func (rt *rtuTransport) readRTUFrame() (*pdu, error) {
 		return nil, ErrShortFrame
 	}
 
-	// compute the CRC on the entire frame, excluding the CRC
-	crc.init()
-	crc.add(rxbuf[0 : 3+bytesNeeded-2])
+	return decodeRTUFrame(rxbuf[0 : 3+bytesNeeded])
+}
 
-	// compare CRC values
-	if !crc.isEqual(rxbuf[3+bytesNeeded-2], rxbuf[3+bytesNeeded-1]) {
-		return nil, ErrBadCRC
+// Reads and decodes the remainder of a read device identification response,
+// whose length can only be figured out by walking the list of objects.
+// rxbuf is expected to hold the unit id, function code and MEI type.
+func (rt *rtuTransport) readDeviceIdFrame(rxbuf []byte) (*pdu, error) {
+	var frameLength int = 3
+
+	read := func(count int) error {
+		err := rt.readFrameBytes(rxbuf, frameLength, count)
+		frameLength += count
+
+		return err
 	}
 
-	return &pdu{
-		unitId:       rxbuf[0],
-		functionCode: rxbuf[1],
-		// pass the byte count + trailing data as payload, withtout the CRC
-		payload: rxbuf[2 : 3+bytesNeeded-2],
-	}, nil
+	if rxbuf[2] != meiReadDeviceId {
+		return nil, ErrProtocol
+	}
+
+	// read device id code, conformity level, more follows, next object id
+	// and number of objects
+	err := read(5)
+	if err != nil {
+		return nil, err
+	}
+
+	for objectCount := int(rxbuf[frameLength-1]); objectCount > 0; objectCount-- {
+		// object id and length
+		err = read(2)
+		if err != nil {
+			return nil, err
+		}
+
+		// object value
+		err = read(int(rxbuf[frameLength-1]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// CRC
+	err = read(2)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRTUFrame(rxbuf[0:frameLength])
+}
+
+// Reads and decodes the remainder of a read FIFO queue response, whose byte
+// count field is 2 bytes long.
+// rxbuf is expected to hold the unit id, function code and first byte of
+// the byte count.
+func (rt *rtuTransport) readFIFOFrame(rxbuf []byte) (*pdu, error) {
+	// read the second byte of the byte count
+	err := rt.readFrameBytes(rxbuf, 3, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the payload and CRC
+	bytesNeeded := int(bytesToUint16(BIG_ENDIAN, rxbuf[2:4])) + 2
+	err = rt.readFrameBytes(rxbuf, 4, bytesNeeded)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRTUFrame(rxbuf[0 : 4+bytesNeeded])
+}
+
+// Reads exactly count bytes from the link into rxbuf, starting at offset.
+func (rt *rtuTransport) readFrameBytes(rxbuf []byte, offset int, count int) error {
+	// never read more than the max allowed frame length
+	if offset+count > maxRTUFrameLength {
+		return ErrProtocol
+	}
+
+	byteCount, err := io.ReadFull(rt.link, rxbuf[offset:offset+count])
+	if byteCount != count {
+		rt.logger.Warningf("expected %v bytes, received %v", count, byteCount)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		return ErrShortFrame
+	}
+
+	return nil
+}
+
+// Waits for, reads and decodes a request frame from the rtu link.
+func (rt *rtuTransport) readRTURequest() (*pdu, error) {
+	var rxbuf []byte
+	var byteCount int
+
+	rxbuf = make([]byte, maxRTUFrameLength)
+
+	// read the serial ADU header: unit id (1 byte) and function code (1 byte)
+	byteCount, err := io.ReadFull(rt.link, rxbuf[0:2])
+	if (byteCount > 0 || err == nil) && byteCount != 2 {
+		return nil, ErrShortFrame
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	// read the fixed part of the request
+	headerLen, err := expectedRequestHeaderLength(rxbuf[1])
+	if err != nil {
+		return nil, err
+	}
+
+	byteCount, err = io.ReadFull(rt.link, rxbuf[2:2+headerLen])
+	if byteCount != headerLen {
+		rt.logger.Warningf("expected %v bytes, received %v", headerLen, byteCount)
+		return nil, ErrShortFrame
+	}
+
+	// requests carrying values end their fixed part with a byte count
+	bytesNeeded := 0
+	if rxbuf[1] == FC_WRITE_MULTIPLE_COILS || rxbuf[1] == FC_WRITE_MULTIPLE_REGISTERS {
+		bytesNeeded = int(rxbuf[2+headerLen-1])
+	}
+
+	// we need to read 2 additional bytes of CRC after the payload
+	bytesNeeded += 2
+
+	// never read more than the max allowed frame length
+	if 2+headerLen+bytesNeeded > maxRTUFrameLength {
+		return nil, ErrProtocol
+	}
+
+	byteCount, err = io.ReadFull(rt.link, rxbuf[2+headerLen:2+headerLen+bytesNeeded])
+	if byteCount != bytesNeeded {
+		rt.logger.Warningf("expected %v bytes, received %v", bytesNeeded, byteCount)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		return nil, ErrShortFrame
+	}
+
+	return decodeRTUFrame(rxbuf[0 : 2+headerLen+bytesNeeded])
 }
 
 // Turns a PDU object into bytes.
 func (rt *rtuTransport) assembleRTUFrame(p *pdu) []byte {
-	var crc crc
 	var adu []byte
 
 	adu = append(adu, p.unitId)
 	adu = append(adu, p.functionCode)
 	adu = append(adu, p.payload...)
 
-	// run the ADU through the CRC generator
-	crc.init()
-	crc.add(adu)
-
 	// append the CRC to the ADU
-	return append(adu, crc.value()...)
+	return AppendCRC16(adu)
+}
+
+// Validates the CRC of a complete RTU frame (unit id, function code,
+// payload and CRC) and turns it into a PDU object.
+func decodeRTUFrame(frame []byte) (*pdu, error) {
+	// compare the CRC of the frame against its last two bytes
+	if !CheckCRC16(frame) {
+		return nil, ErrBadCRC
+	}
+
+	return &pdu{
+		unitId:       frame[0],
+		functionCode: frame[1],
+		// pass the byte count + trailing data as payload, withtout the CRC
+		payload: frame[2 : len(frame)-2],
+	}, nil
+}
+
+// Computes the length of the fixed part of a modbus RTU request (i.e. the
+// number of bytes following the function code, up to and including the
+// byte count field if any).
+func expectedRequestHeaderLength(functionCode uint8) (int, error) {
+	switch functionCode {
+	case FC_READ_HOLDING_REGISTERS,
+		FC_READ_INPUT_REGISTERS,
+		FC_READ_COILS,
+		FC_READ_DISCRETE_INPUTS,
+		FC_WRITE_SINGLE_REGISTER,
+		FC_WRITE_SINGLE_COIL:
+		// address (2 bytes) + quantity or value (2 bytes)
+		return 4, nil
+	case FC_WRITE_MULTIPLE_REGISTERS,
+		FC_WRITE_MULTIPLE_COILS:
+		// address (2 bytes) + quantity (2 bytes) + byte count (1 byte)
+		return 5, nil
+	case FC_MASK_WRITE_REGISTER:
+		// address (2 bytes) + and mask (2 bytes) + or mask (2 bytes)
+		return 6, nil
+	default:
+		return 0, ErrProtocol
+	}
+}
+
+// Returns true if the request is a broadcast (i.e. a write sent to unit id 0),
+// to which no device should reply (serial links only).
+func isBroadcast(req *pdu) bool {
+	if req.unitId != 0x00 {
+		return false
+	}
+
+	switch req.functionCode {
+	case FC_WRITE_SINGLE_COIL,
+		FC_WRITE_MULTIPLE_COILS,
+		FC_WRITE_SINGLE_REGISTER,
+		FC_WRITE_MULTIPLE_REGISTERS,
+		FC_MASK_WRITE_REGISTER:
+		return true
+	default:
+		return false
+	}
 }
 
 // Computes the expected length of a modbus RTU response.
 func expectedResponseLenth(responseCode uint8, responseLength uint8) (int, error) {
 	var byteCount int
 	switch responseCode {
-	case fcReadHoldingRegisters,
-		fcReadInputRegisters,
-		fcReadCoils,
-		fcReadDiscreteInputs:
+	case FC_READ_HOLDING_REGISTERS,
+		FC_READ_INPUT_REGISTERS,
+		FC_READ_COILS,
+		FC_READ_DISCRETE_INPUTS,
+		FC_READ_WRITE_MULTIPLE_REGISTERS,
+		FC_GET_COMM_EVENT_LOG,
+		FC_REPORT_SERVER_ID,
+		FC_READ_FILE_RECORD,
+		FC_WRITE_FILE_RECORD:
 		byteCount = int(responseLength)
-	case fcWriteSingleRegister,
-		fcWriteMultipleRegisters,
-		fcWriteSingleCoil,
-		fcWriteMultipleCoils:
+	case FC_WRITE_SINGLE_REGISTER,
+		FC_WRITE_MULTIPLE_REGISTERS,
+		FC_WRITE_SINGLE_COIL,
+		FC_WRITE_MULTIPLE_COILS,
+		FC_DIAGNOSTICS,
+		FC_GET_COMM_EVENT_COUNTER:
 		byteCount = 3
-	case fcMaskWriteRegister:
+	case FC_MASK_WRITE_REGISTER:
 		byteCount = 5
-	case fcReadHoldingRegisters | 0x80,
-		fcReadInputRegisters | 0x80,
-		fcReadCoils | 0x80,
-		fcReadDiscreteInputs | 0x80,
-		fcWriteSingleRegister | 0x80,
-		fcWriteMultipleRegisters | 0x80,
-		fcWriteSingleCoil | 0x80,
-		fcWriteMultipleCoils | 0x80,
-		fcMaskWriteRegister | 0x80:
+	case FC_READ_EXCEPTION_STATUS:
+		// the status byte is all there is
+		byteCount = 0
+	case FC_READ_HOLDING_REGISTERS | 0x80,
+		FC_READ_INPUT_REGISTERS | 0x80,
+		FC_READ_COILS | 0x80,
+		FC_READ_DISCRETE_INPUTS | 0x80,
+		FC_WRITE_SINGLE_REGISTER | 0x80,
+		FC_WRITE_MULTIPLE_REGISTERS | 0x80,
+		FC_WRITE_SINGLE_COIL | 0x80,
+		FC_WRITE_MULTIPLE_COILS | 0x80,
+		FC_MASK_WRITE_REGISTER | 0x80,
+		FC_READ_WRITE_MULTIPLE_REGISTERS | 0x80,
+		FC_READ_EXCEPTION_STATUS | 0x80,
+		FC_DIAGNOSTICS | 0x80,
+		FC_GET_COMM_EVENT_COUNTER | 0x80,
+		FC_GET_COMM_EVENT_LOG | 0x80,
+		FC_REPORT_SERVER_ID | 0x80,
+		FC_READ_FILE_RECORD | 0x80,
+		FC_WRITE_FILE_RECORD | 0x80,
+		FC_READ_FIFO_QUEUE | 0x80,
+		FC_ENCAPSULATED_INTERFACE | 0x80:
 		byteCount = 0
 	default:
 		return 0, ErrProtocol
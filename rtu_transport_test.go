@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"bytes"
 	"io"
 	"net"
 	"testing"
@@ -139,6 +140,187 @@ func TestRTUTransportReadRTUFrame(t *testing.T) {
 	p2.Close()
 }
 
+func TestRTUTransportReadRequest(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var req *pdu
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	rt = newRTUTransport(p2, "", 38400, 10*time.Millisecond, nil)
+
+	// read a fixed-length request (read holding registers)
+	txchan <- []byte{
+		0x11, 0x03, // unit id and function code
+		0x00, 0x6b, // start address
+		0x00, 0x03, // quantity
+		0x76, 0x87, // CRC
+	}
+	req, err = rt.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got %v", err)
+	}
+	if req.unitId != 0x11 {
+		t.Errorf("expected 0x11 as unit id, got 0x%02x", req.unitId)
+	}
+	if req.functionCode != 0x03 {
+		t.Errorf("expected 0x03 as function code, got 0x%02x", req.functionCode)
+	}
+	for i, b := range []byte{
+		0x00, 0x6b,
+		0x00, 0x03,
+	} {
+		if req.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				b, i, req.payload[i])
+		}
+	}
+
+	// read a variable-length request (write multiple registers)
+	txchan <- []byte{
+		0x11, 0x10, // unit id and function code
+		0x00, 0x01, // start address
+		0x00, 0x02, // quantity
+		0x04,       // byte count
+		0x00, 0x0a, // register #1
+		0x01, 0x02, // register #2
+		0xc6, 0xf0, // CRC
+	}
+	req, err = rt.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got %v", err)
+	}
+	if req.functionCode != 0x10 {
+		t.Errorf("expected 0x10 as function code, got 0x%02x", req.functionCode)
+	}
+	if len(req.payload) != 9 {
+		t.Errorf("expected a length of 9, got %v", len(req.payload))
+	}
+	for i, b := range []byte{
+		0x00, 0x01,
+		0x00, 0x02,
+		0x04,
+		0x00, 0x0a,
+		0x01, 0x02,
+	} {
+		if req.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				b, i, req.payload[i])
+		}
+	}
+
+	// read a request with a bad crc
+	txchan <- []byte{
+		0x11, 0x06, // unit id and function code
+		0x00, 0x01, // address
+		0x00, 0x03, // value
+		0x00, 0x00, // CRC
+	}
+	_, err = rt.ReadRequest()
+	if err != ErrBadCRC {
+		t.Errorf("ReadRequest() should have returned ErrBadCRC, got %v", err)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportExecuteRequestError(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var err error
+
+	p1, p2 = net.Pipe()
+
+	// play the role of a device replying with a corrupted frame
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		_, rerr := io.ReadFull(p1, rxbuf)
+		if rerr != nil {
+			return
+		}
+
+		p1.Write([]byte{
+			0x11, 0x83, // unit id and exception code
+			0x02,       // exception code
+			0x00, 0x00, // (bad) CRC
+		})
+	}()
+
+	rt = newRTUTransport(p2, "", 38400, 100*time.Millisecond, nil)
+
+	_, err = rt.ExecuteRequest(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	if err != ErrBadCRC {
+		t.Errorf("ExecuteRequest() should have returned ErrBadCRC, got %v", err)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportUnitIdMismatch(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var res *pdu
+	var err error
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	rt = newRTUTransport(p2, "", 38400, 100*time.Millisecond, nil)
+
+	// play the role of two devices replying in turn, with valid frames
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		for _, unitId := range []uint8{0x12, 0x11} {
+			_, rerr := io.ReadFull(p1, rxbuf)
+			if rerr != nil {
+				return
+			}
+
+			p1.Write(rt.assembleRTUFrame(&pdu{
+				unitId:       unitId,
+				functionCode: 0x83,
+				payload:      []byte{0x02},
+			}))
+		}
+	}()
+
+	// a response from another device should be rejected...
+	_, err = rt.ExecuteRequest(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	if err != ErrBadUnitId {
+		t.Errorf("ExecuteRequest() should have returned ErrBadUnitId, got %v", err)
+	}
+
+	// ... but not one from the device the request was sent to
+	res, err = rt.ExecuteRequest(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	if err != nil {
+		t.Errorf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+	if res == nil || res.unitId != 0x11 || res.functionCode != 0x83 {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
 func feedTestPipe(t *testing.T, in chan []byte, out io.WriteCloser) {
 	var err error
 	var txbuf []byte
@@ -176,3 +358,506 @@ func TestModbusRTUSerialCharTime(t *testing.T) {
 		t.Errorf("unexpected serial char duration: %v", d)
 	}
 }
+
+func TestRTUOverTCPClient(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+
+	// play the role of a serial to ethernet converter, tunneling raw
+	// RTU frames over TCP
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, err = io.ReadFull(conn, rxbuf)
+		if err != nil {
+			return
+		}
+
+		// expect a read holding registers request for 2 registers at
+		// address 0x0010, sent to unit id 0x05
+		for i, b := range (&rtuTransport{}).assembleRTUFrame(&pdu{
+			unitId:       0x05,
+			functionCode: 0x03,
+			payload:      []byte{0x00, 0x10, 0x00, 0x02},
+		}) {
+			if rxbuf[i] != b {
+				t.Errorf("expected 0x%02x at position %v, got 0x%02x", b, i, rxbuf[i])
+			}
+		}
+
+		conn.Write((&rtuTransport{}).assembleRTUFrame(&pdu{
+			unitId:       0x05,
+			functionCode: 0x03,
+			payload:      []byte{0x04, 0x12, 0x34, 0xab, 0xcd},
+		}))
+	}()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtuovertcp://" + ln.Addr().String(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	client.SetUnitId(0x05)
+	regs, err = client.ReadRegisters(0x0010, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if regs[0] != 0x1234 || regs[1] != 0xabcd {
+		t.Errorf("expected {0x1234, 0xabcd}, got: %v", regs)
+	}
+}
+
+func TestRTUTransportBroadcast(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var err error
+	var res *pdu
+	var rxbuf = make([]byte, 8)
+	var done = make(chan struct{})
+
+	p1, p2 = net.Pipe()
+
+	// play the role of a device receiving the broadcast, without replying
+	go func() {
+		defer close(done)
+
+		_, err := io.ReadFull(p1, rxbuf)
+		if err != nil {
+			t.Errorf("failed to read broadcast request: %v", err)
+		}
+	}()
+
+	rt = newRTUTransport(p2, "", 38400, 500*time.Millisecond, nil)
+
+	ts := time.Now()
+	res, err = rt.ExecuteRequest(&pdu{
+		unitId:       0x00,
+		functionCode: FC_WRITE_SINGLE_REGISTER,
+		payload:      []byte{0x00, 0x01, 0x12, 0x34},
+	})
+	if err != nil {
+		t.Errorf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+	if res != nil {
+		t.Errorf("expected no response, got %v", res)
+	}
+	if time.Since(ts) >= 500*time.Millisecond {
+		t.Errorf("ExecuteRequest() should not have waited for a response")
+	}
+
+	<-done
+	if rxbuf[0] != 0x00 || rxbuf[1] != FC_WRITE_SINGLE_REGISTER {
+		t.Errorf("unexpected broadcast frame: %v", rxbuf)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportReadDeviceIdFrame(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var res *pdu
+	var frame []byte
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	rt = newRTUTransport(p2, "", 9600, 10*time.Millisecond, nil)
+
+	// read a device identification response holding 2 objects, which
+	// carries no byte count field
+	frame = rt.assembleRTUFrame(&pdu{
+		unitId:       0x01,
+		functionCode: 0x2b,
+		payload: []byte{
+			0x0e, 0x01, 0x01, // MEI type, read device id code, conformity level
+			0x00, 0x00, 0x02, // more follows, next object id, number of objects
+			0x00, 0x03, 'A', 'C', 'M', // vendor name
+			0x01, 0x02, 'X', '1', // product code
+		},
+	})
+	txchan <- frame[0:5]
+	txchan <- frame[5:]
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Fatalf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x2b {
+		t.Errorf("expected 0x2b as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 15 {
+		t.Errorf("expected a length of 15, got %v", len(res.payload))
+	}
+
+	// read a device identification exception response
+	txchan <- rt.assembleRTUFrame(&pdu{
+		unitId:       0x01,
+		functionCode: 0xab,
+		payload:      []byte{0x01},
+	})
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Fatalf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0xab || len(res.payload) != 1 {
+		t.Errorf("unexpected response: 0x%02x, %v", res.functionCode, res.payload)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportReadFIFOFrame(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var res *pdu
+	var frame []byte
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	rt = newRTUTransport(p2, "", 9600, 10*time.Millisecond, nil)
+
+	// read a FIFO queue response, whose byte count is 2 bytes long
+	frame = rt.assembleRTUFrame(&pdu{
+		unitId:       0x01,
+		functionCode: 0x18,
+		payload: []byte{
+			0x00, 0x06, // byte count
+			0x00, 0x02, // FIFO count
+			0x01, 0xb8, // FIFO value
+			0x12, 0x84, // FIFO value
+		},
+	})
+	txchan <- frame[0:3]
+	txchan <- frame[3:]
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Fatalf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x18 {
+		t.Errorf("expected 0x18 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 8 {
+		t.Errorf("expected a length of 8, got %v", len(res.payload))
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportInterRequestDelay(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var err error
+	var responded chan time.Time
+	var received chan time.Time
+	var client *ModbusClient
+
+	p1, p2 = net.Pipe()
+	responded = make(chan time.Time, 2)
+	received = make(chan time.Time, 2)
+
+	// play the role of a device answering read holding register requests
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		for {
+			_, err := io.ReadFull(p1, rxbuf)
+			if err != nil {
+				return
+			}
+			received <- time.Now()
+
+			p1.Write((&rtuTransport{}).assembleRTUFrame(&pdu{
+				unitId:       0x01,
+				functionCode: 0x03,
+				payload:      []byte{0x02, 0x00, 0x01},
+			}))
+			responded <- time.Now()
+		}
+	}()
+
+	rt = newRTUTransport(p2, "", 115200, 500*time.Millisecond, nil)
+	rt.interRequestDelay = 100 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		_, err = rt.ExecuteRequest(&pdu{
+			unitId:       0x01,
+			functionCode: 0x03,
+			payload:      []byte{0x00, 0x00, 0x00, 0x01},
+		})
+		if err != nil {
+			t.Fatalf("ExecuteRequest() should have succeeded, got: %v", err)
+		}
+	}
+
+	// the second request should have been held back until the delay
+	// elapsed, counting from the end of the first response
+	<-received
+	gap := (<-received).Sub(<-responded)
+	if gap < 100*time.Millisecond {
+		t.Errorf("expected a gap of at least 100ms between transactions, got %v", gap)
+	}
+
+	p1.Close()
+	p2.Close()
+
+	// the delay should be applied to the transport of the client, through
+	// handles as well
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtuovertcp://localhost:5502",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newRTUTransport(p2, "", 9600, time.Second, nil)
+	client.WithUnitId(0x02).SetInterRequestDelay(20 * time.Millisecond)
+	if client.transport.(*rtuTransport).interRequestDelay != 20*time.Millisecond {
+		t.Errorf("expected a 20ms inter-request delay, got %v",
+			client.transport.(*rtuTransport).interRequestDelay)
+	}
+}
+
+func TestRTUTransportBusIdleWindow(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var res *pdu
+	var err error
+	var stop chan bool = make(chan bool)
+	var done chan bool = make(chan bool)
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	// 38400 bps: t3.5 is 1750us
+	rt = newRTUTransport(p2, "", 38400, 100*time.Millisecond, nil)
+	rt.busIdleWindow = 10 * time.Millisecond
+
+	// play the role of another master, keeping the line busy
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			p1.SetWriteDeadline(time.Now().Add(1 * time.Millisecond))
+			p1.Write([]byte{0x55})
+			time.Sleep(200 * time.Microsecond)
+		}
+	}()
+
+	start := time.Now()
+	_, err = rt.ExecuteRequest(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x01},
+	})
+	if err != ErrBusBusy {
+		t.Errorf("ExecuteRequest() should have returned ErrBusBusy, got %v", err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Errorf("ExecuteRequest() should have given up after ~10ms, took %v",
+			time.Since(start))
+	}
+
+	close(stop)
+	<-done
+	p1.SetWriteDeadline(time.Time{})
+
+	// once the line is quiet, requests should go through
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		_, rerr := io.ReadFull(p1, rxbuf)
+		if rerr != nil {
+			return
+		}
+
+		p1.Write(rt.assembleRTUFrame(&pdu{
+			unitId:       0x11,
+			functionCode: 0x03,
+			payload:      []byte{0x02, 0x12, 0x34},
+		}))
+	}()
+
+	res, err = rt.ExecuteRequest(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x01},
+	})
+	if err != nil {
+		t.Errorf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+	if res == nil || !bytes.Equal(res.payload, []byte{0x02, 0x12, 0x34}) {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestRTUTransportResyncOnFrameError(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var res *pdu
+	var err error
+	var noiseDone chan bool = make(chan bool)
+	var req *pdu = &pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x01},
+	}
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	// 9600 bps: t3.5 is ~4ms, leaving enough margin over the noise interval
+	// for the test not to be sensitive to scheduling delays
+	rt = newRTUTransport(p2, "", 9600, time.Second, nil)
+	rt.resyncOnError = true
+
+	// reply with a corrupted frame followed by ~10ms of line noise
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		defer close(noiseDone)
+
+		_, rerr := io.ReadFull(p1, rxbuf)
+		if rerr != nil {
+			return
+		}
+
+		frame := rt.assembleRTUFrame(&pdu{
+			unitId:       0x11,
+			functionCode: 0x03,
+			payload:      []byte{0x02, 0x12, 0x34},
+		})
+		frame[len(frame)-1] ^= 0xff
+		p1.Write(frame)
+
+		for range 20 {
+			p1.Write([]byte{0x55})
+			time.Sleep(500 * time.Microsecond)
+		}
+	}()
+
+	start := time.Now()
+	_, err = rt.ExecuteRequest(req)
+	if err != ErrBadCRC {
+		t.Errorf("ExecuteRequest() should have returned ErrBadCRC, got %v", err)
+	}
+
+	// the noise should have been drained, and no longer than needed
+	elapsed := time.Since(start)
+	select {
+	case <-noiseDone:
+	case <-time.After(10 * time.Millisecond):
+		t.Errorf("the line should have been drained")
+	}
+	if elapsed < 10*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("ExecuteRequest() should have returned after ~10ms, took %v",
+			elapsed)
+	}
+
+	// the next request should go through
+	go func() {
+		var rxbuf = make([]byte, 8)
+
+		_, rerr := io.ReadFull(p1, rxbuf)
+		if rerr != nil {
+			return
+		}
+
+		p1.Write(rt.assembleRTUFrame(&pdu{
+			unitId:       0x11,
+			functionCode: 0x03,
+			payload:      []byte{0x02, 0x12, 0x34},
+		}))
+	}()
+
+	res, err = rt.ExecuteRequest(req)
+	if err != nil {
+		t.Fatalf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+	if !bytes.Equal(res.payload, []byte{0x02, 0x12, 0x34}) {
+		t.Errorf("unexpected payload: % x", res.payload)
+	}
+}
+
+func TestRTUTransportReadExceptionStatus(t *testing.T) {
+	var rt *rtuTransport
+	var p1, p2 net.Conn
+	var res *pdu
+	var err error
+	var req *pdu = &pdu{
+		unitId:       0x11,
+		functionCode: 0x07,
+	}
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	rt = newRTUTransport(p2, "", 9600, 500*time.Millisecond, nil)
+
+	// reply with the status byte, then with an exception
+	go func() {
+		var rxbuf = make([]byte, 4)
+
+		for _, res := range []*pdu{
+			{unitId: 0x11, functionCode: 0x07, payload: []byte{0x6d}},
+			{unitId: 0x11, functionCode: 0x87, payload: []byte{0x04}},
+		} {
+			_, rerr := io.ReadFull(p1, rxbuf)
+			if rerr != nil {
+				return
+			}
+			p1.Write(rt.assembleRTUFrame(res))
+		}
+	}()
+
+	// responses should be delimited without waiting out the timeout
+	for _, expected := range [][]byte{{0x6d}, {0x04}} {
+		start := time.Now()
+		res, err = rt.ExecuteRequest(req)
+		if err != nil {
+			t.Fatalf("ExecuteRequest() should have succeeded, got %v", err)
+		}
+		if !bytes.Equal(res.payload, expected) {
+			t.Errorf("unexpected payload: % x", res.payload)
+		}
+		if time.Since(start) > 400*time.Millisecond {
+			t.Errorf("ExecuteRequest() took %v", time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package modbus
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Hands out turns on a shared connection, one request at a time, serving
+// per unit id queues in turn so that a slow device with many requests
+// waiting does not hold up requests to other devices.
+type scheduler struct {
+	lock sync.Mutex
+	// true while a request holds the turn
+	busy bool
+	// waiting requests, per unit id
+	queues map[uint8][]chan struct{}
+	// unit ids with waiting requests, in the order they are to be served
+	order []uint8
+}
+
+// Returns a new scheduler.
+func newScheduler() *scheduler {
+	return &scheduler{
+		queues: make(map[uint8][]chan struct{}),
+	}
+}
+
+// Waits for the turn of a request to unitId, or for ctx to be done (in which
+// case ctx.Err() is returned).
+// Every successful call must be followed by a call to release().
+func (s *scheduler) acquire(ctx context.Context, unitId uint8) error {
+	s.lock.Lock()
+	if !s.busy {
+		s.busy = true
+		s.lock.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	if len(s.queues[unitId]) == 0 {
+		s.order = append(s.order, unitId)
+	}
+	s.queues[unitId] = append(s.queues[unitId], ready)
+	s.lock.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	queue := s.queues[unitId]
+	idx := slices.Index(queue, ready)
+	if idx < 0 {
+		// the turn was handed to us in the meantime: pass it on
+		s.lock.Unlock()
+		s.release()
+		return ctx.Err()
+	}
+
+	queue = slices.Delete(queue, idx, idx+1)
+	if len(queue) == 0 {
+		delete(s.queues, unitId)
+		s.order = slices.DeleteFunc(s.order, func(id uint8) bool {
+			return id == unitId
+		})
+	} else {
+		s.queues[unitId] = queue
+	}
+	s.lock.Unlock()
+
+	return ctx.Err()
+}
+
+// Hands the turn over to the oldest request of the next unit id in line,
+// moving that unit id to the back of the line if it has more requests
+// waiting.
+func (s *scheduler) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.order) == 0 {
+		s.busy = false
+		return
+	}
+
+	unitId := s.order[0]
+	s.order = s.order[1:]
+
+	queue := s.queues[unitId]
+	ready := queue[0]
+	if len(queue) > 1 {
+		s.queues[unitId] = queue[1:]
+		s.order = append(s.order, unitId)
+	} else {
+		delete(s.queues, unitId)
+	}
+
+	close(ready)
+}
@@ -0,0 +1,153 @@
+package modbus
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Waits for count requests to be queued on s.
+func waitQueued(t *testing.T, s *scheduler, count int) {
+	for range 100 {
+		s.lock.Lock()
+		queued := 0
+		for _, queue := range s.queues {
+			queued += len(queue)
+		}
+		s.lock.Unlock()
+
+		if queued == count {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v queued requests", count)
+}
+
+func TestScheduler(t *testing.T) {
+	var s *scheduler = newScheduler()
+	var lock sync.Mutex
+	var order []uint8
+	var wg sync.WaitGroup
+	var err error
+
+	// the first request should get the turn right away
+	err = s.acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("acquire() should have succeeded, got: %v", err)
+	}
+
+	// queue 3 requests to unit 1, then one to unit 2
+	for i, unitId := range []uint8{1, 1, 1, 2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.acquire(context.Background(), unitId)
+			if err != nil {
+				t.Errorf("acquire() should have succeeded, got: %v", err)
+				return
+			}
+			lock.Lock()
+			order = append(order, unitId)
+			lock.Unlock()
+			s.release()
+		}()
+		waitQueued(t, s, i+1)
+	}
+
+	// unit 2 should not have to wait for all requests to unit 1
+	s.release()
+	wg.Wait()
+	if !slices.Equal(order, []uint8{1, 2, 1, 1}) {
+		t.Errorf("unexpected order: %v", order)
+	}
+
+	// waiting requests should give up when their context is done
+	err = s.acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("acquire() should have succeeded, got: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = s.acquire(ctx, 2)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if len(s.queues) != 0 || len(s.order) != 0 {
+		t.Errorf("cancelled requests should have been dequeued: %v, %v",
+			s.queues, s.order)
+	}
+
+	// and the scheduler should go idle once the turn is released
+	s.release()
+	if s.busy {
+		t.Error("the scheduler should be idle")
+	}
+}
+
+func TestClientRoundRobinScheduling(t *testing.T) {
+	var client *ModbusClient
+	var lock sync.Mutex
+	var order []uint8
+	var wg sync.WaitGroup
+	var unblock chan bool = make(chan bool)
+
+	client = newTestClient(func(req *pdu) (*pdu, error) {
+		lock.Lock()
+		order = append(order, req.unitId)
+		first := len(order) == 1
+		lock.Unlock()
+
+		// hold the connection until all requests are queued
+		if first {
+			<-unblock
+		}
+
+		return &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{0x02, 0x00, 0x00},
+		}, nil
+	})
+	client.sched = newScheduler()
+
+	// requests to the slow device, through both the client and a handle,
+	// followed by a request to another device
+	for i, c := range []*ModbusClient{
+		client, client, client.WithUnitId(1), client.WithUnitId(2),
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := c.ReadRegister(0, HOLDING_REGISTER)
+			if err != nil {
+				t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+			}
+		}()
+		if i == 0 {
+			// wait for the first request to reach the handler
+			for {
+				lock.Lock()
+				started := len(order) == 1
+				lock.Unlock()
+				if started {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			continue
+		}
+		waitQueued(t, client.sched, i)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if !slices.Equal(order, []uint8{1, 1, 2, 1}) {
+		t.Errorf("unexpected order: %v", order)
+	}
+}
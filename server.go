@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
@@ -25,8 +26,21 @@ type ServerConfiguration struct {
 	// Timeout sets the idle session timeout (client connections will
 	// be closed if idle for this long)
 	Timeout time.Duration
+	// IdleTimeout sets how long to wait for the next request on a client
+	// connection before closing it (defaults to Timeout).
+	IdleTimeout time.Duration
+	// ReadTimeout sets how long a request may take to be received in full
+	// once its first byte has arrived, after which the connection is closed.
+	// Defaults to 0 (requests must be received in full within IdleTimeout).
+	ReadTimeout time.Duration
 	// MaxClients sets the maximum number of concurrent client connections
 	MaxClients uint
+	// MaxConcurrentRequests caps the number of requests handled at the same
+	// time across all client connections, to protect slow handlers from
+	// being overwhelmed. Requests beyond the limit are rejected with a server
+	// device busy exception (code 0x06).
+	// Defaults to 0 (no limit other than MaxClients).
+	MaxConcurrentRequests uint
 	// TLSServerCert sets the server-side TLS key pair (tcp+tls only)
 	TLSServerCert *tls.Certificate
 	// TLSClientCAs sets the list of CA certificates used to authenticate
@@ -36,6 +50,12 @@ type ServerConfiguration struct {
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger *log.Logger
+	// LogLevel sets the minimum level of log messages to output
+	// (defaults to LOG_INFO, use LOG_OFF to silence all messages).
+	LogLevel LogLevel
+	// LogFunc, if set, receives log messages along with structured fields
+	// instead of Logger/stdout.
+	LogFunc LogFunc
 }
 
 // Request object passed to the coil handler.
@@ -149,6 +169,11 @@ type ModbusServer struct {
 	tcpListener   net.Listener
 	tcpClients    []net.Conn
 	transportType transportType
+	// cancelled to stop reading requests from client connections
+	ctx    context.Context
+	cancel context.CancelFunc
+	// request handling slots (nil if unlimited)
+	handlerSlots chan struct{}
 }
 
 // Returns a new modbus server.
@@ -172,6 +197,7 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 
 	ms.logger = newLogger(
 		fmt.Sprintf("modbus-server(%s)", ms.conf.URL), ms.conf.Logger)
+	ms.logger.configure(ms.conf.LogLevel, ms.conf.LogFunc)
 
 	if ms.conf.URL == "" {
 		ms.logger.Errorf("missing host part in URL '%s'", conf.URL)
@@ -179,6 +205,10 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 		return
 	}
 
+	if ms.conf.MaxConcurrentRequests > 0 {
+		ms.handlerSlots = make(chan struct{}, ms.conf.MaxConcurrentRequests)
+	}
+
 	switch serverType {
 	case "tcp":
 		if ms.conf.Timeout == 0 {
@@ -242,6 +272,8 @@ func (ms *ModbusServer) Start() (err error) {
 			return
 		}
 
+		ms.ctx, ms.cancel = context.WithCancel(context.Background())
+
 		// accept client connections in a goroutine
 		go ms.acceptTCPClients()
 
@@ -269,6 +301,7 @@ func (ms *ModbusServer) Stop() (err error) {
 	if ms.transportType == modbusTCP || ms.transportType == modbusTCPOverTLS {
 		// close the server socket if we're listening over TCP
 		err = ms.tcpListener.Close()
+		ms.cancel()
 
 		// close all active TCP clients
 		for _, sock := range ms.tcpClients {
@@ -279,6 +312,83 @@ func (ms *ModbusServer) Stop() (err error) {
 	return
 }
 
+// Gracefully shuts down the server: stops accepting new client connections
+// and reading requests, closes idle connections and lets requests being
+// handled run to completion and their responses be written before closing
+// the remaining connections.
+// If ctx is done before then (e.g. at the end of a grace period set with
+// context.WithTimeout()), remaining connections are closed forcibly and
+// ctx.Err() is returned.
+func (ms *ModbusServer) Shutdown(ctx context.Context) (err error) {
+	var ticker *time.Ticker
+
+	ms.lock.Lock()
+	if !ms.started {
+		ms.lock.Unlock()
+		return
+	}
+
+	ms.started = false
+
+	// close the server socket
+	err = ms.tcpListener.Close()
+
+	// unblock connections waiting for a request (or going through a TLS
+	// handshake): they get closed right away, while busy ones are closed
+	// by their handler once the response is written
+	ms.cancel()
+	ms.lock.Unlock()
+
+	ticker = time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		ms.lock.Lock()
+		remaining := len(ms.tcpClients)
+		ms.lock.Unlock()
+
+		if remaining == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			ms.logger.Warningf("grace period expired, closing %v busy "+
+				"connection(s)", remaining)
+
+			ms.lock.Lock()
+			for _, sock := range ms.tcpClients {
+				sock.Close()
+			}
+			ms.lock.Unlock()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// Takes a request handling slot, if any is available.
+func (ms *ModbusServer) acquireHandlerSlot() bool {
+	if ms.handlerSlots == nil {
+		return true
+	}
+
+	select {
+	case ms.handlerSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Gives back a request handling slot.
+func (ms *ModbusServer) releaseHandlerSlot() {
+	if ms.handlerSlots != nil {
+		<-ms.handlerSlots
+	}
+}
+
 // Accepts new client connections if the configured connection limit allows it.
 // Each connection is served from a dedicated goroutine to allow for concurrent
 // connections.
@@ -286,6 +396,7 @@ func (ms *ModbusServer) acceptTCPClients() {
 	var sock net.Conn
 	var err error
 	var accepted bool
+	var ctx context.Context
 
 	for {
 		sock, err = ms.tcpListener.Accept()
@@ -305,6 +416,7 @@ func (ms *ModbusServer) acceptTCPClients() {
 			accepted = true
 			// add the new client connection to the pool
 			ms.tcpClients = append(ms.tcpClients, sock)
+			ctx = ms.ctx
 		} else {
 			accepted = false
 		}
@@ -312,7 +424,7 @@ func (ms *ModbusServer) acceptTCPClients() {
 
 		if accepted {
 			// spin a client handler goroutine to serve the new client
-			go ms.handleTCPClient(sock)
+			go ms.handleTCPClient(ctx, sock)
 		} else {
 			ms.logger.Warningf("max. number of concurrent connections "+
 				"reached, rejecting %v", sock.RemoteAddr())
@@ -322,11 +434,11 @@ func (ms *ModbusServer) acceptTCPClients() {
 	}
 }
 
-// Handles a TCP client connection.
+// Handles a TCP client connection, until ctx is done.
 // Once handleTransport() returns (i.e. the connection has either closed, timed
 // out, or an unrecoverable error happened), the TCP socket is closed and removed
 // from the list of active client connections.
-func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
+func (ms *ModbusServer) handleTCPClient(ctx context.Context, sock net.Conn) {
 	var err error
 	var clientRole string
 	var tlsSock net.Conn
@@ -334,20 +446,20 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 	switch ms.transportType {
 	case modbusTCP:
 		// serve modbus requests over the raw TCP connection
-		ms.handleTransport(
-			newTCPTransport(sock, ms.conf.Timeout, ms.conf.Logger),
+		ms.handleTransport(ctx,
+			ms.newTCPTransport(sock),
 			sock.RemoteAddr().String(), "")
 
 	case modbusTCPOverTLS:
 		// start TLS negotiation over the raw TCP connection
-		tlsSock, clientRole, err = ms.startTLS(sock)
+		tlsSock, clientRole, err = ms.startTLS(ctx, sock)
 		if err != nil {
 			ms.logger.Warningf("TLS handshake with %s failed: %v",
 				sock.RemoteAddr().String(), err)
 		} else {
 			// serve modbus requests over the TLS tunnel
-			ms.handleTransport(
-				newTCPTransport(tlsSock, ms.conf.Timeout, ms.conf.Logger),
+			ms.handleTransport(ctx,
+				ms.newTCPTransport(tlsSock),
 				sock.RemoteAddr().String(), clientRole)
 		}
 
@@ -370,10 +482,25 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 	sock.Close()
 }
 
+// Returns a TCP transport serving requests over sock.
+func (ms *ModbusServer) newTCPTransport(sock net.Conn) (tt *tcpTransport) {
+	idleTimeout := ms.conf.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = ms.conf.Timeout
+	}
+
+	tt = newTCPTransport(sock, idleTimeout, ms.logger)
+	tt.readTimeout = ms.conf.ReadTimeout
+
+	return
+}
+
 // For each request read from the transport, performs decoding and validation,
 // calls the user-provided handler, then encodes and writes the response
-// to the transport.
-func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRole string) {
+// to the transport. Returns as soon as ctx is done, letting the request being
+// handled (if any) complete first.
+func (ms *ModbusServer) handleTransport(ctx context.Context, t transport,
+	clientAddr string, clientRole string) {
 	var req *pdu
 	var res *pdu
 	var err error
@@ -381,13 +508,39 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 	var quantity uint16
 
 	for {
-		req, err = t.ReadRequest()
+		if rr, ok := t.(contextRequestReader); ok {
+			req, err = rr.ReadRequestContext(ctx)
+		} else {
+			req, err = t.ReadRequest()
+		}
 		if err != nil {
 			return
 		}
 
+		// don't start handling new requests when shutting down
+		if ctx.Err() != nil {
+			return
+		}
+
+		// reject requests beyond the concurrency limit
+		if !ms.acquireHandlerSlot() {
+			ms.logger.Warningf("too many concurrent requests, rejecting "+
+				"function code 0x%02x (client address: '%s')",
+				req.functionCode, clientAddr)
+
+			err = t.WriteResponse(&pdu{
+				unitId:       req.unitId,
+				functionCode: (0x80 | req.functionCode),
+				payload:      []byte{EX_SERVER_DEVICE_BUSY},
+			})
+			if err != nil {
+				ms.logger.Warningf("failed to write response: %v", err)
+			}
+			continue
+		}
+
 		switch req.functionCode {
-		case fcReadCoils, fcReadDiscreteInputs:
+		case FC_READ_COILS, FC_READ_DISCRETE_INPUTS:
 			var coils []bool
 			var resCount int
 
@@ -412,7 +565,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			}
 
 			// invoke the appropriate handler
-			if req.functionCode == fcReadCoils {
+			if req.functionCode == FC_READ_COILS {
 				coils, err = ms.handler.HandleCoils(&CoilsRequest{
 					ClientAddr: clientAddr,
 					ClientRole: clientRole,
@@ -462,7 +615,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			// coil values
 			res.payload = append(res.payload, encodeBools(coils)...)
 
-		case fcWriteSingleCoil:
+		case FC_WRITE_SINGLE_COIL:
 			if len(req.payload) != 4 {
 				err = ErrProtocol
 				break
@@ -505,7 +658,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			res.payload = append(res.payload,
 				req.payload[2], req.payload[3])
 
-		case fcWriteMultipleCoils:
+		case FC_WRITE_MULTIPLE_COILS:
 			var expectedLen int
 
 			if len(req.payload) < 6 {
@@ -572,7 +725,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			res.payload = append(res.payload,
 				uint16ToBytes(BIG_ENDIAN, quantity)...)
 
-		case fcReadHoldingRegisters, fcReadInputRegisters:
+		case FC_READ_HOLDING_REGISTERS, FC_READ_INPUT_REGISTERS:
 			var regs []uint16
 			var resCount int
 
@@ -597,7 +750,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			}
 
 			// invoke the appropriate handler
-			if req.functionCode == fcReadHoldingRegisters {
+			if req.functionCode == FC_READ_HOLDING_REGISTERS {
 				regs, err = ms.handler.HandleHoldingRegisters(
 					&HoldingRegistersRequest{
 						ClientAddr: clientAddr,
@@ -646,7 +799,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			res.payload = append(res.payload,
 				uint16sToBytes(BIG_ENDIAN, regs)...)
 
-		case fcWriteSingleRegister:
+		case FC_WRITE_SINGLE_REGISTER:
 			var value uint16
 
 			if len(req.payload) != 4 {
@@ -686,7 +839,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			res.payload = append(res.payload,
 				uint16ToBytes(BIG_ENDIAN, value)...)
 
-		case fcWriteMultipleRegisters:
+		case FC_WRITE_MULTIPLE_REGISTERS:
 			var expectedLen int
 
 			if len(req.payload) < 6 {
@@ -758,10 +911,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				functionCode: (0x80 | req.functionCode),
 				// set the exception code to illegal function to indicate that
 				// the server does not know how to handle this function code.
-				payload: []byte{exIllegalFunction},
+				payload: []byte{EX_ILLEGAL_FUNCTION},
 			}
 		}
 
+		ms.releaseHandlerSlot()
+
 		// if there was no error processing the request but the response is nil
 		// (which should never happen), emit a server failure exception code
 		// and log an error
@@ -803,7 +958,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 
 // startTLS performs a full TLS handshake (with client authentication) on tcpSock
 // and returns a 'wrapped' clear-text socket suitable for use by the TCP transport.
-func (ms *ModbusServer) startTLS(tcpSock net.Conn) (*tls.Conn, string, error) {
+func (ms *ModbusServer) startTLS(ctx context.Context, tcpSock net.Conn) (*tls.Conn, string, error) {
 	var (
 		connState  tls.ConnectionState
 		clientRole string
@@ -829,7 +984,7 @@ func (ms *ModbusServer) startTLS(tcpSock net.Conn) (*tls.Conn, string, error) {
 	})
 
 	// complete the full TLS handshake (with client cert validation)
-	err = tlsSock.Handshake()
+	err = tlsSock.HandshakeContext(ctx)
 	if err != nil {
 		return nil, "", err
 	}
@@ -1,6 +1,10 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -269,19 +273,19 @@ func TestTCPServerCoilsAndDiscreteInputs(t *testing.T) {
 
 	// reading past the array size should return ErrIllegalDataAddress
 	_, err = client.ReadDiscreteInputs(0x000a, 1)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
 	}
 	_, err = client.ReadCoils(0x000a, 1)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
 	}
 	_, err = client.ReadDiscreteInputs(0x8, 3)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
 	}
 	_, err = client.ReadCoils(0x8, 3)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
 	}
 
@@ -332,25 +336,76 @@ func TestTCPServerCoilsAndDiscreteInputs(t *testing.T) {
 		}
 	}
 
+	// quantities outside of protocol limits should be rejected before
+	// anything is sent (2000 coils per read, 1968 coils per write)
+	_, err = client.ReadCoils(0x0000, 0)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadCoils() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadCoils(0x0000, 2001)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadCoils() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadDiscreteInputs(0x0000, 2001)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadDiscreteInputs() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	err = client.WriteCoils(0x0000, []bool{})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.WriteCoils() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	err = client.WriteCoils(0x0000, make([]bool, 1969))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.WriteCoils() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	err = client.WriteCoils(0xfffe, make([]bool, 3))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.WriteCoils() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// reads spanning multiple bytes should yield exactly quantity values
+	err = client.WriteCoils(0x0000, []bool{
+		true, false, false, true, true, false, true, false,
+		false, true,
+	})
+	if err != nil {
+		t.Errorf("client.WriteCoils() should have succeeded, got: %v", err)
+	}
+	coils, err = client.ReadCoils(0x0000, 10)
+	if err != nil {
+		t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 10 {
+		t.Errorf("expected 10 coils, got: %v", len(coils))
+	}
+	for i, v := range []bool{
+		true, false, false, true, true, false, true, false,
+		false, true,
+	} {
+		if coils[i] != v {
+			t.Errorf("expected coil at addr 0x%04x to be %v", i, v)
+		}
+	}
+
 	// switch to another unit ID and make sure both coil and discrete input operations
 	// return ErrIllegalFunction
 	client.SetUnitId(5)
 	err = client.WriteCoils(0x0005, []bool{
 		true, false, true, true,
 	})
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.WriteCoils() should have returned ErrIllegalFunction, got: %v", err)
 	}
 	err = client.WriteCoil(0x0005, false)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.WriteCoil() should have returned ErrIllegalFunction, got: %v", err)
 	}
 	_, err = client.ReadCoils(0x0005, 1)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.ReadCoils() should have returned ErrIllegalFunction, got: %v", err)
 	}
 	_, err = client.ReadDiscreteInputs(0x0005, 1)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.ReadDiscreteInputs() should have returned ErrIllegalFunction, got: %v", err)
 	}
 
@@ -431,14 +486,32 @@ func TestTCPServerHoldingAndInputRegisters(t *testing.T) {
 
 	// reading past address 0x000a should fail
 	_, err = client.ReadRegisters(0x0001, 10, INPUT_REGISTER)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("client.ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
 	}
 	_, err = client.ReadRegisters(0x0000, 11, INPUT_REGISTER)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("client.ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
 	}
 
+	// ReadInputRegisters() should yield the same values
+	regs, err = client.ReadInputRegisters(0x0008, 2)
+	if err != nil {
+		t.Errorf("client.ReadInputRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0xa718 || regs[1] != 0xa719 {
+		t.Errorf("expected {0xa718, 0xa719}, got: %v", regs)
+	}
+	_, err = client.ReadInputRegisters(0x0000, 126)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadInputRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadInputRegisters(0x0009, 2)
+	var ime *ModbusError
+	if !errors.As(err, &ime) || ime.FunctionCode != 0x04 || ime.ExceptionCode != 0x02 {
+		t.Errorf("client.ReadInputRegisters() should have returned a ModbusError (0x04/0x02), got: %v", err)
+	}
+
 	// all 10 holding registers should still be 0x0000
 	regs, err = client.ReadRegisters(0x0000, 10, HOLDING_REGISTER)
 	if err != nil {
@@ -470,6 +543,44 @@ func TestTCPServerHoldingAndInputRegisters(t *testing.T) {
 		}
 	}
 
+	// ReadHoldingRegisters() should yield the same values
+	regs, err = client.ReadHoldingRegisters(0x0007, 1)
+	if err != nil {
+		t.Errorf("client.ReadHoldingRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0xfea1 {
+		t.Errorf("expected {0xfea1}, got: %v", regs)
+	}
+
+	// quantities outside of 1..125 should be rejected without hitting the wire
+	_, err = client.ReadHoldingRegisters(0x0000, 0)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadHoldingRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadHoldingRegisters(0x0000, 126)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadHoldingRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// reading past the end of the register space should map to a typed error
+	_, err = client.ReadHoldingRegisters(0x0005, 6)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("client.ReadHoldingRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	var me *ModbusError
+	if !errors.As(err, &me) {
+		t.Fatalf("expected a ModbusError, got: %v", err)
+	}
+	if me.FunctionCode != 0x03 {
+		t.Errorf("expected function code 0x03, got: 0x%02x", me.FunctionCode)
+	}
+	if me.ExceptionCode != 0x02 {
+		t.Errorf("expected exception code 0x02, got: 0x%02x", me.ExceptionCode)
+	}
+	if me.Error() != "illegal data address" {
+		t.Errorf("unexpected error message: %v", me.Error())
+	}
+
 	// check values in the handler as well
 	for i := 0; i < 10; i++ {
 		if i != 7 && th.holding[i] != 0x0000 {
@@ -526,11 +637,11 @@ func TestTCPServerHoldingAndInputRegisters(t *testing.T) {
 
 	// reading past address 0x000a should fail
 	_, err = client.ReadRegisters(0x0001, 10, HOLDING_REGISTER)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("client.ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
 	}
 	_, err = client.ReadRegisters(0x0000, 11, HOLDING_REGISTER)
-	if err != ErrIllegalDataAddress {
+	if !errors.Is(err, ErrIllegalDataAddress) {
 		t.Errorf("client.ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
 	}
 
@@ -540,26 +651,255 @@ func TestTCPServerHoldingAndInputRegisters(t *testing.T) {
 	err = client.WriteRegisters(0x0005, []uint16{
 		0x0000, 0x0001,
 	})
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.WriteRegisters() should have returned ErrIllegalFunction, got: %v", err)
 	}
 	err = client.WriteRegister(0x0001, 0xffff)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.WriteRegister() should have returned ErrIllegalFunction, got: %v", err)
 	}
 	_, err = client.ReadRegisters(0x0005, 1, HOLDING_REGISTER)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.ReadRegisters() should have returned ErrIllegalFunction, got: %v", err)
 	}
 	_, err = client.ReadRegisters(0x0005, 1, INPUT_REGISTER)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("client.ReadRegisters() should have returned ErrIllegalFunction, got: %v", err)
 	}
 
+	// unit id handles should address their own unit id over the same
+	// connection, leaving the parent client's unit id untouched
+	client.SetUnitId(5)
+	_, err = client.ReadRegisters(0x0000, 1, HOLDING_REGISTER)
+	if !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("client.ReadRegisters() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	unit9 := client.WithUnitId(9)
+	regs, err = unit9.ReadRegisters(0x0007, 1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("unit9.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 {
+		t.Errorf("expected 1 register, got: %v", regs)
+	}
+
+	// 32-bit values should be decoded according to the selected encoding
+	client.SetUnitId(9)
+	err = client.WriteRegisters(0x0000, []uint16{0x8765, 0x4321, 0x4049, 0x0fdb})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+	for _, tc := range []struct {
+		layout     string
+		endianness Endianness
+		wordOrder  WordOrder
+		expected   uint32
+	}{
+		{"ABCD", BIG_ENDIAN, HIGH_WORD_FIRST, 0x87654321},
+		{"CDAB", BIG_ENDIAN, LOW_WORD_FIRST, 0x43218765},
+		{"BADC", LITTLE_ENDIAN, HIGH_WORD_FIRST, 0x65872143},
+		{"DCBA", LITTLE_ENDIAN, LOW_WORD_FIRST, 0x21436587},
+	} {
+		client.SetEncoding(tc.endianness, tc.wordOrder)
+		u32, err := client.ReadUint32(0x0000, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("client.ReadUint32() should have succeeded, got: %v", err)
+		}
+		if u32 != tc.expected {
+			t.Errorf("%s: expected 0x%08x, got: 0x%08x", tc.layout, tc.expected, u32)
+		}
+	}
+	client.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+	f32, err := client.ReadFloat32(0x0002, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadFloat32() should have succeeded, got: %v", err)
+	}
+	if f32 != float32(3.1415927) {
+		t.Errorf("expected 3.1415927, got: %v", f32)
+	}
+
+	// 64-bit values should round-trip with every encoding, spanning
+	// 4 registers laid out according to the selected encoding
+	for _, tc := range []struct {
+		layout     string
+		endianness Endianness
+		wordOrder  WordOrder
+		registers  []uint16
+	}{
+		{"ABCDEFGH", BIG_ENDIAN, HIGH_WORD_FIRST, []uint16{0x0102, 0x0304, 0x0506, 0x0708}},
+		{"GHEFCDAB", BIG_ENDIAN, LOW_WORD_FIRST, []uint16{0x0708, 0x0506, 0x0304, 0x0102}},
+		{"BADCFEHG", LITTLE_ENDIAN, HIGH_WORD_FIRST, []uint16{0x0201, 0x0403, 0x0605, 0x0807}},
+		{"HGFEDCBA", LITTLE_ENDIAN, LOW_WORD_FIRST, []uint16{0x0807, 0x0605, 0x0403, 0x0201}},
+	} {
+		client.SetEncoding(tc.endianness, tc.wordOrder)
+
+		err = client.WriteUint64(0x0000, 0x0102030405060708)
+		if err != nil {
+			t.Errorf("%s: client.WriteUint64() should have succeeded, got: %v", tc.layout, err)
+		}
+		for i, v := range tc.registers {
+			if th.holding[i] != v {
+				t.Errorf("%s: expected 0x%04x at position %v, got: 0x%04x",
+					tc.layout, v, i, th.holding[i])
+			}
+		}
+
+		u64, err := client.ReadUint64(0x0000, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("%s: client.ReadUint64() should have succeeded, got: %v", tc.layout, err)
+		}
+		if u64 != 0x0102030405060708 {
+			t.Errorf("%s: expected 0x0102030405060708, got: 0x%016x", tc.layout, u64)
+		}
+
+		err = client.WriteFloat64(0x0004, -1.23456789e-100)
+		if err != nil {
+			t.Errorf("%s: client.WriteFloat64() should have succeeded, got: %v", tc.layout, err)
+		}
+		f64, err := client.ReadFloat64(0x0004, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("%s: client.ReadFloat64() should have succeeded, got: %v", tc.layout, err)
+		}
+		if f64 != -1.23456789e-100 {
+			t.Errorf("%s: expected -1.23456789e-100, got: %v", tc.layout, f64)
+		}
+	}
+	client.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+
+	// requests made through a context handle should fail once the
+	// context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.WithContext(ctx).ReadRegisters(0x0007, 1, HOLDING_REGISTER)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadRegisters() should have returned context.Canceled, got: %v", err)
+	}
+
+	// closing the handle should not close the connection
+	err = unit9.Close()
+	if err != nil {
+		t.Errorf("unit9.Close() should have succeeded, got: %v", err)
+	}
+	_, err = client.WithUnitId(9).ReadRegisters(0x0007, 1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v", err)
+	}
+
 	client.Close()
 	server.Stop()
 }
 
+func TestTCPServerChunkedWriteRegisters(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var th *chunkTestHandler
+	var values []uint16
+	var chunkErr *ChunkError
+
+	th = &chunkTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5505",
+		MaxClients: 1,
+	}, th)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5505",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// 250 registers should be split into 3 requests (123 + 123 + 4)
+	for i := 0; i < 250; i++ {
+		values = append(values, 0x1000+uint16(i))
+	}
+
+	err = client.WriteRegisters(0x0001, values)
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+	if th.requests != 3 {
+		t.Errorf("expected 3 requests, saw: %v", th.requests)
+	}
+	for i := 0; i < 250; i++ {
+		if th.holding[1+i] != 0x1000+uint16(i) {
+			t.Errorf("expected 0x%04x at address %v, got: 0x%04x",
+				0x1000+uint16(i), 1+i, th.holding[1+i])
+		}
+	}
+
+	// the second request should fail as it spans past the end of the
+	// register space
+	th.requests = 0
+	err = client.WriteRegisters(100, values)
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("client.WriteRegisters() should have returned a ChunkError, got: %v", err)
+	}
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("expected ErrIllegalDataAddress to be wrapped, got: %v", chunkErr.Err)
+	}
+	if chunkErr.Done != 123 {
+		t.Errorf("expected 123 registers to have been written, got: %v", chunkErr.Done)
+	}
+	if chunkErr.Addr != 100+123 {
+		t.Errorf("expected the failed request to start at %v, got: %v",
+			100+123, chunkErr.Addr)
+	}
+	// no further request should have been sent after the failure
+	if th.requests != 2 {
+		t.Errorf("expected 2 requests, saw: %v", th.requests)
+	}
+
+	// errors on writes of 123 registers or less should not be wrapped
+	err = client.WriteRegisters(200, values[0:123])
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("client.WriteRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+}
+
+// Test handler exposing 300 holding registers and counting requests.
+type chunkTestHandler struct {
+	tcpTestHandler
+	holding  [300]uint16
+	requests int
+}
+
+func (th *chunkTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	th.requests++
+
+	if int(req.Addr)+int(req.Quantity) > len(th.holding) {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	for i := 0; i < int(req.Quantity); i++ {
+		if req.IsWrite {
+			th.holding[int(req.Addr)+i] = req.Args[i]
+		}
+		res = append(res, th.holding[int(req.Addr)+i])
+	}
+
+	return
+}
+
 type tcpTestHandler struct {
 	coils   [10]bool
 	di      [10]bool
@@ -648,3 +988,295 @@ func (th *tcpTestHandler) HandleInputRegisters(req *InputRegistersRequest) (res
 
 	return
 }
+
+// Handler stalling holding register requests, used to exercise graceful
+// shutdowns.
+type slowTestHandler struct {
+	*DataStore
+	started chan bool
+	delay   time.Duration
+}
+
+func (sh *slowTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	sh.started <- true
+	time.Sleep(sh.delay)
+
+	return sh.DataStore.HandleHoldingRegisters(req)
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	var server *ModbusServer
+	var c1, c2 *ModbusClient
+	var err error
+	var errChan chan error = make(chan error, 1)
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	// long enough to outlast the short grace period below, short enough to
+	// fit in the long one
+	sh := &slowTestHandler{
+		DataStore: NewDataStore(0, 0, 10, 0),
+		started:   make(chan bool, 1),
+		delay:     500 * time.Millisecond,
+	}
+	sh.SetHoldingRegisters(0, []uint16{0x1234})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL: "tcp://localhost:5509",
+	}, sh)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	c1, _ = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5509",
+		Timeout: 2 * time.Second,
+	})
+	c2, _ = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5509",
+		Timeout: 2 * time.Second,
+	})
+	for _, c := range []*ModbusClient{c1, c2} {
+		err = c.Open()
+		if err != nil {
+			t.Fatalf("failed to open client: %v", err)
+		}
+		defer c.Close()
+	}
+
+	// c1 issues a request, c2 remains idle
+	go func() {
+		reg, err := c1.ReadRegister(0, HOLDING_REGISTER)
+		if err == nil && reg != 0x1234 {
+			err = fmt.Errorf("unexpected value 0x%04x", reg)
+		}
+		errChan <- err
+	}()
+	<-sh.started
+
+	// the in-flight request should complete before Shutdown() returns
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("Shutdown() should have succeeded, got: %v", err)
+	}
+
+	// the response was written before Shutdown() returned, but the client
+	// may not have read it yet
+	select {
+	case err = <-errChan:
+		if err != nil {
+			t.Errorf("in-flight request should have succeeded, got: %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("in-flight request should have completed")
+	}
+
+	// both connections should now be closed
+	_, err = c2.ReadRegister(0, HOLDING_REGISTER)
+	if err == nil {
+		t.Errorf("requests should fail after shutdown")
+	}
+	if len(server.tcpClients) != 0 {
+		t.Errorf("expected 0 client connections, saw: %v", len(server.tcpClients))
+	}
+
+	// busy connections should be closed forcibly once the grace period
+	// expires
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	err = c1.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+
+	go func() {
+		_, err := c1.ReadRegister(0, HOLDING_REGISTER)
+		errChan <- err
+	}()
+	<-sh.started
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() should have returned context.DeadlineExceeded, got: %v", err)
+	}
+
+	err = <-errChan
+	if err == nil {
+		t.Errorf("in-flight request should have failed")
+	}
+}
+
+func TestTCPServerMaxConcurrentRequests(t *testing.T) {
+	var server *ModbusServer
+	var c1, c2 *ModbusClient
+	var err error
+	var errChan chan error = make(chan error, 1)
+
+	sh := &slowTestHandler{
+		DataStore: NewDataStore(0, 0, 10, 0),
+		started:   make(chan bool, 1),
+		delay:     200 * time.Millisecond,
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:                   "tcp://localhost:5510",
+		MaxConcurrentRequests: 1,
+	}, sh)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	c1, _ = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5510",
+		Timeout: 1 * time.Second,
+	})
+	c2, _ = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5510",
+		Timeout: 1 * time.Second,
+	})
+	for _, c := range []*ModbusClient{c1, c2} {
+		err = c.Open()
+		if err != nil {
+			t.Fatalf("failed to open client: %v", err)
+		}
+		defer c.Close()
+	}
+
+	go func() {
+		_, err := c1.ReadRegister(0, HOLDING_REGISTER)
+		errChan <- err
+	}()
+	<-sh.started
+
+	// the handler is busy serving c1: c2 should be turned away
+	_, err = c2.ReadRegister(0, HOLDING_REGISTER)
+	if !errors.Is(err, ErrServerDeviceBusy) {
+		t.Errorf("expected ErrServerDeviceBusy, got: %v", err)
+	}
+
+	err = <-errChan
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	// the slot should be available again
+	go func() { <-sh.started }()
+	_, err = c2.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+}
+
+func TestTCPServerUnsupportedFunctionCode(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+	var fc uint8
+	var payload []byte
+
+	server, err = NewServer(&ServerConfiguration{
+		URL: "tcp://localhost:5511",
+	}, NewDataStore(0, 0, 10, 0))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, _ = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5511",
+		Timeout: 1 * time.Second,
+	})
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// unknown function codes should be answered with an illegal function
+	// exception
+	fc, payload, err = client.ExecuteRaw(1, 0x41, []byte{0x01, 0x02})
+	if !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	if fc != 0xc1 || !bytes.Equal(payload, []byte{0x01}) {
+		t.Errorf("unexpected response: fc 0x%02x, payload % x", fc, payload)
+	}
+
+	// and the connection should remain usable
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+}
+
+func TestTCPServerSlowHandler(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+	var reg uint16
+
+	sh := &slowTestHandler{
+		DataStore: NewDataStore(0, 0, 10, 0),
+		started:   make(chan bool, 1),
+		delay:     300 * time.Millisecond,
+	}
+	sh.SetHoldingRegisters(0, []uint16{0x1234})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:     "tcp://localhost:5514",
+		Timeout: 100 * time.Millisecond,
+	}, sh)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, _ = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5514",
+		Timeout: 1 * time.Second,
+	})
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// the handler takes longer than the server timeout: the response should
+	// still make it through
+	go func() { <-sh.started }()
+	reg, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadRegister() should have succeeded, got: %v", err)
+	}
+	if reg != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x", reg)
+	}
+}
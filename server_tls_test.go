@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"testing"
 	"time"
 )
@@ -224,7 +225,7 @@ func TestTLSServer(t *testing.T) {
 	// client #2 (with 'operator2' role) should have read/write access to coils while
 	// client #1 (without role) should only be able to read.
 	err = c1.WriteCoil(0, true)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("c1.WriteCoil() should have failed with %v, got: %v",
 			ErrIllegalFunction, err)
 	}
@@ -268,7 +269,7 @@ func TestTLSServer(t *testing.T) {
 
 	c1.SetUnitId(4)
 	err = c1.WriteRegister(2, 200)
-	if err != ErrIllegalFunction {
+	if !errors.Is(err, ErrIllegalFunction) {
 		t.Errorf("c1.WriteRegister() should have failed with %v, got: %v",
 			ErrIllegalFunction, err)
 	}
@@ -1,67 +1,649 @@
 package modbus
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	maxTCPFrameLength int = 260
 	mbapHeaderLength  int = 7
+	// protocol identifier of modbus frames
+	mbapProtocolId uint16 = 0x0000
+	// largest frame the MBAP length field can describe
+	maxExtendedTCPFrameLength int = mbapHeaderLength - 1 + 0xffff
+	// default number of non-matching frames skipped while waiting for a
+	// response
+	defaultMaxSkippedFrames int = 16
 )
 
+// Pool of receive buffers, shared by all TCP transports to avoid allocating
+// on each frame read.
+var tcpRxBufPool = sync.Pool{
+	New: func() any {
+		return new([maxTCPFrameLength]byte)
+	},
+}
+
 type tcpTransport struct {
+	timeouts
 	logger    *logger
+	lock      sync.Mutex
 	socket    net.Conn
-	timeout   time.Duration
 	lastTxnId uint16
+	retry     RetryConfig
+	redial    func() (net.Conn, error)
+	// if set, called with a copy of every raw frame received
+	rawFrameFunc func(frame []byte)
+	// if set, called with every frame sent or received
+	traceFunc atomic.Pointer[func(direction Direction, frame []byte)]
+	// if set, decides whether frames with unexpected transaction ids are
+	// skipped (serialized mode only, skipped if nil)
+	skipTxnId func(expected uint16, received uint16) bool
+	// number of non-matching frames skipped before giving up on a response
+	// (defaultMaxSkippedFrames if 0)
+	maxSkippedFrames int
+	// if set, time allowed for a frame to be received in full once its
+	// first byte has arrived (server side)
+	readTimeout time.Duration
+	// protocol id of frames sent and expected (mbapProtocolId unless
+	// tunneling)
+	protocolId uint16
+	// if set, 1-byte responses to function codes expecting more are treated
+	// as exceptions
+	tolerantExceptions bool
+	// notified of frames whose MBAP length field leaves trailing bytes
+	// after the PDU, if implementing FrameLengthObserver
+	observer Observer
+	// most recent frames, shared with the client (may be nil)
+	history *frameHistory
+	// number of times requests are sent again when left unanswered
+	// (datagram sockets only)
+	retransmits int
+	// maximum accepted frame length, if above maxTCPFrameLength
+	maxFrameLength atomic.Int32
+	// connection lifecycle: connections older than maxLifetime are replaced
+	// ahead of the next request, and released after each request if
+	// releaseConn is set (serialized mode only)
+	connectedAt time.Time
+	maxLifetime time.Duration
+	releaseConn bool
+	// set when the socket was closed and must be re-dialed before use
+	dropped bool
+	// guards socket replacements along with the fields below, so that
+	// Close() can reach the socket without waiting for the request in
+	// progress to complete
+	sockLock   sync.Mutex
+	sockClosed bool
+	closing    bool
+	// closed by Close(), to stop requests from retrying
+	closed chan struct{}
+	// connections left unused for idleTimeout are closed until the next
+	// request (serialized mode only)
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	lastRequest time.Time
+	// traffic counters
+	bytesSent      atomic.Uint64
+	bytesReceived  atomic.Uint64
+	framesSent     atomic.Uint64
+	framesReceived atomic.Uint64
+
+	// pipelined mode
+	pipelined bool
+	pending   map[uint16]chan *pipelinedResponse
+	reading   bool
+
+	// keep-alive probes
+	keepAlive     KeepAliveConfig
+	lastActivity  time.Time
+	stopKeepAlive context.CancelFunc
+}
+
+// Response (or error) dispatched to a pending pipelined request.
+type pipelinedResponse struct {
+	res *pdu
+	err error
 }
 
 // Returns a new TCP transport.
-func newTCPTransport(socket net.Conn, timeout time.Duration, customLogger *log.Logger) *tcpTransport {
+func newTCPTransport(socket net.Conn, timeout time.Duration, parentLogger *logger) *tcpTransport {
 	return &tcpTransport{
-		socket:  socket,
-		timeout: timeout,
-		logger:  newLogger(fmt.Sprintf("tcp-transport(%s)", socket.RemoteAddr()), customLogger),
+		timeouts:    timeouts{timeout: timeout},
+		socket:      socket,
+		connectedAt: time.Now(),
+		closed:      make(chan struct{}),
+		logger:      parentLogger.derive(fmt.Sprintf("tcp-transport(%s)", socket.RemoteAddr())),
 	}
 }
 
-// Closes the underlying tcp socket.
+// Closes the underlying tcp socket, aborting the request in progress if any.
 func (tt *tcpTransport) Close() error {
+	if tt.idleTimer != nil {
+		tt.idleTimer.Stop()
+	}
+
+	if tt.stopKeepAlive != nil {
+		tt.stopKeepAlive()
+	}
+
+	// stop requests from retrying or re-dialing
+	tt.sockLock.Lock()
+	if !tt.closing {
+		tt.closing = true
+		close(tt.closed)
+	}
+	tt.sockLock.Unlock()
+
+	// close the socket right away to abort the request in progress, if any,
+	// then wait for it to complete
+	err := tt.closeSocket()
+
+	tt.lock.Lock()
+	tt.dropped = true
+	tt.lock.Unlock()
+
+	return err
+}
+
+// Closes the current socket, unless already closed.
+func (tt *tcpTransport) closeSocket() error {
+	tt.sockLock.Lock()
+	defer tt.sockLock.Unlock()
+
+	if tt.sockClosed {
+		return nil
+	}
+	tt.sockClosed = true
+
 	return tt.socket.Close()
 }
 
 // Runs a request across the socket and returns a response.
 func (tt *tcpTransport) ExecuteRequest(req *pdu) (*pdu, error) {
-	// set an i/o deadline on the socket (read and write)
-	err := tt.socket.SetDeadline(time.Now().Add(tt.timeout))
-	if err != nil {
-		return nil, err
+	return tt.ExecuteRequestContext(context.Background(), req)
+}
+
+// Runs a request across the socket and returns a response, aborting
+// in-flight i/o as soon as ctx is done (in which case ctx.Err() is returned).
+// The context deadline, if any, is used instead of the transport timeout.
+// If a retry policy is set and the connection drops mid-request, the
+// connection is re-established and the request sent again (with the same
+// transaction id) until either a response is received or the maximum
+// number of attempts is reached.
+// Safe for concurrent use: requests are serialized, each one holding the
+// socket until its response (or an error) is received, unless pipelined
+// mode is enabled.
+func (tt *tcpTransport) ExecuteRequestContext(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var backoff time.Duration
+
+	// don't bother sending anything if the context is already done
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if tt.pipelined {
+		return tt.executePipelinedRequest(ctx, req)
 	}
+
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	// keep track of activity to only send keep-alive probes on idle
+	// connections
+	defer func() {
+		tt.lastActivity = time.Now()
+		tt.lastRequest = tt.lastActivity
+		if tt.idleTimer != nil {
+			tt.idleTimer.Reset(tt.idleTimeout)
+		}
+	}()
+
 	// increase the transaction ID counter
 	tt.lastTxnId++
-	_, err = tt.socket.Write(tt.assembleMBAPFrame(tt.lastTxnId, req))
+
+	err = tt.acquireConn()
 	if err != nil {
 		return nil, err
 	}
-	return tt.readResponse()
+	if tt.releaseConn {
+		defer tt.releaseConnection()
+	}
+
+	backoff = tt.retry.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		res, err = tt.runRequest(ctx, req)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// the socket deadline may fire slightly ahead of the context one
+		if _, ok := ctx.Deadline(); ok && os.IsTimeout(err) {
+			return nil, context.DeadlineExceeded
+		}
+		if err == nil || attempt >= tt.retry.MaxAttempts || !isConnectionError(err) {
+			return
+		}
+
+		tt.logger.Warningf("connection lost (%v), reconnecting in %v "+
+			"(attempt %v of %v)", err, backoff, attempt+1, tt.retry.MaxAttempts)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-tt.closed:
+			return
+		}
+
+		// double the delay between attempts, up to MaxBackoff
+		backoff *= 2
+		if tt.retry.MaxBackoff > 0 && backoff > tt.retry.MaxBackoff {
+			backoff = tt.retry.MaxBackoff
+		}
+
+		err = tt.reconnect()
+		if err != nil {
+			tt.logger.Warningf("failed to reconnect: %v", err)
+		}
+	}
+}
+
+// Makes sure the transport holds a usable connection, dialing a new one if
+// the previous one was dropped or has outlived maxLifetime.
+// Note: expects tt.lock to be held by the caller.
+func (tt *tcpTransport) acquireConn() error {
+	if !tt.dropped &&
+		(tt.maxLifetime <= 0 || time.Since(tt.connectedAt) < tt.maxLifetime) {
+		return nil
+	}
+
+	if !tt.dropped {
+		tt.logger.Debugf("connection reached its maximum lifetime, reconnecting")
+	}
+
+	return tt.reconnect()
+}
+
+// Closes the connection until the next request, handing it back to its
+// pool if it was obtained from one.
+// Note: expects tt.lock to be held by the caller.
+func (tt *tcpTransport) releaseConnection() {
+	if !tt.dropped {
+		tt.closeSocket()
+		tt.dropped = true
+	}
+}
+
+// Closes the connection once it has been left unused for idleTimeout, until
+// the next request (serialized mode only).
+func (tt *tcpTransport) startIdleTimer(idleTimeout time.Duration) {
+	tt.idleTimeout = idleTimeout
+	tt.lastRequest = time.Now()
+	tt.idleTimer = time.AfterFunc(idleTimeout, tt.closeIdleConn)
+}
+
+// Closes the connection if no request was made on it for idleTimeout.
+// Keep-alive probes do not count as requests.
+func (tt *tcpTransport) closeIdleConn() {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	// a request may have completed while waiting for the lock
+	if tt.dropped || time.Since(tt.lastRequest) < tt.idleTimeout {
+		return
+	}
+
+	tt.logger.Debugf("connection idle for %v, closing", tt.idleTimeout)
+	tt.releaseConnection()
+}
+
+// Sends a request over the socket using the current transaction id and
+// waits for the matching response.
+// On datagram sockets, unanswered requests are sent again up to
+// tt.retransmits times, the time left until the deadline being split evenly
+// between remaining attempts. Late responses to earlier attempts carry the
+// same transaction id and are accepted just the same.
+func (tt *tcpTransport) runRequest(ctx context.Context, req *pdu) (*pdu, error) {
+	var sock net.Conn = tt.socket
+	var deadline time.Time = tt.deadline(ctx, req.unitId)
+	var frame []byte = tt.assembleMBAPFrame(tt.lastTxnId, req)
+	var attempts int = 1
+
+	if _, ok := sock.(datagramSocket); ok {
+		attempts += tt.retransmits
+	}
+
+	// unblock pending i/o as soon as the context is cancelled
+	stop := deadlineOnDone(ctx, sock.SetDeadline)
+	defer stop()
+
+	for attempt := 1; ; attempt++ {
+		// set an i/o deadline on the socket (read and write)
+		attemptDeadline := deadline
+		if attempt < attempts {
+			attemptDeadline = time.Now().Add(
+				time.Until(deadline) / time.Duration(attempts-attempt+1))
+		}
+		err := sock.SetDeadline(attemptDeadline)
+		if err != nil {
+			return nil, wrapIOError(err)
+		}
+
+		err = tt.writeFrame(sock, frame)
+		if err != nil {
+			return nil, wrapIOError(err)
+		}
+
+		res, err := tt.readResponse(req.unitId)
+		if attempt >= attempts || !os.IsTimeout(err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		tt.logger.Warningf("no response to transaction id 0x%04x, retransmitting "+
+			"(attempt %v of %v)", tt.lastTxnId, attempt+1, attempts)
+	}
+}
+
+// Returns the context deadline if any, or the timeout applicable to unitId
+// from now.
+func (tt *tcpTransport) deadline(ctx context.Context, unitId uint8) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(tt.timeoutFor(unitId))
+}
+
+// Sends a request over the socket without waiting for previously sent
+// requests to complete, then waits for the response bearing the same
+// transaction id to be dispatched by the background reader.
+// Note that the retry policy does not apply in pipelined mode.
+func (tt *tcpTransport) executePipelinedRequest(ctx context.Context, req *pdu) (*pdu, error) {
+	var txnId uint16
+	var resChan chan *pipelinedResponse
+	var timer *time.Timer
+
+	tt.lock.Lock()
+
+	// pick the next transaction id not currently in flight
+	for {
+		tt.lastTxnId++
+		if _, inFlight := tt.pending[tt.lastTxnId]; !inFlight {
+			break
+		}
+	}
+	txnId = tt.lastTxnId
+
+	// register the request before sending it, as its response may well
+	// arrive before Write() returns
+	resChan = make(chan *pipelinedResponse, 1)
+	if tt.pending == nil {
+		tt.pending = make(map[uint16]chan *pipelinedResponse)
+	}
+	tt.pending[txnId] = resChan
+
+	// start the background reader if it isn't running yet
+	if !tt.reading {
+		tt.reading = true
+		go tt.dispatchResponses()
+	}
+
+	err := tt.socket.SetWriteDeadline(tt.deadline(ctx, req.unitId))
+	if err == nil {
+		err = tt.writeFrame(tt.socket, tt.assembleMBAPFrame(txnId, req))
+	}
+	if err != nil {
+		delete(tt.pending, txnId)
+		tt.lock.Unlock()
+		return nil, wrapIOError(err)
+	}
+
+	tt.lock.Unlock()
+
+	timer = time.NewTimer(time.Until(tt.deadline(ctx, req.unitId)))
+	defer timer.Stop()
+
+	select {
+	case pr := <-resChan:
+		return pr.res, pr.err
+	case <-timer.C:
+		err = os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// favour the context error should both expire at the same time
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	tt.lock.Lock()
+	delete(tt.pending, txnId)
+	tt.lock.Unlock()
+
+	return nil, err
+}
+
+// Reads frames off the socket and dispatches them to pending requests by
+// transaction id. Runs until a read error occurs, at which point all
+// pending requests are failed with that error.
+func (tt *tcpTransport) dispatchResponses() {
+	var (
+		res     *pdu
+		txnId   uint16
+		err     error
+		resChan chan *pipelinedResponse
+		found   bool
+	)
+
+	// responses may take any amount of time to come back
+	tt.socket.SetReadDeadline(time.Time{})
+
+	for {
+		res, txnId, err = tt.readMBAPFrame()
+		// ignore unknown protocol identifiers
+		if errors.Is(err, ErrUnknownProtocolId) {
+			continue
+		}
+
+		tt.lock.Lock()
+		if err != nil {
+			// fail all pending requests and stop
+			for id, c := range tt.pending {
+				c <- &pipelinedResponse{err: err}
+				delete(tt.pending, id)
+			}
+			tt.reading = false
+			tt.lock.Unlock()
+			return
+		}
+
+		resChan, found = tt.pending[txnId]
+		if found {
+			delete(tt.pending, txnId)
+		}
+		tt.lock.Unlock()
+
+		if !found {
+			// most likely a late response to a request which timed out
+			tt.logger.Warningw(fmt.Sprintf("received unexpected transaction id 0x%04x",
+				txnId), map[string]any{
+				"received_txn_id": txnId,
+			})
+			continue
+		}
+
+		err = tt.checkResponseLength(res)
+		if err != nil {
+			resChan <- &pipelinedResponse{err: err}
+			continue
+		}
+
+		resChan <- &pipelinedResponse{res: res}
+	}
+}
+
+// Starts sending probes on the connection whenever it has been idle for
+// keepAlive.Interval, until the transport is closed (serialized mode only).
+func (tt *tcpTransport) startKeepAlive(keepAlive KeepAliveConfig) {
+	var ctx context.Context
+
+	tt.keepAlive = keepAlive
+	tt.lastActivity = time.Now()
+	ctx, tt.stopKeepAlive = context.WithCancel(context.Background())
+
+	go tt.runKeepAlive(ctx)
+}
+
+// Waits for the connection to go idle and probes it, until ctx is done.
+func (tt *tcpTransport) runKeepAlive(ctx context.Context) {
+	var timer *time.Timer = time.NewTimer(tt.keepAlive.Interval)
+	var idle time.Duration
+
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		tt.lock.Lock()
+		idle = time.Since(tt.lastActivity)
+		// released connections have nothing to probe
+		if tt.dropped {
+			idle = 0
+		} else if idle >= tt.keepAlive.Interval && ctx.Err() == nil {
+			tt.probe(ctx)
+			idle = 0
+		}
+		tt.lock.Unlock()
+
+		timer.Reset(tt.keepAlive.Interval - idle)
+	}
+}
+
+// Sends a probe request and re-establishes the connection if it fails.
+// Note: expects tt.lock to be held by the caller.
+func (tt *tcpTransport) probe(ctx context.Context) {
+	var req *pdu = &pdu{}
+
+	req.unitId, req.functionCode, req.payload = tt.keepAlive.Probe()
+
+	tt.lastTxnId++
+	_, err := tt.runRequest(ctx, req)
+	tt.lastActivity = time.Now()
+
+	// don't bother reconnecting if the transport is being closed
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	tt.logger.Warningf("keep-alive probe failed (%v), reconnecting", err)
+	err = tt.reconnect()
+	if err != nil {
+		tt.logger.Warningf("failed to reconnect: %v", err)
+	}
+}
+
+// Closes the socket and replaces it with a fresh connection to the same
+// remote host.
+func (tt *tcpTransport) reconnect() error {
+	var sock net.Conn
+	var err error
+
+	if !tt.dropped {
+		tt.closeSocket()
+		tt.dropped = true
+	}
+
+	tt.sockLock.Lock()
+	closing := tt.closing
+	tt.sockLock.Unlock()
+	if closing {
+		return net.ErrClosed
+	}
+
+	if tt.redial != nil {
+		sock, err = tt.redial()
+	} else {
+		addr := tt.socket.RemoteAddr()
+		sock, err = net.DialTimeout(addr.Network(), addr.String(), tt.defaultTimeout())
+	}
+	if err != nil {
+		return err
+	}
+
+	tt.sockLock.Lock()
+	defer tt.sockLock.Unlock()
+
+	// the transport may have been closed while dialing
+	if tt.closing {
+		sock.Close()
+		return net.ErrClosed
+	}
+
+	tt.socket = sock
+	tt.sockClosed = false
+	tt.connectedAt = time.Now()
+	tt.dropped = false
+	return nil
+}
+
+// Sets an immediate deadline through setDeadline as soon as ctx is done, to
+// unblock pending i/o. The returned function cancels this. Should the
+// deadline be in the process of being set, it waits for it and clears the
+// deadline so that it cannot fail subsequent i/o.
+func deadlineOnDone(ctx context.Context, setDeadline func(time.Time) error) (stop func()) {
+	var done chan struct{} = make(chan struct{})
+
+	stopFunc := context.AfterFunc(ctx, func() {
+		setDeadline(time.Now())
+		close(done)
+	})
+
+	return func() {
+		if !stopFunc() {
+			<-done
+			setDeadline(time.Time{})
+		}
+	}
 }
 
 // Reads a request from the socket.
 func (tt *tcpTransport) ReadRequest() (*pdu, error) {
+	return tt.ReadRequestContext(context.Background())
+}
+
+// Reads a request from the socket, giving up as soon as ctx is done (in
+// which case ctx.Err() is returned).
+func (tt *tcpTransport) ReadRequestContext(ctx context.Context) (*pdu, error) {
 	var txnId uint16
 
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
 	// set an i/o deadline on the socket (read and write)
-	err := tt.socket.SetDeadline(time.Now().Add(tt.timeout))
+	err := tt.socket.SetDeadline(time.Now().Add(tt.defaultTimeout()))
 	if err != nil {
 		return nil, err
 	}
 
+	// unblock the read as soon as the context is cancelled
+	stop := deadlineOnDone(ctx, tt.socket.SetReadDeadline)
+	defer stop()
+
 	req, txnId, err := tt.readMBAPFrame()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -72,21 +654,71 @@ func (tt *tcpTransport) ReadRequest() (*pdu, error) {
 }
 
 // Writes a response to the socket.
+// The write deadline is refreshed first, as the deadline set when reading the
+// request may have expired while the request was being handled.
 func (tt *tcpTransport) WriteResponse(res *pdu) error {
-	_, err := tt.socket.Write(tt.assembleMBAPFrame(tt.lastTxnId, res))
+	err := tt.socket.SetWriteDeadline(time.Now().Add(tt.defaultTimeout()))
+	if err != nil {
+		return err
+	}
+
+	return tt.writeFrame(tt.socket, tt.assembleMBAPFrame(tt.lastTxnId, res))
+}
+
+// Writes a frame to sock, keeping track of traffic.
+func (tt *tcpTransport) writeFrame(sock net.Conn, frame []byte) error {
+	tt.trace(DIRECTION_TX, frame)
+
+	n, err := sock.Write(frame)
+	tt.bytesSent.Add(uint64(n))
+	if err == nil {
+		tt.framesSent.Add(1)
+	}
+
 	return err
 }
 
+// Returns the addresses of both ends of the connection.
+func (tt *tcpTransport) addrs() (local net.Addr, remote net.Addr) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	return tt.socket.LocalAddr(), tt.socket.RemoteAddr()
+}
+
+// Returns traffic counters.
+func (tt *tcpTransport) stats() ConnStats {
+	return ConnStats{
+		BytesSent:      tt.bytesSent.Load(),
+		BytesReceived:  tt.bytesReceived.Load(),
+		FramesSent:     tt.framesSent.Load(),
+		FramesReceived: tt.framesReceived.Load(),
+	}
+}
+
 // Reads as many MBAP+modbus frames as necessary until either the response
-// matching tt.lastTxnId is received or an error occurs.
-func (tt *tcpTransport) readResponse() (*pdu, error) {
+// matching tt.lastTxnId and unitId is received or an error occurs.
+func (tt *tcpTransport) readResponse(unitId uint8) (*pdu, error) {
 	var (
-		res   *pdu
-		txnId uint16
-		err   error
+		res     *pdu
+		txnId   uint16
+		err     error
+		skipped int
 	)
 
-	for {
+	maxSkipped := tt.maxSkippedFrames
+	if maxSkipped == 0 {
+		maxSkipped = defaultMaxSkippedFrames
+	}
+
+	for ; ; skipped++ {
+		// give up rather than spin until the deadline if the peer keeps
+		// sending frames we're not interested in
+		if skipped > maxSkipped {
+			tt.logger.Warningf("skipped too many non-matching frames (%v)", skipped)
+			return nil, ErrTooManyMismatchedFrames
+		}
+
 		// grab a frame
 		res, txnId, err = tt.readMBAPFrame()
 		// ignore unknown protocol identifiers
@@ -99,13 +731,37 @@ func (tt *tcpTransport) readResponse() (*pdu, error) {
 		}
 		// ignore unknown transaction identifiers
 		if tt.lastTxnId != txnId {
-			tt.logger.Warningf("received unexpected transaction id "+
+			tt.logger.Warningw(fmt.Sprintf("received unexpected transaction id "+
 				"(expected 0x%04x, received 0x%04x)",
-				tt.lastTxnId, txnId)
+				tt.lastTxnId, txnId), map[string]any{
+				"expected_txn_id": tt.lastTxnId,
+				"received_txn_id": txnId,
+			})
+			if tt.skipTxnId != nil && !tt.skipTxnId(tt.lastTxnId, txnId) {
+				return nil, ErrBadTransactionId
+			}
+			continue
+		}
+		// ignore responses from other units, but accept errors from
+		// gateway devices (using special unit id #255)
+		if res.unitId != unitId &&
+			!((res.functionCode&0x80) == 0x80 && res.unitId == 0xff) {
+			tt.logger.Warningw(fmt.Sprintf("received unexpected unit id "+
+				"(expected 0x%02x, received 0x%02x)",
+				unitId, res.unitId), map[string]any{
+				"expected_unit_id": unitId,
+				"received_unit_id": res.unitId,
+			})
 			continue
 		}
 		break
 	}
+
+	err = tt.checkResponseLength(res)
+	if err != nil {
+		return nil, err
+	}
+
 	return res, nil
 }
 
@@ -114,21 +770,44 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 	var (
 		bytesNeeded int
 		protocolId  uint16
-		unitId      uint8
 	)
+	pooledBuf := tcpRxBufPool.Get().(*[maxTCPFrameLength]byte)
+	defer tcpRxBufPool.Put(pooledBuf)
+	rxbuf := pooledBuf[:]
+
+	// on datagram sockets, frames must fit in a single datagram
+	if ds, ok := tt.socket.(datagramSocket); ok {
+		ds.startFrame()
+	}
+
+	// wait for the first byte of the frame, then give the rest of it
+	// readTimeout to arrive
+	headerStart := 0
+	if tt.readTimeout > 0 {
+		n, err := io.ReadFull(tt.socket, rxbuf[0:1])
+		tt.bytesReceived.Add(uint64(n))
+		if err != nil {
+			return nil, 0, wrapIOError(err)
+		}
+
+		err = tt.socket.SetReadDeadline(time.Now().Add(tt.readTimeout))
+		if err != nil {
+			return nil, 0, err
+		}
+		headerStart = 1
+	}
+
 	// read the MBAP header
-	rxbuf := make([]byte, mbapHeaderLength)
-	_, err := io.ReadFull(tt.socket, rxbuf)
+	n, err := io.ReadFull(tt.socket, rxbuf[headerStart:mbapHeaderLength])
+	tt.bytesReceived.Add(uint64(n))
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, wrapIOError(err)
 	}
 
 	// decode the transaction identifier
 	txnId := bytesToUint16(BIG_ENDIAN, rxbuf[0:2])
 	// decode the protocol identifier
 	protocolId = bytesToUint16(BIG_ENDIAN, rxbuf[2:4])
-	// store the source unit id
-	unitId = rxbuf[6]
 
 	// determine how many more bytes we need to read
 	bytesNeeded = int(bytesToUint16(BIG_ENDIAN, rxbuf[4:6]))
@@ -137,7 +816,7 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 	bytesNeeded--
 
 	// never read more than the max allowed frame length
-	if bytesNeeded+mbapHeaderLength > maxTCPFrameLength {
+	if bytesNeeded+mbapHeaderLength > tt.frameLengthLimit() {
 		return nil, 0, ErrProtocol
 	}
 
@@ -146,39 +825,170 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 		return nil, 0, ErrProtocol
 	}
 
+	// oversized frames (see setMaxFrameLength()) don't fit in pooled buffers
+	if bytesNeeded+mbapHeaderLength > len(rxbuf) {
+		rxbuf = make([]byte, mbapHeaderLength+bytesNeeded)
+		copy(rxbuf, pooledBuf[0:mbapHeaderLength])
+	}
+
 	// read the PDU
-	rxbuf = make([]byte, bytesNeeded)
-	_, err = io.ReadFull(tt.socket, rxbuf)
+	n, err = io.ReadFull(tt.socket, rxbuf[mbapHeaderLength:mbapHeaderLength+bytesNeeded])
+	tt.bytesReceived.Add(uint64(n))
+	if err != nil {
+		if os.IsTimeout(err) {
+			tt.logger.Warningf("timed out waiting for the end of the frame "+
+				"(expected %v bytes, received %v)", bytesNeeded, n)
+		}
+		return nil, 0, wrapIOError(err)
+	}
+
+	tt.framesReceived.Add(1)
+
+	tt.trace(DIRECTION_RX, rxbuf[0:mbapHeaderLength+bytesNeeded])
+
+	// hand a copy of the raw frame to the debug hook, if any
+	if tt.rawFrameFunc != nil {
+		tt.rawFrameFunc(append([]byte(nil),
+			rxbuf[0:mbapHeaderLength+bytesNeeded]...))
+	}
+
+	// decode the frame (copying the payload out of rxbuf, which goes back
+	// to the pool)
+	p, err := decodeMBAPFrame(rxbuf[0:mbapHeaderLength+bytesNeeded], tt.protocolId)
+	if errors.Is(err, ErrUnknownProtocolId) {
+		tt.logger.Warningw(fmt.Sprintf("received unexpected protocol id 0x%04x",
+			protocolId), map[string]any{
+			"protocol_id": protocolId,
+			"txn_id":      txnId,
+		})
+	}
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// validate the protocol identifier
-	if protocolId != 0x0000 {
-		tt.logger.Warningf("received unexpected protocol id 0x%04x", protocolId)
-		return nil, 0, ErrUnknownProtocolId
+	return p, p.txnId, nil
+}
+
+// Makes sure the length of a response, as declared by the MBAP header, is
+// consistent with its function code and byte count field (if any).
+// Responses to function codes with no known length are left alone.
+func (tt *tcpTransport) checkResponseLength(res *pdu) error {
+	var byteCount int
+	var err error
+
+	if len(res.payload) > 0 {
+		byteCount, err = expectedResponseLenth(res.functionCode, res.payload[0])
+	} else {
+		byteCount, err = expectedResponseLenth(res.functionCode, 0)
+	}
+	if err != nil {
+		// unknown function code
+		return nil
+	}
+
+	// some devices flag exceptions by appending the exception code to the
+	// request function code, without setting the exception bit
+	if len(res.payload) == 1 && byteCount != 0 && tt.tolerantExceptions {
+		tt.logger.Warningf("treating 1-byte response to function code 0x%02x "+
+			"as exception code 0x%02x", res.functionCode, res.payload[0])
+		res.functionCode |= 0x80
+		return nil
+	}
+
+	// a length field covering more than a well-formed PDU is an early sign
+	// of a desynced stream
+	if len(res.payload) > 1+byteCount {
+		tt.logger.Warningw(fmt.Sprintf("MBAP length field leaves %v trailing "+
+			"bytes after the PDU, stream may be desynced (function code 0x%02x, "+
+			"declared length %v, expected %v)", len(res.payload)-1-byteCount,
+			res.functionCode, 2+len(res.payload), 3+byteCount), map[string]any{
+			"function_code":   res.functionCode,
+			"declared_length": 2 + len(res.payload),
+			"expected_length": 3 + byteCount,
+		})
+		if fo, ok := tt.observer.(FrameLengthObserver); ok {
+			fo.FrameLengthMismatch(res.functionCode, 2+len(res.payload), 3+byteCount)
+		}
+		return ErrProtocol
+	}
+
+	if len(res.payload) != 1+byteCount {
+		tt.logger.Warningw(fmt.Sprintf("received inconsistent payload length "+
+			"(function code 0x%02x, expected %v bytes, received %v)",
+			res.functionCode, 1+byteCount, len(res.payload)), map[string]any{
+			"function_code":   res.functionCode,
+			"expected_length": 1 + byteCount,
+			"received_length": len(res.payload),
+		})
+		return ErrProtocol
+	}
+
+	return nil
+}
+
+// Raises the maximum length of frames accepted by the transport above the
+// 260 bytes allowed by the spec, for devices sending oversized frames.
+// A length of 0 restores the default.
+func (tt *tcpTransport) setMaxFrameLength(length int) {
+	tt.maxFrameLength.Store(int32(length))
+
+	// make room for oversized datagrams
+	if ds, ok := tt.socket.(datagramSocket); ok {
+		ds.setMaxFrameLength(max(length, maxTCPFrameLength))
+	}
+}
+
+// Sets (or removes, if nil) the trace function.
+func (tt *tcpTransport) setTraceFunc(traceFunc func(direction Direction, frame []byte)) {
+	if traceFunc == nil {
+		tt.traceFunc.Store(nil)
+	} else {
+		tt.traceFunc.Store(&traceFunc)
+	}
+}
+
+// Hands a copy of frame to the trace function, if any.
+// Also records frame in the history of the client, if enabled.
+func (tt *tcpTransport) trace(direction Direction, frame []byte) {
+	if traceFunc := tt.traceFunc.Load(); traceFunc != nil {
+		(*traceFunc)(direction, append([]byte(nil), frame...))
+	}
+
+	if tt.history != nil {
+		tt.history.add(direction, frame)
 	}
+}
 
-	// store unit id, function code and payload in the PDU object
-	return &pdu{
-		unitId:       unitId,
-		functionCode: rxbuf[0],
-		payload:      rxbuf[1:],
-	}, txnId, nil
+// Returns the maximum length of frames accepted by the transport.
+func (tt *tcpTransport) frameLengthLimit() int {
+	return max(int(tt.maxFrameLength.Load()), maxTCPFrameLength)
 }
 
 // Turns a PDU into an MBAP frame (MBAP header + PDU) and returns it as bytes.
 func (tt *tcpTransport) assembleMBAPFrame(txnId uint16, p *pdu) []byte {
-	// transaction identifier
-	payload := uint16ToBytes(BIG_ENDIAN, txnId)
-	// protocol identifier (always 0x0000)
-	payload = append(payload, 0x00, 0x00)
-	// length (covers unit identifier + function code + payload fields)
-	payload = append(payload, uint16ToBytes(BIG_ENDIAN, uint16(2+len(p.payload)))...)
-	// unit identifier
-	payload = append(payload, p.unitId)
-	// function code
-	payload = append(payload, p.functionCode)
-	// payload
-	return append(payload, p.payload...)
+	return encodeMBAPFrame(txnId, tt.protocolId, p)
+}
+
+// Returns true if err indicates that the connection was lost (closed or
+// reset by the peer), as opposed to a timeout or a protocol error.
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) {
+		return true
+	}
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+// Wraps i/o timeouts into a timeoutError and connection losses into a
+// connClosedError, leaving other errors untouched.
+func wrapIOError(err error) error {
+	switch {
+	case os.IsTimeout(err):
+		return &timeoutError{err: err}
+	case isConnectionError(err):
+		return &connClosedError{err: err}
+	}
+	return err
 }
@@ -1,9 +1,15 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"log"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -56,6 +62,18 @@ func TestAssembleMBAPFrame(t *testing.T) {
 			t.Errorf("expected 0x%02x at position %v, got 0x%02x", b, i, frame[i])
 		}
 	}
+
+	// frames should be assembled in a single allocation
+	allocs := testing.AllocsPerRun(100, func() {
+		tt.assembleMBAPFrame(0x921b, &pdu{
+			unitId:       0x31,
+			functionCode: 0x10,
+			payload:      make([]byte, 200),
+		})
+	})
+	if allocs > 2 {
+		t.Errorf("expected at most 2 allocations (payload + frame), got %v", allocs)
+	}
 }
 
 func TestTCPTransportReadResponse(t *testing.T) {
@@ -75,26 +93,29 @@ func TestTCPTransportReadResponse(t *testing.T) {
 	txchan <- []byte{
 		0x92, 0x18, // transaction identifier (big endian)
 		0x00, 0x00, // protocol identifier
-		0x00, 0x04, // length (big endian)
+		0x00, 0x06, // length (big endian)
 		0x31, 0x06, // unit id and function code
-		0x12, 0x34, // payload
+		0x00, 0x01, 0x12, 0x34, // payload
 	}
-	res, err := tt.readResponse()
+	res, err := tt.readResponse(0x31)
 	if err != nil {
 		t.Fatalf("readResponse() should have succeeded, got %v", err)
 	}
+	if res.txnId != 0x9218 {
+		t.Errorf("expected 0x9218 as transaction id, got 0x%04x", res.txnId)
+	}
 	if res.unitId != 0x31 {
 		t.Errorf("expected 0x31 as unit id, got 0x%02x", res.unitId)
 	}
 	if res.functionCode != 0x06 {
 		t.Errorf("expected 0x06 as function code, got 0x%02x", res.functionCode)
 	}
-	if len(res.payload) != 2 {
-		t.Errorf("expected a length of 2, got %v", len(res.payload))
+	if len(res.payload) != 4 {
+		t.Errorf("expected a length of 4, got %v", len(res.payload))
 	}
-	if res.payload[0] != 0x12 || res.payload[1] != 0x34 {
-		t.Errorf("expected {0x12, 0x34} as payload, got {0x%02x, 0x%02x}",
-			res.payload[0], res.payload[1])
+	if res.payload[2] != 0x12 || res.payload[3] != 0x34 {
+		t.Errorf("expected {0x12, 0x34} as value, got {0x%02x, 0x%02x}",
+			res.payload[2], res.payload[3])
 	}
 
 	// read a frame with an unexpected transaction id followed by a frame with a
@@ -111,9 +132,9 @@ func TestTCPTransportReadResponse(t *testing.T) {
 		0x00, 0x00, // protocol identifier
 		0x00, 0x04, // length (big endian)
 		0x39, 0x02, // unit id and function code
-		0x10, 0x01, // payload
+		0x01, 0x01, // payload
 	}
-	res, err = tt.readResponse()
+	res, err = tt.readResponse(0x39)
 	if err != nil {
 		t.Fatalf("readResponse() should have succeeded, got %v", err)
 	}
@@ -126,11 +147,56 @@ func TestTCPTransportReadResponse(t *testing.T) {
 	if len(res.payload) != 2 {
 		t.Errorf("expected a length of 2, got %v", len(res.payload))
 	}
-	if res.payload[0] != 0x10 || res.payload[1] != 0x01 {
-		t.Errorf("expected {0x10, 0x01 as payload, got {0x%02x, 0x%02x}",
+	if res.payload[0] != 0x01 || res.payload[1] != 0x01 {
+		t.Errorf("expected {0x01, 0x01} as payload, got {0x%02x, 0x%02x}",
 			res.payload[0], res.payload[1])
 	}
 
+	// read a frame with an unexpected unit id followed by a frame with a
+	// matching unit id: the first frame should be skipped
+	txchan <- []byte{
+		0x92, 0x18, // transaction identifier (big endian)
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length (big endian)
+		0x30, 0x06, // unit id and function code
+		0x00, 0x01, 0x12, 0x34, // payload
+	}
+	txchan <- []byte{
+		0x92, 0x18, // transaction identifier (big endian)
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length (big endian)
+		0x31, 0x06, // unit id and function code
+		0x00, 0x01, 0x56, 0x78, // payload
+	}
+	res, err = tt.readResponse(0x31)
+	if err != nil {
+		t.Fatalf("readResponse() should have succeeded, got %v", err)
+	}
+	if res.unitId != 0x31 {
+		t.Errorf("expected 0x31 as unit id, got 0x%02x", res.unitId)
+	}
+	if res.payload[2] != 0x56 || res.payload[3] != 0x78 {
+		t.Errorf("expected {0x56, 0x78} as value, got {0x%02x, 0x%02x}",
+			res.payload[2], res.payload[3])
+	}
+
+	// exception responses from gateways (unit id 0xff) should be accepted
+	txchan <- []byte{
+		0x92, 0x18, // transaction identifier (big endian)
+		0x00, 0x00, // protocol identifier
+		0x00, 0x03, // length (big endian)
+		0xff, 0x83, // unit id and exception code
+		0x0b, // exception code
+	}
+	res, err = tt.readResponse(0x31)
+	if err != nil {
+		t.Fatalf("readResponse() should have succeeded, got %v", err)
+	}
+	if res.unitId != 0xff || res.functionCode != 0x83 {
+		t.Errorf("expected an exception from unit 0xff, got 0x%02x/0x%02x",
+			res.unitId, res.functionCode)
+	}
+
 	// read a frame with an illegal length, preceded by a frame with an unexpected
 	// protocol ID. While the first frame should be skipped without error,
 	// the second should yield an ErrProtocolError.
@@ -147,7 +213,34 @@ func TestTCPTransportReadResponse(t *testing.T) {
 		0x00, 0x01, // length (big endian)
 		0x31, // unit id
 	}
-	_, err = tt.readResponse()
+	_, err = tt.readResponse(0x31)
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("readResponse() should have returned ErrProtocolError, got %v", err)
+	}
+
+	// read a frame whose length is inconsistent with its function code
+	// (write single register responses carry 4 bytes of payload)
+	txchan <- []byte{
+		0x92, 0x18, // transaction identifier (big endian)
+		0x00, 0x00, // protocol identifier
+		0x00, 0x04, // length (big endian)
+		0x31, 0x06, // unit id and function code
+		0x12, 0x34, // payload
+	}
+	_, err = tt.readResponse(0x31)
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("readResponse() should have returned ErrProtocolError, got %v", err)
+	}
+
+	// likewise with a byte count field not matching the payload length
+	txchan <- []byte{
+		0x92, 0x18, // transaction identifier (big endian)
+		0x00, 0x00, // protocol identifier
+		0x00, 0x05, // length (big endian)
+		0x31, 0x03, // unit id and function code
+		0x04, 0x12, 0x34, // payload
+	}
+	_, err = tt.readResponse(0x31)
 	if !errors.Is(err, ErrProtocol) {
 		t.Errorf("readResponse() should have returned ErrProtocolError, got %v", err)
 	}
@@ -163,7 +256,7 @@ func TestTCPTransportReadResponse(t *testing.T) {
 		0x88, 0x99, // payload
 		0xaa, 0xbb, // payload
 	}
-	res, err = tt.readResponse()
+	res, err = tt.readResponse(0x31)
 	if err != nil {
 		t.Errorf("readResponse() should have succeeded, got %v", err)
 	}
@@ -195,7 +288,7 @@ func TestTCPTransportReadResponse(t *testing.T) {
 		0x10, 0x0a, // length (big endian)
 		0x31, // unit id
 	}
-	_, err = tt.readResponse()
+	_, err = tt.readResponse(0x31)
 	if !errors.Is(err, ErrProtocol) {
 		t.Errorf("readResponse() should have returned ErrProtocolError, got %v", err)
 	}
@@ -357,3 +450,1869 @@ func TestTCPTransportWriteResponse(t *testing.T) {
 	// wait for the checker goroutine to return
 	<-done
 }
+
+func TestTCPTransportRetry(t *testing.T) {
+	var tt *tcpTransport
+	var res *pdu
+	var err error
+
+	// without a retry policy, the request should fail on the first dropped
+	// connection
+	tt = newTCPTransport(dialFlakyTCPPeer(t), 100*time.Millisecond, nil)
+	tt.lastTxnId = 0x0041
+	_, err = tt.ExecuteRequest(&pdu{
+		unitId:       0x01,
+		functionCode: 0x06,
+		payload:      []byte{0x00, 0x01, 0x12, 0x34},
+	})
+	if !isConnectionError(err) {
+		t.Errorf("ExecuteRequest() should have returned a connection error, got %v", err)
+	}
+	tt.Close()
+
+	// with a retry policy, the transport should reconnect and re-send the
+	// request with the same transaction id
+	tt = newTCPTransport(dialFlakyTCPPeer(t), 100*time.Millisecond, nil)
+	tt.retry = RetryConfig{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+	tt.lastTxnId = 0x0041
+	res, err = tt.ExecuteRequest(&pdu{
+		unitId:       0x01,
+		functionCode: 0x06,
+		payload:      []byte{0x00, 0x01, 0x12, 0x34},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x06 {
+		t.Errorf("expected 0x06 as function code, got 0x%02x", res.functionCode)
+	}
+	if tt.lastTxnId != 0x0042 {
+		t.Errorf("expected 0x0042 as transaction id, got 0x%04x", tt.lastTxnId)
+	}
+	tt.Close()
+}
+
+// Starts a TCP peer which drops the first connection after reading a
+// 12-byte request and answers the request received on the second one,
+// then returns a connection to it.
+func dialFlakyTCPPeer(t *testing.T) net.Conn {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		var rxbuf []byte = make([]byte, 12)
+
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			_, err = io.ReadFull(conn, rxbuf)
+			if err != nil || i == 0 {
+				conn.Close()
+				continue
+			}
+
+			// echo the transaction id of the request
+			conn.Write([]byte{
+				rxbuf[0], rxbuf[1], // transaction identifier (big endian)
+				0x00, 0x00, // protocol identifier
+				0x00, 0x06, // length (big endian)
+				0x01, 0x06, // unit id and function code
+				0x00, 0x01, 0x12, 0x34, // payload
+			})
+			conn.Close()
+		}
+	}()
+
+	sock, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return sock
+}
+
+func TestTCPTransportConcurrentRequests(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var wg sync.WaitGroup
+
+	p1, p2 = net.Pipe()
+
+	// play the role of a server echoing the unit id of each request back
+	// as the payload
+	go func() {
+		var rxbuf []byte = make([]byte, 8)
+
+		for {
+			_, err := io.ReadFull(p1, rxbuf)
+			if err != nil {
+				return
+			}
+
+			p1.Write([]byte{
+				rxbuf[0], rxbuf[1], // transaction identifier (big endian)
+				0x00, 0x00, // protocol identifier
+				0x00, 0x03, // length (big endian)
+				rxbuf[6], 0x07, // unit id and function code
+				rxbuf[6], // payload
+			})
+		}
+	}()
+
+	tt = newTCPTransport(p2, 500*time.Millisecond, nil)
+
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(unitId uint8) {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				res, err := tt.ExecuteRequest(&pdu{
+					unitId:       unitId,
+					functionCode: 0x07,
+				})
+				if err != nil {
+					t.Errorf("ExecuteRequest() should have succeeded, got %v", err)
+					return
+				}
+				if len(res.payload) != 1 || res.payload[0] != unitId {
+					t.Errorf("expected {0x%02x} as payload, got %v", unitId, res.payload)
+					return
+				}
+			}
+		}(uint8(i))
+	}
+	wg.Wait()
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestTCPTransportPipelinedRequests(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var wg sync.WaitGroup
+	var err error
+
+	p1, p2 = net.Pipe()
+
+	// play the role of a server waiting for 5 requests before answering
+	// them in reverse order, echoing the unit id of each request as payload
+	go func() {
+		var requests [][]byte
+
+		for len(requests) < 5 {
+			rxbuf := make([]byte, 8)
+			_, err := io.ReadFull(p1, rxbuf)
+			if err != nil {
+				return
+			}
+			requests = append(requests, rxbuf)
+		}
+
+		// throw in a response to a transaction nobody is waiting for
+		p1.Write([]byte{
+			0xab, 0xcd, // transaction identifier (big endian)
+			0x00, 0x00, // protocol identifier
+			0x00, 0x03, // length (big endian)
+			0x01, 0x07, // unit id and function code
+			0x01, // payload
+		})
+
+		for i := len(requests) - 1; i >= 0; i-- {
+			p1.Write([]byte{
+				requests[i][0], requests[i][1], // transaction identifier
+				0x00, 0x00, // protocol identifier
+				0x00, 0x03, // length (big endian)
+				requests[i][6], 0x07, // unit id and function code
+				requests[i][6], // payload
+			})
+		}
+
+		// swallow any further request without answering
+		io.Copy(io.Discard, p1)
+	}()
+
+	tt = newTCPTransport(p2, 500*time.Millisecond, nil)
+	tt.pipelined = true
+
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(unitId uint8) {
+			defer wg.Done()
+
+			res, err := tt.ExecuteRequest(&pdu{
+				unitId:       unitId,
+				functionCode: 0x07,
+			})
+			if err != nil {
+				t.Errorf("ExecuteRequest() should have succeeded, got %v", err)
+				return
+			}
+			if res.unitId != unitId || len(res.payload) != 1 || res.payload[0] != unitId {
+				t.Errorf("unexpected response for unit 0x%02x: %+v", unitId, res)
+			}
+		}(uint8(i))
+	}
+	wg.Wait()
+
+	// requests left unanswered should time out
+	tt.setTimeout(20 * time.Millisecond)
+	_, err = tt.ExecuteRequest(&pdu{unitId: 0x01, functionCode: 0x07})
+	if !os.IsTimeout(err) {
+		t.Errorf("ExecuteRequest() should have timed out, got %v", err)
+	}
+
+	// pending requests should be failed when the connection goes away
+	tt.setTimeout(500 * time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p1.Close()
+	}()
+	_, err = tt.ExecuteRequest(&pdu{unitId: 0x01, functionCode: 0x07})
+	if err == nil {
+		t.Errorf("ExecuteRequest() should have failed")
+	}
+
+	p2.Close()
+}
+
+func TestTCPTransportExecuteRequestContext(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var ts time.Time
+
+	p1, p2 = net.Pipe()
+
+	// play the role of a server which never answers
+	go io.Copy(io.Discard, p1)
+
+	tt = newTCPTransport(p2, 5*time.Second, nil)
+
+	// cancelling the context should unblock the request promptly
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	ts = time.Now()
+	_, err = tt.ExecuteRequestContext(ctx, &pdu{unitId: 0x01, functionCode: 0x07})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteRequestContext() should have returned context.Canceled, got %v", err)
+	}
+	if time.Since(ts) > time.Second {
+		t.Errorf("ExecuteRequestContext() took too long to return (%v)", time.Since(ts))
+	}
+
+	// the context deadline should supersede the transport timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	ts = time.Now()
+	_, err = tt.ExecuteRequestContext(ctx, &pdu{unitId: 0x01, functionCode: 0x07})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecuteRequestContext() should have returned context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(ts) > time.Second {
+		t.Errorf("ExecuteRequestContext() took too long to return (%v)", time.Since(ts))
+	}
+
+	// the same should hold in pipelined mode
+	tt.pipelined = true
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = tt.ExecuteRequestContext(ctx, &pdu{unitId: 0x01, functionCode: 0x07})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteRequestContext() should have returned context.Canceled, got %v", err)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestTCPTransportShortFrameTimeout(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var netErr net.Error
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	tt = newTCPTransport(p2, 10*time.Millisecond, nil)
+	tt.lastTxnId = 0x0001
+
+	// send a valid header announcing 5 bytes of PDU, followed by only 2
+	// bytes before stalling
+	txchan <- []byte{
+		0x00, 0x01, // transaction identifier (big endian)
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length (big endian)
+		0x01, 0x03, // unit id and function code
+		0x02, // payload
+	}
+	p2.SetDeadline(time.Now().Add(tt.defaultTimeout()))
+	_, err = tt.readResponse(0x01)
+	if !errors.Is(err, ErrRequestTimedOut) {
+		t.Errorf("readResponse() should have returned ErrRequestTimedOut, got %v", err)
+	}
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a net.Error with Timeout() == true, got %v", err)
+	}
+	if errors.Is(err, ErrProtocol) {
+		t.Errorf("a timeout should not be reported as a protocol error")
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestTCPTransportReadMBAPFramePayloadRetention(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var res1, res2 *pdu
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	tt = newTCPTransport(p2, 10*time.Millisecond, nil)
+
+	txchan <- []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x05, // length
+		0x01, 0x03, // unit id and function code
+		0x02, 0xaa, 0xbb, // payload
+	}
+	res1, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got %v", err)
+	}
+
+	// receive buffers are pooled: reading another frame should leave the
+	// payload of the first one untouched
+	txchan <- []byte{
+		0x00, 0x02, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x05, // length
+		0x01, 0x03, // unit id and function code
+		0x02, 0xcc, 0xdd, // payload
+	}
+	res2, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got %v", err)
+	}
+
+	for i, b := range []byte{0x02, 0xaa, 0xbb} {
+		if res1.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x", b, i, res1.payload[i])
+		}
+	}
+	for i, b := range []byte{0x02, 0xcc, 0xdd} {
+		if res2.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x", b, i, res2.payload[i])
+		}
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestTCPTransportKeepAlive(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var probes chan *pdu
+	var redials chan net.Conn
+	var res *pdu
+	var err error
+
+	probes = make(chan *pdu, 10)
+	redials = make(chan net.Conn, 10)
+
+	// play the role of a device answering requests with an echo
+	serve := func(sock net.Conn) {
+		peer := newTCPTransport(sock, time.Second, nil)
+		for {
+			req, err := peer.ReadRequest()
+			if err != nil {
+				return
+			}
+			if req.functionCode == 0x08 {
+				probes <- req
+			}
+			peer.WriteResponse(req)
+		}
+	}
+
+	p1, p2 = net.Pipe()
+	go serve(p1)
+
+	tt = newTCPTransport(p2, 50*time.Millisecond, nil)
+	tt.redial = func() (net.Conn, error) {
+		s1, s2 := net.Pipe()
+		go serve(s1)
+		redials <- s2
+		return s2, nil
+	}
+	tt.startKeepAlive(KeepAliveConfig{
+		Interval: 100 * time.Millisecond,
+		Probe: func() (uint8, uint8, []byte) {
+			return 0x01, 0x08, []byte{0x00, 0x00, 0x12, 0x34}
+		},
+	})
+
+	// requests should keep the connection from being probed
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+		_, err = tt.ExecuteRequest(&pdu{
+			unitId:       0x01,
+			functionCode: 0x06,
+			payload:      []byte{0x00, 0x00, 0x00, 0x01},
+		})
+		if err != nil {
+			t.Fatalf("ExecuteRequest() should have succeeded, got: %v", err)
+		}
+	}
+	if len(probes) != 0 {
+		t.Errorf("expected no probe, got %v", len(probes))
+	}
+
+	// an idle connection should get probed
+	select {
+	case res = <-probes:
+		if res.unitId != 0x01 || len(res.payload) != 4 || res.payload[3] != 0x34 {
+			t.Errorf("unexpected probe: %v", res)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timed out waiting for a probe")
+	}
+
+	// the connection should be re-established once a probe fails
+	p1.Close()
+	select {
+	case <-redials:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timed out waiting for a reconnection")
+	}
+
+	// and probes should keep flowing on the new connection
+	select {
+	case <-probes:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("timed out waiting for a probe")
+	}
+
+	// closing the transport should stop probes
+	tt.Close()
+	for len(probes) > 0 {
+		<-probes
+	}
+	time.Sleep(250 * time.Millisecond)
+	if len(probes) != 0 || len(redials) != 0 {
+		t.Errorf("expected no activity after Close(), got %v probes, %v redials",
+			len(probes), len(redials))
+	}
+}
+
+func TestTCPTransportUnitTimeout(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var ts time.Time
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	// play the role of a server which never answers
+	go io.Copy(io.Discard, p1)
+
+	tt = newTCPTransport(p2, 5*time.Second, nil)
+
+	// requests to unit 0x02 should use the override...
+	tt.setUnitTimeout(0x02, 20*time.Millisecond)
+
+	ts = time.Now()
+	_, err = tt.ExecuteRequest(&pdu{unitId: 0x02, functionCode: 0x07})
+	if !os.IsTimeout(err) {
+		t.Errorf("ExecuteRequest() should have timed out, got %v", err)
+	}
+	if time.Since(ts) > time.Second {
+		t.Errorf("ExecuteRequest() took too long to time out (%v)", time.Since(ts))
+	}
+
+	// ... while other unit ids should keep the default timeout
+	if tt.timeoutFor(0x01) != 5*time.Second {
+		t.Errorf("unit 0x01 should have used the default timeout")
+	}
+
+	// a zero timeout should remove the override
+	tt.setUnitTimeout(0x02, 0)
+	if tt.timeoutFor(0x02) != 5*time.Second {
+		t.Errorf("unit 0x02 override should have been removed")
+	}
+}
+
+func TestTCPTransportRawFrameFunc(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var frames [][]byte
+	var frame []byte
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	tt = newTCPTransport(p2, 10*time.Millisecond, nil)
+	tt.rawFrameFunc = func(frame []byte) {
+		frames = append(frames, frame)
+	}
+
+	frame = []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x05, // length
+		0x01, 0x03, // unit id and function code
+		0x02, 0xaa, 0xbb, // payload
+	}
+	txchan <- frame
+	_, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got %v", err)
+	}
+
+	// frames should be passed along even when they fail validation
+	txchan <- []byte{
+		0x00, 0x02, // transaction identifier
+		0x00, 0x01, // protocol identifier
+		0x00, 0x03, // length
+		0x01, 0x03, // unit id and function code
+		0x00, // payload
+	}
+	_, _, err = tt.readMBAPFrame()
+	if err != ErrUnknownProtocolId {
+		t.Fatalf("readMBAPFrame() should have returned ErrUnknownProtocolId, got %v", err)
+	}
+
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %v", len(frames))
+	}
+	if !bytes.Equal(frames[0], frame) {
+		t.Errorf("unexpected raw frame: % x", frames[0])
+	}
+	if len(frames[1]) != 9 || frames[1][3] != 0x01 {
+		t.Errorf("unexpected raw frame: % x", frames[1])
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestTCPTransportMaxFrameLength(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var frame []byte
+	var res *pdu
+	var err error
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	tt = newTCPTransport(p2, 10*time.Millisecond, nil)
+
+	// 300-byte frame (user-defined function code 0x41)
+	frame = []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x01, 0x26, // length (unit id + 293 bytes of pdu)
+		0x01, 0x41, // unit id and function code
+	}
+	for i := 0; i < 292; i++ {
+		frame = append(frame, uint8(i))
+	}
+
+	// oversized frames should be rejected by default
+	txchan <- frame
+	_, _, err = tt.readMBAPFrame()
+	if err != ErrProtocol {
+		t.Errorf("readMBAPFrame() should have returned ErrProtocol, got %v", err)
+	}
+	// drain the rest of the frame
+	p2.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	io.Copy(io.Discard, p2)
+	p2.SetReadDeadline(time.Time{})
+
+	// ... unless the limit was raised
+	tt.setMaxFrameLength(300)
+	txchan <- frame
+	res, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x41 || len(res.payload) != 292 || res.payload[291] != 0x23 {
+		t.Errorf("unexpected response: fc 0x%02x, %v bytes of payload",
+			res.functionCode, len(res.payload))
+	}
+
+	p1.Close()
+	p2.Close()
+
+	// out of range limits should be rejected
+	client, _ := NewClient(&ClientConfiguration{URL: "tcp://localhost:5502"})
+	for _, length := range []int{100, 259, 65542} {
+		if client.SetMaxFrameLength(length) != ErrUnexpectedParameters {
+			t.Errorf("SetMaxFrameLength(%v) should have returned ErrUnexpectedParameters", length)
+		}
+	}
+	for _, length := range []int{0, 260, 1024, 65541} {
+		if client.SetMaxFrameLength(length) != nil {
+			t.Errorf("SetMaxFrameLength(%v) should have succeeded", length)
+		}
+	}
+}
+
+func TestTCPTransportTxnIdMismatchPolicy(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var res *pdu
+	var err error
+	var seen [][2]uint16
+	var client *ModbusClient
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	go feedTestPipe(t, txchan, p1)
+
+	// frame with transaction id txnId
+	frame := func(txnId uint16) []byte {
+		return []byte{
+			byte(txnId >> 8), byte(txnId), // transaction identifier
+			0x00, 0x00, // protocol identifier
+			0x00, 0x03, // length
+			0x01, 0x83, // unit id and function code
+			0x02, // exception code
+		}
+	}
+
+	// fail on mismatch
+	client, _ = NewClient(&ClientConfiguration{
+		URL:           "tcp://localhost:5502",
+		TxnIdMismatch: TXN_ID_MISMATCH_FAIL,
+	})
+	tt = newTCPTransport(p2, 100*time.Millisecond, nil)
+	client.configureTCPTransport(tt)
+	tt.lastTxnId = 0x0010
+
+	txchan <- frame(0x000f)
+	_, err = tt.readResponse(0x01)
+	if err != ErrBadTransactionId {
+		t.Errorf("readResponse() should have returned ErrBadTransactionId, got %v", err)
+	}
+
+	// let a callback decide
+	client, _ = NewClient(&ClientConfiguration{
+		URL:           "tcp://localhost:5502",
+		TxnIdMismatch: TXN_ID_MISMATCH_FAIL,
+		TxnIdMismatchFunc: func(expected uint16, received uint16) bool {
+			seen = append(seen, [2]uint16{expected, received})
+			// skip late responses only
+			return received < expected
+		},
+	})
+	client.configureTCPTransport(tt)
+
+	txchan <- frame(0x000e)
+	txchan <- frame(0x0010)
+	res, err = tt.readResponse(0x01)
+	if err != nil || res.txnId != 0x0010 {
+		t.Errorf("readResponse() should have succeeded, got %v", err)
+	}
+
+	txchan <- frame(0x0011)
+	_, err = tt.readResponse(0x01)
+	if err != ErrBadTransactionId {
+		t.Errorf("readResponse() should have returned ErrBadTransactionId, got %v", err)
+	}
+
+	if len(seen) != 2 || seen[0] != [2]uint16{0x10, 0x0e} || seen[1] != [2]uint16{0x10, 0x11} {
+		t.Errorf("unexpected callback calls: %v", seen)
+	}
+}
+
+func TestTCPTransportMaxSkippedFrames(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+
+	txchan = make(chan []byte, 4)
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	go feedTestPipe(t, txchan, p1)
+
+	// exception frame with transaction id txnId, protocol id protocolId
+	// and unit id unitId
+	frame := func(txnId uint16, protocolId uint16, unitId uint8) []byte {
+		return []byte{
+			byte(txnId >> 8), byte(txnId), // transaction identifier
+			byte(protocolId >> 8), byte(protocolId), // protocol identifier
+			0x00, 0x03, // length
+			unitId, 0x83, // unit id and function code
+			0x02, // exception code
+		}
+	}
+
+	tt = newTCPTransport(p2, 500*time.Millisecond, nil)
+	tt.maxSkippedFrames = 2
+	tt.lastTxnId = 0x0010
+
+	// 2 non-matching frames should be skipped...
+	txchan <- frame(0x000f, 0x0000, 0x01)
+	txchan <- frame(0x0010, 0x0001, 0x01)
+	txchan <- frame(0x0010, 0x0000, 0x01)
+	_, err = tt.readResponse(0x01)
+	if err != nil {
+		t.Errorf("readResponse() should have succeeded, got %v", err)
+	}
+
+	// ... but not 3
+	txchan <- frame(0x000f, 0x0000, 0x01)
+	txchan <- frame(0x0010, 0x0001, 0x01)
+	txchan <- frame(0x0010, 0x0000, 0x02)
+	_, err = tt.readResponse(0x01)
+	if err != ErrTooManyMismatchedFrames {
+		t.Errorf("readResponse() should have returned ErrTooManyMismatchedFrames, got %v", err)
+	}
+}
+
+func TestTCPTransportSetTimeout(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var ts time.Time
+	var wg sync.WaitGroup
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	// play the role of a server which never answers
+	go io.Copy(io.Discard, p1)
+
+	tt = newTCPTransport(p2, 200*time.Millisecond, nil)
+
+	// changing the timeout while requests are in flight should be safe...
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tt.setTimeout(time.Duration(i+1) * time.Millisecond)
+		}
+	}()
+	tt.ExecuteRequest(&pdu{unitId: 0x01, functionCode: 0x07})
+	wg.Wait()
+
+	// ... and take effect on the next request
+	tt.setTimeout(20 * time.Millisecond)
+	ts = time.Now()
+	_, err = tt.ExecuteRequest(&pdu{unitId: 0x01, functionCode: 0x07})
+	if !os.IsTimeout(err) {
+		t.Errorf("ExecuteRequest() should have timed out, got %v", err)
+	}
+	if time.Since(ts) > time.Second {
+		t.Errorf("ExecuteRequest() took too long to time out (%v)", time.Since(ts))
+	}
+}
+
+func TestTCPTransportStats(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var rxbuf []byte
+	var stats ConnStats
+	var err error
+
+	txchan = make(chan []byte, 1)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	tt = newTCPTransport(p2, 10*time.Millisecond, nil)
+
+	txchan <- []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x05, // length
+		0x01, 0x03, // unit id and function code
+		0x02, 0xaa, 0xbb, // payload
+	}
+	_, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got %v", err)
+	}
+
+	rxbuf = make([]byte, 12)
+	go func() {
+		io.ReadFull(p1, rxbuf)
+	}()
+	err = tt.WriteResponse(&pdu{
+		unitId:       0x01,
+		functionCode: 0x06,
+		payload:      []byte{0x00, 0x01, 0x00, 0x02},
+	})
+	if err != nil {
+		t.Fatalf("WriteResponse() should have succeeded, got %v", err)
+	}
+
+	stats = tt.stats()
+	if stats.BytesReceived != 11 || stats.FramesReceived != 1 {
+		t.Errorf("unexpected rx stats: %+v", stats)
+	}
+	if stats.BytesSent != 12 || stats.FramesSent != 1 {
+		t.Errorf("unexpected tx stats: %+v", stats)
+	}
+
+	local, remote := tt.addrs()
+	if local == nil || remote == nil {
+		t.Errorf("addrs() should have returned non-nil addresses")
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestTCPTransportConnLifecycle(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var dials int
+	var closed chan bool = make(chan bool, 10)
+
+	// answers holding register reads on the device end of the pipe until
+	// it gets closed
+	serve := func(conn net.Conn) {
+		st := newTCPTransport(conn, 1*time.Second, nil)
+		for {
+			req, err := st.ReadRequest()
+			if err != nil {
+				closed <- true
+				return
+			}
+			st.WriteResponse(&pdu{
+				unitId:       req.unitId,
+				functionCode: req.functionCode,
+				payload:      []byte{0x02, 0x12, 0x34},
+			})
+		}
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://pool",
+		Timeout: 1 * time.Second,
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			dials++
+			go serve(p1)
+			return p2, nil
+		},
+		ConnPerRequest: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+
+	for i := range 3 {
+		reg, err := client.ReadRegister(0, HOLDING_REGISTER)
+		if err != nil || reg != 0x1234 {
+			t.Fatalf("unexpected value: 0x%04x (err: %v)", reg, err)
+		}
+
+		// the connection should be released after each request
+		select {
+		case <-closed:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("connection #%v should have been closed", i+1)
+		}
+	}
+
+	if dials != 3 {
+		t.Errorf("expected 3 dials, got %v", dials)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Errorf("Close() should have succeeded, got %v", err)
+	}
+
+	// connections should be replaced once they reach their maximum lifetime
+	dials = 0
+	client.conf.ConnPerRequest = false
+	client.conf.ConnMaxLifetime = 50 * time.Millisecond
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+
+	for range 2 {
+		_, err = client.ReadRegister(0, HOLDING_REGISTER)
+		if err != nil {
+			t.Fatalf("ReadRegister() should have succeeded, got %v", err)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("expected 1 dial, got %v", dials)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadRegister() should have succeeded, got %v", err)
+	}
+	if dials != 2 {
+		t.Errorf("expected 2 dials, got %v", dials)
+	}
+
+	client.Close()
+}
+
+func TestTCPTransportTCPKeepAlive(t *testing.T) {
+	var client *ModbusClient
+	var ln net.Listener
+	var err error
+
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		var conns []net.Conn
+
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://" + ln.Addr().String(),
+		TCPKeepAlive: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     5 * time.Second,
+			Interval: 1 * time.Second,
+			Count:    3,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+
+	if _, ok := client.transport.(*tcpTransport).socket.(*net.TCPConn); !ok {
+		t.Errorf("expected a *net.TCPConn socket")
+	}
+
+	// options should be applied to connections obtained through Dial as well
+	client.conf.Dial = func() (net.Conn, error) {
+		return net.Dial("tcp", ln.Addr().String())
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+
+	client.Close()
+}
+
+func TestTCPTransportTraceFunc(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var directions []Direction
+	var frames [][]byte
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://device",
+		Timeout: 1 * time.Second,
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			go func() {
+				st := newTCPTransport(p1, 1*time.Second, nil)
+				req, err := st.ReadRequest()
+				if err == nil {
+					st.WriteResponse(&pdu{
+						unitId:       req.unitId,
+						functionCode: req.functionCode,
+						payload:      []byte{0x02, 0x12, 0x34},
+					})
+				}
+			}()
+			return p2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// trace functions set through handles should apply to the parent
+	client.WithUnitId(2).SetTraceFunc(func(direction Direction, frame []byte) {
+		directions = append(directions, direction)
+		frames = append(frames, frame)
+	})
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.ReadRegister(0x10, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("ReadRegister() should have succeeded, got %v", err)
+	}
+
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %v", len(frames))
+	}
+	if directions[0] != DIRECTION_TX || !bytes.Equal(frames[0], []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06,
+		0xff, 0x03, 0x00, 0x10, 0x00, 0x01}) {
+		t.Errorf("unexpected tx frame (%v): % x", directions[0], frames[0])
+	}
+	if directions[1] != DIRECTION_RX || !bytes.Equal(frames[1], []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x05,
+		0xff, 0x03, 0x02, 0x12, 0x34}) {
+		t.Errorf("unexpected rx frame (%v): % x", directions[1], frames[1])
+	}
+
+	// removing the trace function should stop tracing
+	client.SetTraceFunc(nil)
+	client.transport.(*tcpTransport).trace(DIRECTION_TX, []byte{0x00})
+	if len(frames) != 2 {
+		t.Errorf("expected 2 frames, got %v", len(frames))
+	}
+}
+
+func TestTCPTransportReadTimeout(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var frame []byte = []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length
+		0x01, 0x03, // unit id and function code
+		0x00, 0x00, 0x00, 0x01, // payload
+	}
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tt = newTCPTransport(p2, 500*time.Millisecond, nil)
+	tt.readTimeout = 50 * time.Millisecond
+
+	// idle connections should be allowed to wait for longer than the read
+	// timeout
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		p1.Write(frame)
+	}()
+
+	_, err = tt.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got: %v", err)
+	}
+
+	// requests trickling in should time out
+	go func() {
+		p1.Write(frame[0:3])
+		time.Sleep(100 * time.Millisecond)
+		p1.Write(frame[3:])
+	}()
+
+	start := time.Now()
+	_, err = tt.ReadRequest()
+	if !os.IsTimeout(err) {
+		t.Errorf("ReadRequest() should have timed out, got: %v", err)
+	}
+	if time.Since(start) > 90*time.Millisecond {
+		t.Errorf("ReadRequest() should have timed out after ~50ms, took %v",
+			time.Since(start))
+	}
+}
+
+func TestTCPTransportTolerantExceptions(t *testing.T) {
+	var client *ModbusClient
+	var err error
+
+	for _, tolerant := range []bool{false, true} {
+		client, err = NewClient(&ClientConfiguration{
+			URL:                "tcp://device",
+			Timeout:            100 * time.Millisecond,
+			TolerantExceptions: tolerant,
+			// play the role of a device echoing the request function code
+			// in exception responses
+			Dial: func() (net.Conn, error) {
+				p1, p2 := net.Pipe()
+				go func() {
+					st := newTCPTransport(p1, 1*time.Second, nil)
+					for {
+						req, err := st.ReadRequest()
+						if err != nil {
+							return
+						}
+						st.WriteResponse(&pdu{
+							unitId:       req.unitId,
+							functionCode: req.functionCode,
+							payload:      []byte{0x02},
+						})
+					}
+				}()
+				return p2, nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		err = client.Open()
+		if err != nil {
+			t.Fatalf("failed to open client: %v", err)
+		}
+
+		_, err = client.ReadRegister(0x10, HOLDING_REGISTER)
+		if tolerant && !errors.Is(err, ErrIllegalDataAddress) {
+			t.Errorf("expected ErrIllegalDataAddress, got %v", err)
+		}
+		if !tolerant && err != ErrProtocol {
+			t.Errorf("expected ErrProtocol, got %v", err)
+		}
+
+		// function codes with fixed-length responses as well
+		err = client.WriteRegister(0x10, 0x1234)
+		if tolerant && !errors.Is(err, ErrIllegalDataAddress) {
+			t.Errorf("expected ErrIllegalDataAddress, got %v", err)
+		}
+
+		client.Close()
+	}
+}
+
+func TestTCPTransportProtocolId(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var res *pdu
+	var err error
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	go feedTestPipe(t, txchan, p1)
+
+	tt = newTCPTransport(p2, 100*time.Millisecond, nil)
+	tt.protocolId = 0x1234
+
+	frame := tt.assembleMBAPFrame(0x0001, &pdu{
+		unitId:       0x01,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x01, 0x00, 0x01},
+	})
+	if frame[2] != 0x12 || frame[3] != 0x34 {
+		t.Errorf("unexpected protocol id: % x", frame[2:4])
+	}
+
+	// modbus frames should now be rejected...
+	txchan <- []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x03, // length
+		0x01, 0x83, // unit id and function code
+		0x02, // payload
+	}
+	_, _, err = tt.readMBAPFrame()
+	if err != ErrUnknownProtocolId {
+		t.Errorf("readMBAPFrame() should have returned ErrUnknownProtocolId, got %v", err)
+	}
+
+	// ... in favor of frames bearing the configured protocol id
+	txchan <- []byte{
+		0x00, 0x01, // transaction identifier
+		0x12, 0x34, // protocol identifier
+		0x00, 0x03, // length
+		0x01, 0x83, // unit id and function code
+		0x02, // payload
+	}
+	res, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x83 || !bytes.Equal(res.payload, []byte{0x02}) {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestTCPTransportReadRequestContext(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var req *pdu
+	var frame []byte = []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length
+		0x01, 0x03, // unit id and function code
+		0x00, 0x00, 0x00, 0x01, // payload
+	}
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tt = newTCPTransport(p2, 5*time.Second, nil)
+
+	// requests should be read as usual while the context is live
+	go p1.Write(frame)
+
+	req, err = tt.ReadRequestContext(context.Background())
+	if err != nil {
+		t.Fatalf("ReadRequestContext() should have succeeded, got: %v", err)
+	}
+	if req.unitId != 0x01 || req.functionCode != 0x03 {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	// cancelling the context should unblock a read waiting on an idle
+	// connection without waiting out the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err = tt.ReadRequestContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("ReadRequestContext() should have returned after ~50ms, took %v",
+			time.Since(start))
+	}
+
+	// done contexts should not read anything
+	_, err = tt.ReadRequestContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestTCPTransportDialer(t *testing.T) {
+	var client *ModbusClient
+	var ln net.Listener
+	var err error
+	var accepted chan net.Conn = make(chan net.Conn, 1)
+
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// tcp connections should be dialed from the local address of the dialer
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://" + ln.Addr().String(),
+		Dialer: &net.Dialer{
+			LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5512},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	conn := <-accepted
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "127.0.0.1:5512" {
+		t.Errorf("expected a connection from 127.0.0.1:5512, got: %v",
+			conn.RemoteAddr())
+	}
+
+	// udp sockets should be bound to the configured local address
+	client, err = NewClient(&ClientConfiguration{
+		URL:          "udp://127.0.0.1:5513",
+		UDPLocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5512},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	if client.LocalAddr().String() != "127.0.0.1:5512" {
+		t.Errorf("expected local address 127.0.0.1:5512, got: %v",
+			client.LocalAddr())
+	}
+}
+
+type testFrameLengthObserver struct {
+	testObserver
+	mismatches [][3]int
+}
+
+func (fo *testFrameLengthObserver) FrameLengthMismatch(functionCode uint8,
+	declaredLength int, expectedLength int) {
+	fo.mismatches = append(fo.mismatches,
+		[3]int{int(functionCode), declaredLength, expectedLength})
+}
+
+func TestTCPTransportFrameLengthMismatch(t *testing.T) {
+	var client *ModbusClient
+	var fo *testFrameLengthObserver = &testFrameLengthObserver{}
+	var payload []byte
+	var err error
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:      "tcp://device",
+		Timeout:  100 * time.Millisecond,
+		Observer: fo,
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			go func() {
+				st := newTCPTransport(p1, 1*time.Second, nil)
+				for {
+					req, err := st.ReadRequest()
+					if err != nil {
+						return
+					}
+					st.WriteResponse(&pdu{
+						unitId:       req.unitId,
+						functionCode: req.functionCode,
+						payload:      payload,
+					})
+				}
+			}()
+			return p2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// well-formed responses should not be reported
+	payload = []byte{0x02, 0x12, 0x34}
+	_, err = client.ReadRegister(0x10, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	// nor should short ones
+	payload = []byte{0x02, 0x12}
+	_, err = client.ReadRegister(0x10, HOLDING_REGISTER)
+	if err != ErrProtocol {
+		t.Errorf("expected ErrProtocol, got: %v", err)
+	}
+
+	// responses with trailing bytes should be rejected and reported
+	payload = []byte{0x02, 0x12, 0x34, 0x00, 0x00}
+	_, err = client.ReadRegister(0x10, HOLDING_REGISTER)
+	if err != ErrProtocol {
+		t.Errorf("expected ErrProtocol, got: %v", err)
+	}
+
+	if len(fo.mismatches) != 1 || fo.mismatches[0] != [3]int{0x03, 7, 5} {
+		t.Errorf("unexpected mismatches: %v", fo.mismatches)
+	}
+
+	// plain observers should still be notified of the outcome of requests
+	if len(fo.errs) != 3 {
+		t.Errorf("expected 3 events, got: %v", len(fo.errs))
+	}
+}
+
+func TestTCPTransportExceptionKeepsConnection(t *testing.T) {
+	var client *ModbusClient
+	var dials int
+	var err error
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://device",
+		Timeout: 100 * time.Millisecond,
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			dials++
+			go func() {
+				st := newTCPTransport(p1, 1*time.Second, nil)
+				for {
+					req, err := st.ReadRequest()
+					if err != nil {
+						return
+					}
+
+					// reject the first register, serve the others
+					res := &pdu{
+						unitId:       req.unitId,
+						functionCode: req.functionCode,
+						payload:      []byte{0x02, 0x12, 0x34},
+					}
+					if req.payload[1] == 0x00 {
+						res.functionCode |= 0x80
+						res.payload = []byte{0x02}
+					}
+					st.WriteResponse(res)
+				}
+			}()
+			return p2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	sock := client.transport.(*tcpTransport).socket
+
+	_, err = client.ReadRegister(0x00, HOLDING_REGISTER)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Fatalf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// the next request should succeed over the same connection
+	for i := 0; i < 2; i++ {
+		reg, err := client.ReadRegister(0x01, HOLDING_REGISTER)
+		if err != nil {
+			t.Fatalf("ReadRegister() should have succeeded, got: %v", err)
+		}
+		if reg != 0x1234 {
+			t.Errorf("expected 0x1234, got: 0x%04x", reg)
+		}
+	}
+
+	if dials != 1 || client.transport.(*tcpTransport).socket != sock {
+		t.Errorf("the connection should have been kept open (%v dials)", dials)
+	}
+}
+
+func TestTCPTransportConnIdleTimeout(t *testing.T) {
+	var client *ModbusClient
+	var err error
+	var dials int
+	var closed chan bool = make(chan bool, 10)
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:             "tcp://device",
+		Timeout:         1 * time.Second,
+		ConnIdleTimeout: 100 * time.Millisecond,
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			dials++
+			go func() {
+				st := newTCPTransport(p1, 5*time.Second, nil)
+				for {
+					req, err := st.ReadRequest()
+					if err != nil {
+						closed <- true
+						return
+					}
+					st.WriteResponse(&pdu{
+						unitId:       req.unitId,
+						functionCode: req.functionCode,
+						payload:      []byte{0x02, 0x12, 0x34},
+					})
+				}
+			}()
+			return p2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// requests made within the idle timeout should share the connection
+	for range 3 {
+		_, err = client.ReadRegister(0, HOLDING_REGISTER)
+		if err != nil {
+			t.Fatalf("ReadRegister() should have succeeded, got %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if dials != 1 {
+		t.Errorf("expected 1 dial, got %v", dials)
+	}
+
+	// idle connections should be closed
+	select {
+	case <-closed:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("the idle connection should have been closed")
+	}
+
+	// and transparently re-dialed on the next request
+	reg, err := client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil || reg != 0x1234 {
+		t.Fatalf("unexpected value: 0x%04x (err: %v)", reg, err)
+	}
+	if dials != 2 {
+		t.Errorf("expected 2 dials, got %v", dials)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Errorf("Close() should have succeeded, got %v", err)
+	}
+}
+
+// net.Conn counting calls to Close().
+type closeCountingConn struct {
+	net.Conn
+	lock   sync.Mutex
+	closes int
+}
+
+func (ccc *closeCountingConn) Close() error {
+	ccc.lock.Lock()
+	ccc.closes++
+	ccc.lock.Unlock()
+
+	return ccc.Conn.Close()
+}
+
+func TestTCPTransportCloseIdleRace(t *testing.T) {
+	for range 50 {
+		var wg sync.WaitGroup
+
+		p1, p2 := net.Pipe()
+		conn := &closeCountingConn{Conn: p2}
+		tt := newTCPTransport(conn, 1*time.Second, nil)
+		tt.idleTimeout = time.Millisecond
+		tt.lastRequest = time.Now().Add(-time.Second)
+
+		// the idle timer firing while the transport is closed should not
+		// close the socket twice
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tt.closeIdleConn()
+		}()
+		tt.Close()
+		wg.Wait()
+		p1.Close()
+
+		if conn.closes != 1 {
+			t.Fatalf("expected the socket to be closed once, got %v", conn.closes)
+		}
+	}
+}
+
+func TestTCPTransportCloseAbortsRequest(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var ts time.Time
+	var dials int
+	var errChan chan error = make(chan error, 1)
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+
+	// play the role of a server which never answers
+	go io.Copy(io.Discard, p1)
+
+	tt = newTCPTransport(p2, 5*time.Second, nil)
+	tt.retry = RetryConfig{MaxAttempts: 3, InitialBackoff: 5 * time.Second}
+	tt.redial = func() (net.Conn, error) {
+		dials++
+		return nil, errors.New("should not be called")
+	}
+
+	go func() {
+		_, err := tt.ExecuteRequest(&pdu{unitId: 0x01, functionCode: 0x07})
+		errChan <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// closing the transport should abort the request in progress rather
+	// than wait for it to time out, and keep it from retrying
+	ts = time.Now()
+	err = tt.Close()
+	if err != nil {
+		t.Errorf("Close() should have succeeded, got: %v", err)
+	}
+
+	select {
+	case err = <-errChan:
+		if err == nil {
+			t.Errorf("ExecuteRequest() should have failed")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("ExecuteRequest() should have been aborted")
+	}
+	if time.Since(ts) > time.Second {
+		t.Errorf("Close() took too long to abort the request (%v)", time.Since(ts))
+	}
+	if dials != 0 {
+		t.Errorf("expected no redial, got %v", dials)
+	}
+}
+
+func TestTCPTransportFrameHistory(t *testing.T) {
+	var client *ModbusClient
+	var logs bytes.Buffer
+	var records []FrameRecord
+	var err error
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:                       "tcp://device",
+		Timeout:                   100 * time.Millisecond,
+		FrameHistory:              3,
+		DumpFramesOnProtocolError: true,
+		Logger:                    log.New(&logs, "", 0),
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			go func() {
+				st := newTCPTransport(p1, 1*time.Second, nil)
+				for {
+					req, err := st.ReadRequest()
+					if err != nil {
+						return
+					}
+					res := &pdu{
+						unitId:       req.unitId,
+						functionCode: req.functionCode,
+						payload:      []byte{0x02, 0x00, req.payload[1]},
+					}
+					// answer reads at address 0xff with a malformed response
+					if req.payload[1] == 0xff {
+						res.payload = []byte{0x04, 0x00}
+					}
+					st.WriteResponse(res)
+				}
+			}()
+			return p2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if len(client.RecentFrames()) != 0 {
+		t.Errorf("RecentFrames() should be empty before any request")
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	for _, addr := range []uint16{0x01, 0x02} {
+		_, err = client.ReadRegister(addr, HOLDING_REGISTER)
+		if err != nil {
+			t.Fatalf("ReadRegister() should have succeeded, got: %v", err)
+		}
+	}
+
+	// only the 3 most recent frames should be kept, oldest first
+	records = client.WithUnitId(2).RecentFrames()
+	if len(records) != 3 {
+		t.Fatalf("expected 3 frames, got: %v", len(records))
+	}
+	for i, expected := range []struct {
+		direction Direction
+		frame     []byte
+	}{
+		{DIRECTION_RX, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xff, 0x03, 0x02, 0x00, 0x01}},
+		{DIRECTION_TX, []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0xff, 0x03, 0x00, 0x02, 0x00, 0x01}},
+		{DIRECTION_RX, []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x05, 0xff, 0x03, 0x02, 0x00, 0x02}},
+	} {
+		if records[i].Direction != expected.direction ||
+			!bytes.Equal(records[i].Frame, expected.frame) {
+			t.Errorf("unexpected frame #%v: %v % x", i, records[i].Direction,
+				records[i].Frame)
+		}
+	}
+	if records[0].Time.After(records[2].Time) {
+		t.Errorf("frames should be ordered oldest first")
+	}
+
+	// protocol errors should dump the history to the logger
+	_, err = client.ReadRegister(0xff, HOLDING_REGISTER)
+	if err != ErrProtocol {
+		t.Fatalf("expected ErrProtocol, got: %v", err)
+	}
+	if !strings.Contains(logs.String(), "dumping 3 recent frames") ||
+		!strings.Contains(logs.String(), "rx txn=0x0003 proto=0x0000 len=4 unit=0xff "+
+			"fc=0x03 (Read Holding Registers) data=04 00") {
+		t.Errorf("unexpected logs: %s", logs.String())
+	}
+}
+
+func TestTCPTransportErrorClassification(t *testing.T) {
+	var tt *tcpTransport
+	var p1, p2 net.Conn
+	var err error
+	var req *pdu = &pdu{
+		unitId:       0x01,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x00, 0x00, 0x01},
+	}
+
+	p1, p2 = net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tt = newTCPTransport(p2, 100*time.Millisecond, nil)
+
+	// unanswered requests should time out
+	go io.ReadFull(p1, make([]byte, 12))
+	_, err = tt.ExecuteRequest(req)
+	if !errors.Is(err, ErrRequestTimedOut) || errors.Is(err, ErrConnClosed) {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// malformed responses should yield protocol errors
+	go func() {
+		io.ReadFull(p1, make([]byte, 12))
+		p1.Write([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+	}()
+	_, err = tt.ExecuteRequest(req)
+	if err != ErrProtocol {
+		t.Errorf("expected ErrProtocol, got: %v", err)
+	}
+
+	// connections closed by the peer should be reported as such, keeping
+	// the underlying error
+	go func() {
+		io.ReadFull(p1, make([]byte, 12))
+		p1.Close()
+	}()
+	_, err = tt.ExecuteRequest(req)
+	if !errors.Is(err, ErrConnClosed) || !errors.Is(err, io.EOF) ||
+		errors.Is(err, ErrRequestTimedOut) {
+		t.Errorf("expected ErrConnClosed wrapping io.EOF, got: %v", err)
+	}
+
+	// so should writes to a closed connection
+	_, err = tt.ExecuteRequest(req)
+	if !errors.Is(err, ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed, got: %v", err)
+	}
+}
+
+func TestClientTimeoutErrors(t *testing.T) {
+	var client *ModbusClient
+	var netErr net.Error
+	var err error
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://device",
+		Timeout: 50 * time.Millisecond,
+		Dial: func() (net.Conn, error) {
+			p1, p2 := net.Pipe()
+			// play the role of a server which never answers
+			go io.Copy(io.Discard, p1)
+			return p2, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// timeouts should match ErrRequestTimedOut while keeping the transport
+	// error, and the underlying i/o error it wraps, reachable
+	_, err = client.ReadRegisters(0, 1, HOLDING_REGISTER)
+	if !errors.Is(err, ErrRequestTimedOut) {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a net.Error reporting a timeout, got: %v", err)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the underlying i/o error to be wrapped, got: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"net"
@@ -32,6 +33,52 @@ func LoadCertPool(filePath string) (*x509.CertPool, error) {
 	return cp, nil
 }
 
+// DialTLS connects to addr over TCP and performs the TLS handshake, as per the
+// MODBUS/TCP Security spec. The dial and handshake must complete within
+// timeout (if non-zero).
+// TLS 1.2 is used as minimum version should cfg specify a lower one
+// (see R-01 of the MBAPS spec).
+// The peer certificate chain, e.g. to check the role extension of the remote
+// end, is available through the ConnectionState() method of the returned
+// connection.
+func DialTLS(addr string, cfg *tls.Config, timeout time.Duration) (*tls.Conn, error) {
+	return dialTLS(&net.Dialer{Timeout: timeout}, addr, cfg)
+}
+
+// Connects to addr over TCP with dialer and performs the TLS handshake, both
+// within the timeout of dialer (if non-zero).
+func dialTLS(dialer *net.Dialer, addr string, cfg *tls.Config) (*tls.Conn, error) {
+	var timeout time.Duration = dialer.Timeout
+
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	sock, err := tls.DialWithDialer(dialer, "tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// force the TLS handshake
+	if timeout > 0 {
+		sock.SetDeadline(time.Now().Add(timeout))
+	}
+	err = sock.Handshake()
+	if err != nil {
+		sock.Close()
+		return nil, err
+	}
+	sock.SetDeadline(time.Time{})
+
+	return sock, nil
+}
+
 // tlsSockWrapper wraps a TLS socket to work around odd error handling in
 // TLSConn on internal connection state corruption.
 // tlsSockWrapper implements the net.Conn interface to allow its
@@ -91,3 +138,12 @@ func (tsw *tlsSockWrapper) LocalAddr() net.Addr {
 func (tsw *tlsSockWrapper) RemoteAddr() net.Addr {
 	return tsw.sock.RemoteAddr()
 }
+
+// Returns the certificate chain presented by the peer, if any.
+func (tsw *tlsSockWrapper) peerCertificates() []*x509.Certificate {
+	if tc, ok := tsw.sock.(*tls.Conn); ok {
+		return tc.ConnectionState().PeerCertificates
+	}
+
+	return nil
+}
@@ -1,14 +1,24 @@
 package modbus
 
+import (
+	"context"
+	"sync"
+	"time"
+)
+
 type transportType uint
 
 const (
-	modbusRTU        transportType = 1
-	modbusRTUOverTCP transportType = 2
-	modbusRTUOverUDP transportType = 3
-	modbusTCP        transportType = 4
-	modbusTCPOverTLS transportType = 5
-	modbusTCPOverUDP transportType = 6
+	modbusRTU          transportType = 1
+	modbusRTUOverTCP   transportType = 2
+	modbusRTUOverUDP   transportType = 3
+	modbusTCP          transportType = 4
+	modbusTCPOverTLS   transportType = 5
+	modbusTCPOverUDP   transportType = 6
+	modbusASCII        transportType = 7
+	modbusASCIIOverTCP transportType = 8
+	modbusUnitHandle   transportType = 9
+	modbusMock         transportType = 10
 )
 
 type transport interface {
@@ -17,3 +27,75 @@ type transport interface {
 	ReadRequest() (*pdu, error)
 	WriteResponse(*pdu) error
 }
+
+// Implemented by transports able to abort in-flight requests when a context
+// is done.
+type contextTransport interface {
+	ExecuteRequestContext(context.Context, *pdu) (*pdu, error)
+}
+
+// Implemented by transports able to abort reads of incoming requests.
+type contextRequestReader interface {
+	ReadRequestContext(context.Context) (*pdu, error)
+}
+
+// Implemented by transports supporting changes of request timeouts at
+// runtime.
+type timeoutTransport interface {
+	setTimeout(timeout time.Duration)
+	setUnitTimeout(unitId uint8, timeout time.Duration)
+}
+
+// Request timeouts (default value and per unit id overrides), meant to be
+// embedded in transports. Safe for concurrent use, letting timeouts be
+// changed while requests are in flight.
+type timeouts struct {
+	lock      sync.RWMutex
+	timeout   time.Duration
+	overrides map[uint8]time.Duration
+}
+
+// Returns the default timeout.
+func (ts *timeouts) defaultTimeout() time.Duration {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+
+	return ts.timeout
+}
+
+// Returns the timeout to apply to requests addressed to unitId, i.e. the
+// override set for that unit id if any, or the default timeout.
+func (ts *timeouts) timeoutFor(unitId uint8) time.Duration {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
+
+	if timeout, ok := ts.overrides[unitId]; ok {
+		return timeout
+	}
+
+	return ts.timeout
+}
+
+// Sets the default timeout, effective from the next request on.
+func (ts *timeouts) setTimeout(timeout time.Duration) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	ts.timeout = timeout
+}
+
+// Sets the timeout override for unitId (a timeout of 0 removes the override).
+func (ts *timeouts) setUnitTimeout(unitId uint8, timeout time.Duration) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if timeout == 0 {
+		delete(ts.overrides, unitId)
+		return
+	}
+
+	if ts.overrides == nil {
+		ts.overrides = make(map[uint8]time.Duration)
+	}
+	ts.overrides[unitId] = timeout
+}
@@ -8,19 +8,45 @@ import (
 // udpSockWrapper wraps a net.UDPConn (UDP socket) to
 // allow transports to consume data off the network socket on
 // a byte per byte basis rather than datagram by datagram.
+// A single Read() call never returns bytes from more than one datagram.
+// Datagrams longer than the maximum frame length are rejected rather than
+// truncated.
 type udpSockWrapper struct {
 	leftoverCount int
 	rxbuf         []byte
 	sock          *net.UDPConn
+	// set by startFrame() to confine reads to a single datagram
+	inFrame bool
+	// set once the datagram holding the current frame has been received
+	frameStarted bool
 }
 
-func newUDPSockWrapper(sock net.Conn) *udpSockWrapper {
+// Implemented by datagram sockets, on which frames may not span
+// datagrams.
+type datagramSocket interface {
+	startFrame()
+	setMaxFrameLength(length int)
+}
+
+// Returns a new wrapper around sock, accepting datagrams of up to
+// maxFrameLength bytes.
+func newUDPSockWrapper(sock net.Conn, maxFrameLength int) *udpSockWrapper {
 	return &udpSockWrapper{
-		rxbuf: make([]byte, maxTCPFrameLength),
+		// one extra byte to tell oversized datagrams apart, as the
+		// socket silently truncates datagrams to the size of the buffer
+		rxbuf: make([]byte, maxFrameLength+1),
 		sock:  sock.(*net.UDPConn),
 	}
 }
 
+// Reads bytes off the current datagram, or off the next one if the current
+// datagram has been fully consumed.
+// Reads are short whenever buf is larger than what remains of the current
+// datagram: bytes left over from a previous datagram are returned alone,
+// without topping buf up from the next datagram, so that a single call
+// never merges bytes from distinct datagrams (as allowed by io.Reader).
+// Callers needing a given number of bytes should use io.ReadFull(), which
+// may then span datagrams unless startFrame() was called.
 func (usw *udpSockWrapper) Read(buf []byte) (int, error) {
 	var (
 		copied int
@@ -38,11 +64,24 @@ func (usw *udpSockWrapper) Read(buf []byte) (int, error) {
 		// make a note of how many leftover bytes we have in the buffer
 		usw.leftoverCount -= copied
 	} else {
-		// read up to maxTCPFrameLength bytes from the socket
+		// frames may not span datagrams: refuse to read the next
+		// datagram before the current frame is complete
+		if usw.inFrame && usw.frameStarted {
+			return 0, ErrProtocol
+		}
+
+		// read the next datagram from the socket
 		rlen, err := usw.sock.Read(usw.rxbuf)
 		if err != nil {
 			return 0, err
 		}
+
+		// drop datagrams which do not fit in the buffer rather than
+		// returning a truncated frame
+		if rlen == len(usw.rxbuf) {
+			usw.frameStarted = true
+			return 0, ErrProtocol
+		}
 		// copy as many bytes as possible to satisfy the read
 		copied = copy(buf, usw.rxbuf[0:rlen])
 
@@ -52,11 +91,32 @@ func (usw *udpSockWrapper) Read(buf []byte) (int, error) {
 		}
 		// make a note of how many leftover bytes we have in the buffer
 		usw.leftoverCount = rlen - copied
+		usw.frameStarted = true
 	}
 	rlen = copied
 	return rlen, nil
 }
 
+// Marks the start of a new frame: bytes left over from the previous
+// datagram are discarded, as they cannot be part of the frame, and reads
+// are confined to the next datagram until startFrame() is called again.
+func (usw *udpSockWrapper) startFrame() {
+	usw.leftoverCount = 0
+	usw.inFrame = true
+	usw.frameStarted = false
+}
+
+// Resizes the receive buffer to hold datagrams of up to length bytes.
+func (usw *udpSockWrapper) setMaxFrameLength(length int) {
+	if length+1 == len(usw.rxbuf) {
+		return
+	}
+
+	rxbuf := make([]byte, length+1)
+	usw.leftoverCount = copy(rxbuf, usw.rxbuf[0:usw.leftoverCount])
+	usw.rxbuf = rxbuf
+}
+
 func (usw *udpSockWrapper) Close() error {
 	return usw.sock.Close()
 }
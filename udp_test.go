@@ -1,8 +1,10 @@
 package modbus
 
 import (
+	"io"
 	"net"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -41,7 +43,7 @@ func TestUDPSockWrapper(t *testing.T) {
 	// pushed into txchan over UDP to our test UDP sock wrapper object
 	go feedTestPipe(t, txchan, sock2)
 
-	usw = newUDPSockWrapper(sock1)
+	usw = newUDPSockWrapper(sock1, maxTCPFrameLength)
 	// push a valid RTU response (illegal data address) to the test pipe
 	txchan <- []byte{
 		0x31, 0x82, // unit id and response code
@@ -158,3 +160,300 @@ func TestUDPSockWrapper(t *testing.T) {
 	sock1.Close()
 	sock2.Close()
 }
+
+func TestUDPSockWrapperDatagramBoundaries(t *testing.T) {
+	var tt *tcpTransport
+	var sock1 *net.UDPConn
+	var sock2 *net.UDPConn
+	var txchan chan []byte
+	var res *pdu
+	var err error
+
+	sock1, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on udp socket: %v", err)
+	}
+	defer sock1.Close()
+
+	sock2, err = net.DialUDP("udp", nil, sock1.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("failed to open udp socket: %v", err)
+	}
+	defer sock2.Close()
+
+	txchan = make(chan []byte, 4)
+	go feedTestPipe(t, txchan, sock2)
+
+	tt = newTCPTransport(newUDPSockWrapper(sock1, maxTCPFrameLength), 500*time.Millisecond, nil)
+
+	// push a truncated frame (the length field claims 2 more bytes than
+	// the datagram holds), followed by a valid frame
+	txchan <- []byte{
+		0x00, 0x01, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length
+		0x01, 0x06, // unit id and function code
+		0x00, 0x01, // truncated payload
+	}
+	txchan <- []byte{
+		0x00, 0x02, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x06, // length
+		0x01, 0x06, // unit id and function code
+		0x00, 0x01, 0x12, 0x34, // payload
+	}
+
+	// the truncated frame should not be completed with bytes of the
+	// second datagram
+	_, _, err = tt.readMBAPFrame()
+	if err != ErrProtocol {
+		t.Errorf("readMBAPFrame() should have returned ErrProtocol, got: %v", err)
+	}
+
+	res, _, err = tt.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("readMBAPFrame() should have succeeded, got: %v", err)
+	}
+	if res.txnId != 0x0002 || len(res.payload) != 4 || res.payload[3] != 0x34 {
+		t.Errorf("unexpected frame: %+v", res)
+	}
+
+	// trailing bytes of a datagram should not leak into the next frame
+	txchan <- []byte{
+		0x00, 0x03, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x03, // length
+		0x01, 0x83, // unit id and function code
+		0x02,       // exception code
+		0xaa, 0xbb, // junk
+	}
+	txchan <- []byte{
+		0x00, 0x04, // transaction identifier
+		0x00, 0x00, // protocol identifier
+		0x00, 0x03, // length
+		0x01, 0x83, // unit id and function code
+		0x03, // exception code
+	}
+
+	res, _, err = tt.readMBAPFrame()
+	if err != nil || res.txnId != 0x0003 || res.payload[0] != 0x02 {
+		t.Errorf("unexpected frame: %+v (err: %v)", res, err)
+	}
+
+	res, _, err = tt.readMBAPFrame()
+	if err != nil || res.txnId != 0x0004 || res.payload[0] != 0x03 {
+		t.Errorf("unexpected frame: %+v (err: %v)", res, err)
+	}
+}
+
+func TestUDPSockWrapperShortReads(t *testing.T) {
+	var usw *udpSockWrapper
+	var sock1 *net.UDPConn
+	var sock2 *net.UDPConn
+	var txchan chan []byte
+	var rxbuf []byte
+	var count int
+	var err error
+
+	sock1, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on udp socket: %v", err)
+	}
+	defer sock1.Close()
+
+	err = sock1.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set deadline on udp socket: %v", err)
+	}
+
+	sock2, err = net.DialUDP("udp", nil, sock1.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("failed to open udp socket: %v", err)
+	}
+	defer sock2.Close()
+
+	txchan = make(chan []byte, 2)
+	go feedTestPipe(t, txchan, sock2)
+
+	usw = newUDPSockWrapper(sock1, maxTCPFrameLength)
+
+	txchan <- []byte{0x01, 0x02, 0x03, 0x04}
+	txchan <- []byte{0x05, 0x06, 0x07}
+
+	// consume part of the first datagram
+	rxbuf = make([]byte, 3)
+	count, err = usw.Read(rxbuf)
+	if err != nil || count != 3 {
+		t.Fatalf("usw.Read() should have returned 3 bytes, got: %v (err: %v)", count, err)
+	}
+
+	// leftovers smaller than the buffer should be returned alone, without
+	// bytes of the second datagram
+	rxbuf = make([]byte, 16)
+	count, err = usw.Read(rxbuf)
+	if err != nil {
+		t.Errorf("usw.Read() should have succeeded, got: %v", err)
+	}
+	if count != 1 || rxbuf[0] != 0x04 {
+		t.Errorf("expected {0x04}, got: % x", rxbuf[0:count])
+	}
+
+	// the next read should return the second datagram
+	count, err = usw.Read(rxbuf)
+	if err != nil {
+		t.Errorf("usw.Read() should have succeeded, got: %v", err)
+	}
+	if count != 3 || rxbuf[0] != 0x05 || rxbuf[2] != 0x07 {
+		t.Errorf("expected {0x05, 0x06, 0x07}, got: % x", rxbuf[0:count])
+	}
+
+	// io.ReadFull() should drain leftovers then span datagrams
+	txchan <- []byte{0x08, 0x09}
+	txchan <- []byte{0x0a, 0x0b}
+
+	rxbuf = make([]byte, 1)
+	_, err = usw.Read(rxbuf)
+	if err != nil || rxbuf[0] != 0x08 {
+		t.Fatalf("usw.Read() should have returned 0x08, got: % x (err: %v)", rxbuf, err)
+	}
+
+	rxbuf = make([]byte, 3)
+	count, err = io.ReadFull(usw, rxbuf)
+	if err != nil {
+		t.Errorf("io.ReadFull() should have succeeded, got: %v", err)
+	}
+	if count != 3 || rxbuf[0] != 0x09 || rxbuf[1] != 0x0a || rxbuf[2] != 0x0b {
+		t.Errorf("expected {0x09, 0x0a, 0x0b}, got: % x", rxbuf[0:count])
+	}
+}
+
+func TestUDPRetransmits(t *testing.T) {
+	var client *ModbusClient
+	var pc net.PacketConn
+	var err error
+	var txnIds []uint16
+	var lock sync.Mutex
+
+	pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer pc.Close()
+
+	// drop the first datagram of each transaction, answer the second one
+	// twice to simulate late duplicates
+	go func() {
+		var buf [260]byte
+
+		for {
+			n, addr, err := pc.ReadFrom(buf[:])
+			if err != nil {
+				return
+			}
+
+			lock.Lock()
+			txnId := bytesToUint16(BIG_ENDIAN, buf[0:2])
+			txnIds = append(txnIds, txnId)
+			seen := len(txnIds)
+			lock.Unlock()
+
+			if n < 12 || seen%2 == 1 {
+				continue
+			}
+
+			res := EncodeMBAP(txnId, &PDU{
+				UnitId:       buf[6],
+				FunctionCode: buf[7],
+				Payload:      []byte{0x02, 0x00, uint8(txnId)},
+			})
+			pc.WriteTo(res, addr)
+			pc.WriteTo(res, addr)
+		}
+	}()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:            "udp://" + pc.LocalAddr().String(),
+		Timeout:        600 * time.Millisecond,
+		UDPRetransmits: 2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	for i := 1; i <= 2; i++ {
+		reg, err := client.ReadRegister(0, HOLDING_REGISTER)
+		if err != nil {
+			t.Fatalf("ReadRegister() should have succeeded, got %v", err)
+		}
+		if reg != uint16(i) {
+			t.Errorf("expected 0x%04x, got 0x%04x", i, reg)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(txnIds) != 4 ||
+		txnIds[0] != 1 || txnIds[1] != 1 || txnIds[2] != 2 || txnIds[3] != 2 {
+		t.Errorf("unexpected transaction ids: %v", txnIds)
+	}
+}
+
+func TestUDPSockWrapperOversizedDatagrams(t *testing.T) {
+	var usw *udpSockWrapper
+	var sock1 *net.UDPConn
+	var sock2 *net.UDPConn
+	var rxbuf []byte = make([]byte, 32)
+	var count int
+	var err error
+
+	sock1, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on udp socket: %v", err)
+	}
+	defer sock1.Close()
+
+	err = sock1.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if err != nil {
+		t.Fatalf("failed to set deadline on udp socket: %v", err)
+	}
+
+	sock2, err = net.DialUDP("udp", nil, sock1.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("failed to open udp socket: %v", err)
+	}
+	defer sock2.Close()
+
+	usw = newUDPSockWrapper(sock1, 8)
+
+	sock2.Write([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09})
+	sock2.Write([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08})
+	sock2.Write([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a})
+
+	// datagrams longer than the buffer should be rejected, not truncated
+	usw.startFrame()
+	count, err = usw.Read(rxbuf)
+	if err != ErrProtocol || count != 0 {
+		t.Errorf("expected ErrProtocol, got: %v (%v bytes)", err, count)
+	}
+
+	// datagrams of exactly the max frame length should go through
+	usw.startFrame()
+	count, err = usw.Read(rxbuf)
+	if err != nil || count != 8 {
+		t.Errorf("usw.Read() should have returned 8 bytes, got: %v (err: %v)", count, err)
+	}
+
+	// and so should longer datagrams once the buffer is resized
+	usw.setMaxFrameLength(16)
+	usw.startFrame()
+	count, err = usw.Read(rxbuf)
+	if err != nil || count != 10 {
+		t.Errorf("usw.Read() should have returned 10 bytes, got: %v (err: %v)", count, err)
+	}
+}